@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"musicloud/internal/library"
+	"musicloud/internal/metadata"
+	"musicloud/internal/parser"
+)
+
+// runImport runs "musicloud import": it matches the rows of a spreadsheet to
+// uploaded and local recordings, shows the matches and, with -apply, gives
+// the recordings the rows' metadata.
+func runImport(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dir := fs.String("dir", "", "Folder of recordings not uploaded yet to match as well")
+	dateFormat := fs.String("date-format", os.Getenv("MUSICLOUD_DATE_FORMAT"), "Format of dates written with slashes: dd/mm/yyyy or mm/dd/yyyy")
+	apply := fs.Bool("apply", false, "Apply the metadata; without it the matches are only shown")
+	retag := fs.Bool("retag", false, "Also rewrite the tags embedded in uploaded files, as new revisions")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: musicloud import [-dir folder] [-date-format dd/mm/yyyy] [-apply] sheet.csv")
+	}
+	var order parser.DateOrder
+	if *dateFormat != "" {
+		var err error
+		if order, err = parser.ParseDateOrder(*dateFormat); err != nil {
+			return err
+		}
+	}
+
+	sheet := fs.Arg(0)
+	f, err := os.Open(sheet)
+	if err != nil {
+		return err
+	}
+	rows, ignored, err := library.ReadImport(f, strings.EqualFold(filepath.Ext(sheet), ".tsv"), order)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", sheet, err)
+	}
+	if len(ignored) > 0 {
+		fmt.Fprintf(out, "Ignoring columns: %s\n", strings.Join(ignored, ", "))
+	}
+
+	lib, err := library.Load(libraryPath())
+	if err != nil {
+		return err
+	}
+	candidates, err := library.Candidates(lib, *dir, func(path string) bool { return parser.MediaKind(path) != "other" })
+	if err != nil {
+		return err
+	}
+	rec := library.Reconcile(rows, candidates)
+
+	editor, err := newEditor(*retag)
+	if err != nil {
+		return err
+	}
+	if *apply && hasUploads(rec) {
+		if editor.Remote, editor.Root, err = openRemote(); err != nil {
+			return err
+		}
+	}
+	failed := 0
+	for _, m := range rec.Matches {
+		for _, c := range m.Recordings {
+			if err := importInto(editor, m, c, *apply, out); err != nil {
+				fmt.Fprintf(out, "line %d (%s): %v\n", m.Row.Line, c, err)
+				failed++
+			}
+		}
+		if *apply {
+			if err := lib.Save(); err != nil {
+				return err
+			}
+		}
+	}
+
+	reportPath := filepath.Join(getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), "import-report.md")
+	if err := os.MkdirAll(filepath.Dir(reportPath), 0755); err != nil {
+		return err
+	}
+	report, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	defer report.Close()
+	if err := rec.WriteReport(report); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d rows matched, %d rows unmatched, %d recordings in conflict, %d recordings unmatched; see %s\n",
+		len(rec.Matches), len(rec.UnmatchedRows), len(rec.Conflicts), len(rec.UnmatchedRecordings), reportPath)
+	if !*apply {
+		fmt.Fprintln(out, "Nothing was changed; run again with -apply to apply the metadata.")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d recordings could not be updated", failed)
+	}
+	return nil
+}
+
+func hasUploads(rec library.Reconciliation) bool {
+	for _, m := range rec.Matches {
+		for _, c := range m.Recordings {
+			if c.Recording != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// importInto shows, and with apply makes, the change a row makes to one
+// recording: uploaded recordings are edited in the library and in Drive,
+// local ones get the metadata in their sidecar file for the upload.
+func importInto(editor *library.Editor, m library.Match, c *library.Candidate, apply bool, out io.Writer) error {
+	if c.Recording != nil {
+		next := m.Metadata(c.Recording.Metadata)
+		var edit library.Edit
+		var err error
+		if apply {
+			edit, err = editor.Apply(c.Recording, next)
+		} else {
+			edit, err = editor.Plan(c.Recording, next)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "line %d (by %s): %s\n", m.Row.Line, m.By, edit)
+		return nil
+	}
+
+	current, _, err := metadata.Sidecar(c.Path)
+	if err != nil {
+		return err
+	}
+	next := m.Metadata(current)
+	fmt.Fprintf(out, "line %d (by %s): %s\n", m.Row.Line, m.By, c.Path)
+	for _, d := range metadata.Diff(current, next) {
+		fmt.Fprintf(out, "  %s\n", d)
+	}
+	if !apply {
+		return nil
+	}
+	return metadata.WriteSidecar(c.Path, next)
+}
@@ -1,25 +1,53 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"musicloud/config"
 	"musicloud/internal/drive"
+	"musicloud/internal/ffmpeg"
+	"musicloud/internal/library"
+	"musicloud/internal/metadata"
+	"musicloud/internal/organizer"
+	"musicloud/internal/parser"
+	"musicloud/internal/prompt"
+	"musicloud/internal/recdate"
+	"musicloud/internal/review"
 	"musicloud/internal/watcher"
-	"context"
+	"musicloud/internal/zoom"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func printHelp() {
 	fmt.Println(`WhatsApp Music Uploader
 Usage:
   musicloud [options]
+  musicloud meta edit [-set field=value]... [-retag] [-dry-run] <id|path>
+  musicloud meta apply [-retag] [-dry-run] changes.csv
+  musicloud import [-dir folder] [-date-format dd/mm/yyyy] [-apply] [-retag] sheet.csv
+  musicloud reorganize [-apply | -undo]
 
 Options:
   -dir string
         Path to the folder to monitor for WhatsApp exports (default: ./watched or $MUSICLOUD_WATCH_FOLDER)
   -help
         Show this help message and exit
+  -interactive
+        Ask for the metadata of each new recording before uploading it
+
+Metadata options (override folder defaults, chat-derived values and sidecar files):
+  -group string          Group name
+  -teacher string        Teacher
+  -session-type string   Session type: in-person or virtual
+  -songs string          Songs taught, comma-separated
+  -ragas string          Ragas, comma-separated
+  -talas string          Talas, comma-separated
+  -composers string      Composers, comma-separated
 
 Environment variables:
   MUSICLOUD_WATCH_FOLDER              Folder to watch for new WhatsApp exports
@@ -27,7 +55,18 @@ Environment variables:
   MUSICLOUD_GOOGLE_DRIVE_FOLDER_NAME  Google Drive folder name (used if ID is not set; will be created if missing)
   MUSICLOUD_FFMPEG_PATH               Path to ffmpeg binary
   MUSICLOUD_CONFIG                    Path to Google API credentials JSON file (required)
-  MUSICLOUD_OAUTH_TOKEN               OAuth token (managed automatically; not required)`)
+  MUSICLOUD_OAUTH_TOKEN               OAuth token (managed automatically; not required)
+  MUSICLOUD_STATE_DIR                 Folder for local processing state (default: ./.musicloud)
+  MUSICLOUD_GROUPS_FILE               Group configuration (YAML) mapping chat senders to people and roles
+  MUSICLOUD_DATE_FORMAT               Date format of chat exports: dd/mm/yy, mm/dd/yy or yyyy-mm-dd (default: detected)
+  MUSICLOUD_TIMEZONE                  Time zone of chat timestamps, e.g. Asia/Kolkata (default: local time)
+  MUSICLOUD_ZOOM_RECORDING            Track to upload from Zoom recording folders: audio or video (default: audio)
+  MUSICLOUD_COVER_IMAGE               JPEG or PNG embedded as cover art in uploaded audio files
+  MUSICLOUD_COMPOSITIONS_FILE         CSV or JSON file adding to the built-in composition catalog
+  MUSICLOUD_DATE_SOURCES              Order in which recording dates are looked for (default: chat,filename,creation_time,mtime)
+  MUSICLOUD_FOLDER_LAYOUT             Folder layout template, e.g. {{.Year}}/{{.Group}}/{{.Date}} (default: upload folder)
+  MUSICLOUD_FILE_NAME                 File name template, e.g. {{.Date}}_{{.Group}}_{{first .Ragas}}.{{.Ext}} (default: keep names)
+  MUSICLOUD_BROWSE_VIEWS              Browse views of shortcuts to keep: raga, composer, tala or none (default: raga,composer,tala)`)
 	fmt.Println("\nEnvironment variable summary:")
 	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "Variable", "Current Value", "Default", "Effective (used)")
 	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_WATCH_FOLDER", os.Getenv("MUSICLOUD_WATCH_FOLDER"), "./watched", getEnvWithDefault("MUSICLOUD_WATCH_FOLDER", "./watched"))
@@ -35,6 +74,17 @@ Environment variables:
 	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_GOOGLE_DRIVE_FOLDER_NAME", os.Getenv("MUSICLOUD_GOOGLE_DRIVE_FOLDER_NAME"), "Recordings", getEnvWithDefault("MUSICLOUD_GOOGLE_DRIVE_FOLDER_NAME", "Recordings"))
 	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_FFMPEG_PATH", os.Getenv("MUSICLOUD_FFMPEG_PATH"), "ffmpeg", getEnvWithDefault("MUSICLOUD_FFMPEG_PATH", "ffmpeg"))
 	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_OAUTH_TOKEN", os.Getenv("MUSICLOUD_OAUTH_TOKEN"), "", getEnvWithDefault("MUSICLOUD_OAUTH_TOKEN", ""))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_STATE_DIR", os.Getenv("MUSICLOUD_STATE_DIR"), ".musicloud", getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_GROUPS_FILE", os.Getenv("MUSICLOUD_GROUPS_FILE"), "", getEnvWithDefault("MUSICLOUD_GROUPS_FILE", ""))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_DATE_FORMAT", os.Getenv("MUSICLOUD_DATE_FORMAT"), "", getEnvWithDefault("MUSICLOUD_DATE_FORMAT", ""))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_TIMEZONE", os.Getenv("MUSICLOUD_TIMEZONE"), "Local", getEnvWithDefault("MUSICLOUD_TIMEZONE", "Local"))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_ZOOM_RECORDING", os.Getenv("MUSICLOUD_ZOOM_RECORDING"), "audio", getEnvWithDefault("MUSICLOUD_ZOOM_RECORDING", "audio"))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_COVER_IMAGE", os.Getenv("MUSICLOUD_COVER_IMAGE"), "", getEnvWithDefault("MUSICLOUD_COVER_IMAGE", ""))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_COMPOSITIONS_FILE", os.Getenv("MUSICLOUD_COMPOSITIONS_FILE"), "", getEnvWithDefault("MUSICLOUD_COMPOSITIONS_FILE", ""))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_DATE_SOURCES", os.Getenv("MUSICLOUD_DATE_SOURCES"), "chat,filename,creation_time,mtime", getEnvWithDefault("MUSICLOUD_DATE_SOURCES", "chat,filename,creation_time,mtime"))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_FOLDER_LAYOUT", os.Getenv("MUSICLOUD_FOLDER_LAYOUT"), "", getEnvWithDefault("MUSICLOUD_FOLDER_LAYOUT", ""))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_FILE_NAME", os.Getenv("MUSICLOUD_FILE_NAME"), "", getEnvWithDefault("MUSICLOUD_FILE_NAME", ""))
+	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_BROWSE_VIEWS", os.Getenv("MUSICLOUD_BROWSE_VIEWS"), "raga,composer,tala", getEnvWithDefault("MUSICLOUD_BROWSE_VIEWS", "raga,composer,tala"))
 	fmt.Printf("  %-32s %-20q %-20q %-20q\n", "MUSICLOUD_CONFIG", os.Getenv("MUSICLOUD_CONFIG"), "(required)", os.Getenv("MUSICLOUD_CONFIG"))
 }
 
@@ -45,6 +95,17 @@ func printConfig() {
 	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_GOOGLE_DRIVE_FOLDER_NAME:", getEnvWithDefault("MUSICLOUD_GOOGLE_DRIVE_FOLDER_NAME", "Recordings"), "Recordings")
 	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_FFMPEG_PATH:", getEnvWithDefault("MUSICLOUD_FFMPEG_PATH", "ffmpeg"), "ffmpeg")
 	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_OAUTH_TOKEN:", getEnvWithDefault("MUSICLOUD_OAUTH_TOKEN", ""), "empty")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_STATE_DIR:", getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), ".musicloud")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_GROUPS_FILE:", getEnvWithDefault("MUSICLOUD_GROUPS_FILE", ""), "empty")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_DATE_FORMAT:", getEnvWithDefault("MUSICLOUD_DATE_FORMAT", ""), "detected")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_TIMEZONE:", getEnvWithDefault("MUSICLOUD_TIMEZONE", "Local"), "Local")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_ZOOM_RECORDING:", getEnvWithDefault("MUSICLOUD_ZOOM_RECORDING", "audio"), "audio")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_COVER_IMAGE:", getEnvWithDefault("MUSICLOUD_COVER_IMAGE", ""), "empty")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_COMPOSITIONS_FILE:", getEnvWithDefault("MUSICLOUD_COMPOSITIONS_FILE", ""), "empty")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_DATE_SOURCES:", getEnvWithDefault("MUSICLOUD_DATE_SOURCES", "chat,filename,creation_time,mtime"), "chat,filename,creation_time,mtime")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_FOLDER_LAYOUT:", getEnvWithDefault("MUSICLOUD_FOLDER_LAYOUT", ""), "empty")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_FILE_NAME:", getEnvWithDefault("MUSICLOUD_FILE_NAME", ""), "empty")
+	fmt.Printf("  %-30s %s (default: %s)\n", "MUSICLOUD_BROWSE_VIEWS:", getEnvWithDefault("MUSICLOUD_BROWSE_VIEWS", "raga,composer,tala"), "raga,composer,tala")
 	fmt.Printf("  %-30s %s (required)\n", "MUSICLOUD_CONFIG:", os.Getenv("MUSICLOUD_CONFIG"))
 	fmt.Println()
 }
@@ -58,8 +119,35 @@ func getEnvWithDefault(key, def string) string {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "meta" {
+		if err := runMeta(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reorganize" {
+		if err := runReorganize(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	help := flag.Bool("help", false, "Show help")
 	dir := flag.String("dir", os.Getenv("MUSICLOUD_WATCH_FOLDER"), "Path to folder to scan")
+	group := flag.String("group", "", "Group name")
+	teacher := flag.String("teacher", "", "Teacher")
+	sessionType := flag.String("session-type", "", "Session type: in-person or virtual")
+	songs := flag.String("songs", "", "Songs taught, comma-separated")
+	ragas := flag.String("ragas", "", "Ragas, comma-separated")
+	talas := flag.String("talas", "", "Talas, comma-separated")
+	composers := flag.String("composers", "", "Composers, comma-separated")
+	interactive := flag.Bool("interactive", false, "Ask for the metadata of each new recording before uploading it")
 	flag.Parse()
 
 	if *help {
@@ -73,17 +161,194 @@ func main() {
 		*dir = "./watched"
 	}
 
+	st, err := metadata.NormalizeSessionType(*sessionType)
+	if err != nil {
+		log.Fatalf("Invalid -session-type: %v", err)
+	}
+	overrides := metadata.Metadata{
+		GroupName:   *group,
+		Teacher:     *teacher,
+		SessionType: st,
+		SongsTaught: splitList(*songs),
+		Ragas:       splitList(*ragas),
+		Talas:       splitList(*talas),
+		Composers:   splitList(*composers),
+	}
+
 	if _, err := os.Stat(*dir); os.IsNotExist(err) {
 		log.Fatalf("The folder to scan ('%s') does not exist. Please create it or specify a valid path using -dir or MUSICLOUD_WATCH_FOLDER.", *dir)
 	}
 
-	// Initialize Google Drive service
+	folderID, err := connectDrive()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Every upload is recorded, so its metadata can be edited later
+	lib, err := library.Load(libraryPath())
+	if err != nil {
+		log.Fatalf("Failed to load library: %v", err)
+	}
+
+	// Chat exports are processed incrementally; remember what earlier runs uploaded
+	state, err := parser.LoadState(filepath.Join(getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), "chats.json"))
+	if err != nil {
+		log.Fatalf("Failed to load chat state: %v", err)
+	}
+
+	// How chat export timestamps are read
+	var chatOptions parser.Options
+	if format := os.Getenv("MUSICLOUD_DATE_FORMAT"); format != "" {
+		chatOptions.DateOrder, err = parser.ParseDateOrder(format)
+		if err != nil {
+			log.Fatalf("Invalid MUSICLOUD_DATE_FORMAT: %v", err)
+		}
+	}
+	chatOptions.Location, err = time.LoadLocation(getEnvWithDefault("MUSICLOUD_TIMEZONE", "Local"))
+	if err != nil {
+		log.Fatalf("Invalid MUSICLOUD_TIMEZONE: %v", err)
+	}
+
+	// Where recording dates are looked for when no date is given
+	dateChain, err := recdate.ParseChain(os.Getenv("MUSICLOUD_DATE_SOURCES"))
+	if err != nil {
+		log.Fatalf("Invalid MUSICLOUD_DATE_SOURCES: %v", err)
+	}
+	dates := &recdate.Resolver{Chain: dateChain, Location: chatOptions.Location, Probe: ffmpeg.CreationTime}
+
+	zoomPolicy, err := zoom.ParsePolicy(os.Getenv("MUSICLOUD_ZOOM_RECORDING"))
+	if err != nil {
+		log.Fatalf("Invalid MUSICLOUD_ZOOM_RECORDING: %v", err)
+	}
+
+	// Optional group configuration mapping chat senders to people and roles
+	groups, err := loadGroups()
+	if err != nil {
+		log.Fatalf("Failed to load group configuration: %v", err)
+	}
+	layouts, err := organizer.NewLayouts(groups, os.Getenv("MUSICLOUD_FOLDER_LAYOUT"), os.Getenv("MUSICLOUD_FILE_NAME"))
+	if err != nil {
+		log.Fatalf("Invalid folder layout: %v", err)
+	}
+	views, err := organizer.ParseViews(os.Getenv("MUSICLOUD_BROWSE_VIEWS"))
+	if err != nil {
+		log.Fatalf("Invalid MUSICLOUD_BROWSE_VIEWS: %v", err)
+	}
+
+	// Built-in composition catalog, extended by the user's own file
+	compositions := metadata.NewCompositions()
+	if path := os.Getenv("MUSICLOUD_COMPOSITIONS_FILE"); path != "" {
+		if err := compositions.LoadFile(path); err != nil {
+			log.Fatalf("Failed to load compositions: %v", err)
+		}
+	}
+
+	// Run the scan-and-upload batch process, always passing a valid folderID
+	uploader := func(filePath, _ string) error {
+		return drive.UploadFile(filePath, folderID)
+	}
+	remote, err := drive.NewService()
+	if err != nil {
+		log.Fatal(err)
+	}
+	org := organizer.New(remote, folderID, layouts)
+	org.Views = views
+	upload := func(item watcher.Item) error {
+		if item.Metadata.Duration == 0 {
+			if d, err := ffmpeg.Duration(item.Path); err == nil {
+				item.Metadata.Duration = metadata.Duration(d)
+			}
+		}
+		// Upload straight into the recording's folder, under the name the
+		// name template gives it; folders made for an upload that fails are
+		// removed again.
+		original := filepath.Base(item.Path)
+		name, err := layouts.Name(item.Metadata, original)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			name = original
+		}
+		var id string
+		target, err := org.Within(item.Folder, func(parent string) error {
+			unique, err := drive.UniqueName(remote, parent, name, "")
+			if err != nil {
+				return err
+			}
+			name = unique
+			id, err = drive.UploadFileAs(item.Path, name, parent, &item.Metadata)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		rec, recErr := library.NewRecording(item.Original, item.Metadata)
+		if recErr != nil {
+			log.Printf("Not recording %s in the library: %v", item.Path, recErr)
+		}
+		if err := org.Record(target, organizer.Entry{FileID: id, SHA256: rec.SHA256, Metadata: item.Metadata}); err != nil {
+			log.Printf("Failed to update the session manifest of %s: %v", item.Folder, err)
+		}
+		if err := org.Browse(id, item.Metadata); err != nil {
+			log.Printf("Failed to add %s to the browse views: %v", item.Path, err)
+		}
+		if recErr != nil {
+			return nil
+		}
+		rec.DriveID, rec.Name, rec.Folder, rec.FolderID = id, name, item.Folder, target
+		if name != original {
+			rec.Original = original
+		}
+		lib.Add(rec)
+		if err := lib.Save(); err != nil {
+			log.Printf("Failed to save library: %v", err)
+		}
+		return nil
+	}
+	report := &review.Report{}
+	batch := &watcher.Batch{Dir: *dir, Uploader: uploader, Upload: upload, State: state, ChatOptions: chatOptions, ZoomPolicy: zoomPolicy, Overrides: overrides, Groups: groups, Review: report, Cover: os.Getenv("MUSICLOUD_COVER_IMAGE"), Compositions: compositions, Dates: dates, Layouts: layouts}
+	if *interactive {
+		history, err := prompt.LoadHistory(filepath.Join(getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), "history.json"))
+		if err != nil {
+			log.Fatalf("Failed to load metadata history: %v", err)
+		}
+		session := prompt.NewSession(os.Stdin, os.Stdout, history)
+		session.Preview = prompt.FFplayPreview(15)
+		batch.Confirm = func(item watcher.Item) (metadata.Metadata, bool, error) {
+			return session.Ask(item.Path, item.Caption, item.Metadata)
+		}
+	}
+	batch.Run()
+
+	if len(batch.Missing) > 0 {
+		missingPath := filepath.Join(getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), "missing-recordings.md")
+		if err := writeMissingReport(missingPath, batch.Missing); err != nil {
+			log.Printf("Failed to write missing recordings report: %v", err)
+		} else {
+			log.Printf("%d recordings were shared but not exported, see %s", len(batch.Missing), missingPath)
+		}
+	}
+
+	if !report.Empty() {
+		reportPath := filepath.Join(getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), "review.md")
+		if err := report.Save(reportPath); err != nil {
+			log.Printf("Failed to write review report: %v", err)
+		} else {
+			log.Printf("Some items need review, see %s", reportPath)
+		}
+	}
+}
+
+// connectDrive initializes the Google Drive service and returns the ID of the
+// upload folder, creating it if it is given by name and missing.
+func connectDrive() (string, error) {
 	creds, err := drive.GetCredentialsFile()
 	if err != nil {
-		log.Fatalf("Google Drive credentials error: %v", err)
+		return "", fmt.Errorf("Google Drive credentials error: %v", err)
 	}
 	if err := drive.InitializeDriveService(context.Background(), creds); err != nil {
-		log.Fatalf("Failed to initialize Google Drive service: %v", err)
+		return "", fmt.Errorf("Failed to initialize Google Drive service: %v", err)
 	}
 
 	// Determine Google Drive folder ID
@@ -93,17 +358,52 @@ func main() {
 		if folderName != "" {
 			id, err := drive.GetOrCreateFolderID(drive.GetDriveService(), folderName)
 			if err != nil {
-				log.Fatalf("Failed to get or create Google Drive folder: %v", err)
+				return "", fmt.Errorf("Failed to get or create Google Drive folder: %v", err)
 			}
 			folderID = id
 		} else {
 			folderID = "root"
 		}
 	}
+	return folderID, nil
+}
 
-	// Run the scan-and-upload batch process, always passing a valid folderID
-	uploader := func(filePath, _ string) error {
-		return drive.UploadFile(filePath, folderID)
+// loadGroups reads the group configuration named by MUSICLOUD_GROUPS_FILE.
+// It returns nil when none is configured.
+func loadGroups() (*config.Groups, error) {
+	path := os.Getenv("MUSICLOUD_GROUPS_FILE")
+	if path == "" {
+		return nil, nil
 	}
-	watcher.ScanAndProcess(*dir, uploader)
-}
\ No newline at end of file
+	return config.LoadGroups(path)
+}
+
+// libraryPath is where the record of uploaded recordings is kept.
+func libraryPath() string {
+	return filepath.Join(getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), "library.json")
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// writeMissingReport saves the list of recordings that are missing from the
+// chat exports for the group admin.
+func writeMissingReport(path string, missing []parser.MissingMedia) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parser.WriteMissingReport(f, missing)
+}
@@ -1,7 +1,165 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/library"
+	"musicloud/internal/metadata"
+)
 
 func TestMainDummy(t *testing.T) {
 	// This is a placeholder to ensure main package is testable
 }
+
+func TestRunMeta_Apply(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MUSICLOUD_STATE_DIR", dir)
+	remote := drive.NewMockRemote()
+	defer func(open func() (drive.Remote, string, error)) { openRemote = open }(openRemote)
+	openRemote = func() (drive.Remote, string, error) { return remote, "root", nil }
+
+	meta := metadata.Metadata{GroupName: "Veena Class", Ragas: []string{"Kalyan"}}
+	f, _ := remote.Create(&gdrive.File{Name: "class.m4a", Parents: []string{"root"}, AppProperties: drive.AppProperties(&meta)}, nil)
+	lib, _ := library.Load(libraryPath())
+	lib.Add(library.Recording{ID: "abc123", DriveID: f.Id, Name: "class.m4a", FolderID: "root", Metadata: meta})
+	lib.Save()
+
+	changes := filepath.Join(dir, "changes.csv")
+	os.WriteFile(changes, []byte("recording,ragas,lesson\nabc123,Kalyani,4\nnope,Todi,\n"), 0644)
+
+	var out bytes.Buffer
+	if err := runMeta([]string{"apply", "-dry-run", changes}, &out); err == nil || !strings.Contains(out.String(), `no recording matches "nope"`) {
+		t.Errorf("expected the unknown recording to fail, got %v\n%s", err, out.String())
+	}
+	if got, _ := remote.Get(f.Id); got.AppProperties["ragas"] != "Kalyan" {
+		t.Error("expected a dry run to leave Drive alone")
+	}
+
+	out.Reset()
+	runMeta([]string{"apply", changes}, &out)
+	if got, _ := remote.Get(f.Id); got.AppProperties["ragas"] != "Kalyani" || got.AppProperties["lesson"] != "4" {
+		t.Errorf("unexpected Drive properties %v\n%s", got.AppProperties, out.String())
+	}
+	lib, _ = library.Load(libraryPath())
+	if r, _ := lib.Find("abc123"); r.Metadata.Lesson != 4 {
+		t.Errorf("expected the library to be updated, got %+v", r.Metadata)
+	}
+
+	if err := runMeta([]string{"edit", "-set", "tempo=fast", "abc123"}, &out); err == nil {
+		t.Error("expected an unknown field to be rejected")
+	}
+}
+
+func TestRunMeta_RenamesByTemplate(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MUSICLOUD_STATE_DIR", dir)
+	t.Setenv("MUSICLOUD_FILE_NAME", "{{.Group}}_{{first .Ragas}}.{{.Ext}}")
+	t.Setenv("MUSICLOUD_BROWSE_VIEWS", "none")
+	remote := drive.NewMockRemote()
+	defer func(open func() (drive.Remote, string, error)) { openRemote = open }(openRemote)
+	openRemote = func() (drive.Remote, string, error) { return remote, "root", nil }
+
+	meta := metadata.Metadata{GroupName: "Veena Class", Ragas: []string{"Todi"}}
+	named, _ := remote.Create(&gdrive.File{Name: "Veena Class_Todi.opus", Parents: []string{"root"}, AppProperties: map[string]string{drive.OriginalName: "AUD-1.opus"}}, nil)
+	remote.Create(&gdrive.File{Name: "Veena Class_Kalyani.opus", Parents: []string{"root"}}, nil)
+	byHand, _ := remote.Create(&gdrive.File{Name: "Best take.opus", Parents: []string{"root"}}, nil)
+	lib, _ := library.Load(libraryPath())
+	lib.Add(library.Recording{ID: "aaa111", DriveID: named.Id, Name: named.Name, Original: "AUD-1.opus", FolderID: "root", Metadata: meta})
+	lib.Add(library.Recording{ID: "bbb222", DriveID: byHand.Id, Name: byHand.Name, FolderID: "root", Metadata: meta})
+	lib.Save()
+
+	var out bytes.Buffer
+	for _, id := range []string{"aaa111", "bbb222"} {
+		if err := runMeta([]string{"edit", "-set", "ragas=Kalyani", id}, &out); err != nil {
+			t.Fatalf("unexpected error: %v\n%s", err, out.String())
+		}
+	}
+	if got, _ := remote.Get(named.Id); got.Name != "Veena Class_Kalyani_2.opus" || got.AppProperties[drive.OriginalName] != "AUD-1.opus" {
+		t.Errorf("expected a renamed file with a suffix, got %s %v", got.Name, got.AppProperties)
+	}
+	if got, _ := remote.Get(byHand.Id); got.Name != "Best take.opus" {
+		t.Errorf("expected a file renamed by hand to keep its name, got %s", got.Name)
+	}
+	lib, _ = library.Load(libraryPath())
+	if r, _ := lib.Find("aaa111"); r.Name != "Veena Class_Kalyani_2.opus" || r.Original != "AUD-1.opus" {
+		t.Errorf("expected the library to follow, got %+v", r)
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MUSICLOUD_STATE_DIR", filepath.Join(dir, "state"))
+	recordings := filepath.Join(dir, "recordings")
+	os.Mkdir(recordings, 0755)
+	media := filepath.Join(recordings, "AUD-20240305-WA0001.opus")
+	os.WriteFile(media, []byte("audio"), 0644)
+	sheet := filepath.Join(dir, "sheet.tsv")
+	os.WriteFile(sheet, []byte("file\traga\nAUD-20240305-WA0001.opus\tKalyani\nmissing.m4a\tTodi\n"), 0644)
+
+	var out bytes.Buffer
+	if err := runImport([]string{"-dir", recordings, sheet}, &out); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+	if _, ok, _ := metadata.Sidecar(media); ok {
+		t.Error("expected a preview to write no sidecar")
+	}
+	report, err := os.ReadFile(filepath.Join(dir, "state", "import-report.md"))
+	if err != nil || !strings.Contains(string(report), "## Rows Without a Recording") {
+		t.Errorf("unexpected report %s, %v", report, err)
+	}
+
+	if err := runImport([]string{"-dir", recordings, "-apply", sheet}, &out); err != nil {
+		t.Fatalf("unexpected error: %v\n%s", err, out.String())
+	}
+	if m, ok, err := metadata.Sidecar(media); !ok || err != nil || m.Ragas[0] != "Kalyani" {
+		t.Errorf("unexpected sidecar %+v, %v, %v", m, ok, err)
+	}
+}
+
+func TestRunReorganize(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MUSICLOUD_STATE_DIR", dir)
+	t.Setenv("MUSICLOUD_FOLDER_LAYOUT", "{{.Group}}")
+	t.Setenv("MUSICLOUD_FILE_NAME", "")
+	t.Setenv("MUSICLOUD_GROUPS_FILE", "")
+	t.Setenv("MUSICLOUD_BROWSE_VIEWS", "none")
+	remote := drive.NewMockRemote()
+	defer func(open func() (drive.Remote, string, error)) { openRemote = open }(openRemote)
+	openRemote = func() (drive.Remote, string, error) { return remote, "root", nil }
+
+	f, _ := remote.Create(&gdrive.File{Name: "class.opus", Parents: []string{"root"}}, strings.NewReader("audio"))
+	lib, _ := library.Load(libraryPath())
+	lib.Add(library.Recording{ID: "aaa111", DriveID: f.Id, Name: f.Name, FolderID: "root", Metadata: metadata.Metadata{GroupName: "Veena Class"}})
+	lib.Save()
+
+	var out bytes.Buffer
+	if err := runReorganize(nil, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), "- class.opus\n+ Veena Class/class.opus") || remote.Calls["Update"] != 0 {
+		t.Errorf("expected the plan shown and nothing changed, got %s", out.String())
+	}
+	if err := runReorganize([]string{"-apply"}, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := remote.Get(f.Id); got.Parents[0] == "root" || remote.Revisions[f.Id] != 1 {
+		t.Errorf("expected the file moved without a new upload, got %v", got.Parents)
+	}
+	out.Reset()
+	if err := runReorganize(nil, &out); err != nil || !strings.Contains(out.String(), "Every recording is where") {
+		t.Errorf("expected nothing left to do, got %v %s", err, out.String())
+	}
+	if err := runReorganize([]string{"-undo"}, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := remote.Get(f.Id); got.Parents[0] != "root" {
+		t.Errorf("expected the file moved back, got %v", got.Parents)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/library"
+	"musicloud/internal/metadata"
+	"musicloud/internal/organizer"
+)
+
+// openRemote connects to Drive for the meta commands and returns it with the
+// upload folder ID. Tests replace it with a stand-in.
+var openRemote = func() (drive.Remote, string, error) {
+	folderID, err := connectDrive()
+	if err != nil {
+		return nil, "", err
+	}
+	remote, err := drive.NewService()
+	return remote, folderID, err
+}
+
+// newEditor returns the editor of uploaded recordings, which moves and
+// renames them when the folder layout and name template place them
+// elsewhere under their new metadata.
+func newEditor(retag bool) (*library.Editor, error) {
+	groups, err := loadGroups()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load group configuration: %v", err)
+	}
+	layouts, err := organizer.NewLayouts(groups, os.Getenv("MUSICLOUD_FOLDER_LAYOUT"), os.Getenv("MUSICLOUD_FILE_NAME"))
+	if err != nil {
+		return nil, err
+	}
+	views, err := organizer.ParseViews(os.Getenv("MUSICLOUD_BROWSE_VIEWS"))
+	if err != nil {
+		return nil, err
+	}
+	editor := &library.Editor{Retag: retag, Place: placeByLayout(layouts)}
+	editor.Applied = followEdits(editor, layouts, views)
+	return editor, nil
+}
+
+// followEdits keeps the session manifests and browse views in step with
+// edited recordings: the manifest of their folder is rewritten, that of a
+// folder they left drops them, and their shortcuts are repaired.
+func followEdits(editor *library.Editor, layouts *organizer.Layouts, views []string) func(before, after library.Recording) {
+	var org *organizer.Organizer
+	return func(before, after library.Recording) {
+		if org == nil {
+			org = organizer.New(editor.Remote, editor.Root, layouts)
+			org.Views = views
+		}
+		if after.FolderID != "" {
+			if err := org.Record(after.FolderID, organizer.Entry{FileID: after.DriveID, SHA256: after.SHA256, Metadata: after.Metadata}); err != nil {
+				log.Printf("Failed to update the session manifest of %s: %v", after.Name, err)
+			}
+		}
+		if before.FolderID != "" && before.FolderID != after.FolderID {
+			if err := org.Refresh(before.FolderID); err != nil {
+				log.Printf("Failed to update the session manifest of %s: %v", before.Folder, err)
+			}
+		}
+		if err := org.Browse(after.DriveID, after.Metadata); err != nil {
+			log.Printf("Failed to update the browse views of %s: %v", after.Name, err)
+		}
+	}
+}
+
+// placeByLayout places recordings by the folder layout and names them by
+// the name template. A recording is only moved when it is in the folder the
+// layout gave its current metadata, below its route, and only renamed when
+// it has the name the template gave its current metadata, give or take a
+// collision suffix; recordings moved or renamed by hand stay as they are.
+func placeByLayout(layouts *organizer.Layouts) func(r library.Recording, m metadata.Metadata) (string, string) {
+	return func(r library.Recording, m metadata.Metadata) (string, string) {
+		return placeFolder(layouts, r, m), placeName(layouts, r, m)
+	}
+}
+
+func placeFolder(layouts *organizer.Layouts, r library.Recording, m metadata.Metadata) string {
+	current, err1 := layouts.Folder(r.Metadata)
+	next, err2 := layouts.Folder(m)
+	if err1 != nil || err2 != nil || current == "" || current == next {
+		return r.Folder
+	}
+	var route string
+	switch {
+	case r.Folder == current:
+	case strings.HasSuffix(r.Folder, "/"+current):
+		route = strings.TrimSuffix(r.Folder, "/"+current)
+	default:
+		return r.Folder
+	}
+	return path.Join(route, next)
+}
+
+func placeName(layouts *organizer.Layouts, r library.Recording, m metadata.Metadata) string {
+	original := r.Original
+	if original == "" {
+		original = r.Name
+	}
+	current, err1 := layouts.Name(r.Metadata, original)
+	next, err2 := layouts.Name(m, original)
+	if err1 != nil || err2 != nil || current == "" || current == next {
+		return ""
+	}
+	if r.Name != current && collisionSuffix.ReplaceAllString(r.Name, "$1") != current {
+		return ""
+	}
+	return next
+}
+
+// collisionSuffix matches the "_2" drive.UniqueName puts before an extension.
+var collisionSuffix = regexp.MustCompile(`_\d+(\.[^.]*)?$`)
+
+// setFlags collects repeated -set field=value flags.
+type setFlags map[string]string
+
+func (s setFlags) String() string {
+	return fmt.Sprint(map[string]string(s))
+}
+
+func (s setFlags) Set(v string) error {
+	field, value, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("expected field=value, got %q", v)
+	}
+	field = strings.TrimSpace(field)
+	for _, f := range metadata.Fields {
+		if f == field {
+			s[field] = value
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown field %q (use one of %s)", field, strings.Join(metadata.Fields, ", "))
+}
+
+// runMeta runs "musicloud meta edit" and "musicloud meta apply".
+func runMeta(args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] != "edit" && args[0] != "apply" {
+		return fmt.Errorf("usage: musicloud meta edit <id|path> | musicloud meta apply changes.csv")
+	}
+	fs := flag.NewFlagSet("meta "+args[0], flag.ContinueOnError)
+	set := setFlags{}
+	if args[0] == "edit" {
+		fs.Var(set, "set", "Set a field, e.g. -set ragas=Kalyani; repeatable. Without it, the metadata is opened in $EDITOR")
+	}
+	retag := fs.Bool("retag", false, "Also rewrite the tags embedded in the file and upload it as a new revision")
+	dryRun := fs.Bool("dry-run", false, "Show the changes without making them")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: musicloud meta %s [options] <%s>", args[0], map[string]string{"edit": "id|path", "apply": "changes.csv"}[args[0]])
+	}
+
+	lib, err := library.Load(libraryPath())
+	if err != nil {
+		return err
+	}
+	editor, err := newEditor(*retag)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		editor.Remote, editor.Root, err = openRemote()
+		if err != nil {
+			return err
+		}
+	}
+
+	changes := []library.Change{{Recording: fs.Arg(0), Set: set}}
+	if args[0] == "apply" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		changes, err = library.ReadChanges(f, strings.EqualFold(filepath.Ext(fs.Arg(0)), ".tsv"))
+		if err != nil {
+			return fmt.Errorf("%s: %v", fs.Arg(0), err)
+		}
+	}
+
+	failed := 0
+	for _, c := range changes {
+		rec, err := lib.Find(c.Recording)
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", lineError(c, err))
+			failed++
+			continue
+		}
+		var m metadata.Metadata
+		if args[0] == "edit" && len(set) == 0 {
+			m, err = editInEditor(rec.Metadata)
+		} else {
+			m, err = c.Apply(rec.Metadata)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", lineError(c, err))
+			failed++
+			continue
+		}
+		var edit library.Edit
+		if *dryRun {
+			edit, err = editor.Plan(rec, m)
+		} else {
+			edit, err = editor.Apply(rec, m)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "%s\n", lineError(c, err))
+			failed++
+			continue
+		}
+		fmt.Fprintln(out, edit)
+		if !*dryRun && !edit.Empty() {
+			if err := lib.Save(); err != nil {
+				return err
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d changes failed", failed, len(changes))
+	}
+	return nil
+}
+
+func lineError(c library.Change, err error) string {
+	if c.Line > 0 {
+		return fmt.Sprintf("line %d (%s): %v", c.Line, c.Recording, err)
+	}
+	return fmt.Sprintf("%s: %v", c.Recording, err)
+}
+
+// editInEditor opens the metadata as YAML in $EDITOR and reads it back.
+func editInEditor(m metadata.Metadata) (metadata.Metadata, error) {
+	b, err := metadata.EncodeYAML(m)
+	if err != nil {
+		return metadata.Metadata{}, err
+	}
+	f, err := os.CreateTemp("", "musicloud-*.yaml")
+	if err != nil {
+		return metadata.Metadata{}, err
+	}
+	defer os.Remove(f.Name())
+	f.Write(b)
+	f.Close()
+
+	editor := getEnvWithDefault("EDITOR", "vi")
+	cmd := exec.Command(editor, f.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return metadata.Metadata{}, fmt.Errorf("editor %s failed: %v", editor, err)
+	}
+	edited, err := os.ReadFile(f.Name())
+	if err != nil {
+		return metadata.Metadata{}, err
+	}
+	if bytes.Equal(edited, b) {
+		return m, nil
+	}
+	return metadata.Decode(edited)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"musicloud/config"
+	"musicloud/internal/library"
+	"musicloud/internal/organizer"
+	"musicloud/internal/reorganize"
+)
+
+// runReorganize runs "musicloud reorganize": it plans moving every uploaded
+// recording to where the current folder layout and name template put it and
+// shows the plan; -apply carries out the plan shown last and -undo reverts
+// it.
+func runReorganize(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("reorganize", flag.ContinueOnError)
+	apply := fs.Bool("apply", false, "Apply the plan shown by the last run, resuming it if it was interrupted")
+	undo := fs.Bool("undo", false, "Undo the applied steps of the last plan")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *apply && *undo {
+		return fmt.Errorf("usage: musicloud reorganize [-apply | -undo]")
+	}
+
+	planPath := filepath.Join(getEnvWithDefault("MUSICLOUD_STATE_DIR", ".musicloud"), "reorganize.json")
+	plan, err := reorganize.Load(planPath)
+	if err != nil {
+		return err
+	}
+	lib, err := library.Load(libraryPath())
+	if err != nil {
+		return err
+	}
+	groups, err := loadGroups()
+	if err != nil {
+		return fmt.Errorf("unable to load group configuration: %v", err)
+	}
+	layouts, err := organizer.NewLayouts(groups, os.Getenv("MUSICLOUD_FOLDER_LAYOUT"), os.Getenv("MUSICLOUD_FILE_NAME"))
+	if err != nil {
+		return err
+	}
+	views, err := organizer.ParseViews(os.Getenv("MUSICLOUD_BROWSE_VIEWS"))
+	if err != nil {
+		return err
+	}
+	remote, root, err := openRemote()
+	if err != nil {
+		return err
+	}
+	if len(plan.Steps) > 0 && plan.Root != root {
+		return fmt.Errorf("the saved plan is for another upload folder; remove %s to start over", planPath)
+	}
+	org := organizer.New(remote, root, layouts)
+	org.Views = views
+
+	switch {
+	case *apply:
+		if len(plan.Steps) == 0 || plan.Finished() {
+			return fmt.Errorf("no plan to apply; run musicloud reorganize to make one")
+		}
+		err := plan.Apply(org, lib)
+		printWarnings(out, plan)
+		if err != nil {
+			return fmt.Errorf("%v\nRun musicloud reorganize -apply again to resume", err)
+		}
+		fmt.Fprintf(out, "Reorganized: %s\nRun musicloud reorganize -undo to revert it.\n", plan.Summary())
+		return nil
+
+	case *undo:
+		if !plan.Started() {
+			return fmt.Errorf("nothing to undo")
+		}
+		err := plan.Undo(org, lib)
+		printWarnings(out, plan)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "Undone.")
+		return nil
+	}
+
+	if plan.Started() && !plan.Finished() {
+		return fmt.Errorf("the last plan is partly applied; finish it with -apply or revert it with -undo first")
+	}
+	planner := &reorganize.Planner{Remote: remote, Root: root, Layouts: layouts, Routes: routeFolders(groups)}
+	next, err := planner.Plan(lib.Recordings)
+	if err != nil {
+		return err
+	}
+	if len(next.Steps) == 0 {
+		fmt.Fprintln(out, "Every recording is where the current layout puts it.")
+		return nil
+	}
+	plan.Root, plan.Created, plan.Steps, plan.Missing = next.Root, next.Created, next.Steps, next.Missing
+	if err := plan.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s\n%s\nReview the plan, then run musicloud reorganize -apply.\n", plan, plan.Summary())
+	return nil
+}
+
+func printWarnings(out io.Writer, plan *reorganize.Plan) {
+	for _, w := range plan.Warnings {
+		fmt.Fprintf(out, "warning: %s\n", w)
+	}
+}
+
+// routeFolders lists the folders the group configuration routes
+// recordings to.
+func routeFolders(groups *config.Groups) []string {
+	if groups == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var routes []string
+	for _, g := range groups.Groups {
+		for _, folder := range g.Routes {
+			if folder != "" && !seen[folder] {
+				seen[folder] = true
+				routes = append(routes, folder)
+			}
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig_Defaults(t *testing.T) {
@@ -15,3 +17,106 @@ func TestLoadConfig_Defaults(t *testing.T) {
 		t.Errorf("expected ./watched, got %s", cfg.WatchFolder)
 	}
 }
+
+func TestLoadGroups_ResolveSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	os.WriteFile(path, []byte(`groups:
+  - name: Veena Class
+    routes:
+      student: Student Practice
+    people:
+      - name: Lakshmi Raman
+        role: teacher
+        senders: ["Lakshmi Ma'am", "+91 98765 43210"]
+      - name: Ravi
+        role: student
+`), 0644)
+
+	groups, err := LoadGroups(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	group := groups.ForChat("veena class")
+	if group == nil {
+		t.Fatal("expected group for chat")
+	}
+	for _, sender := range []string{"Lakshmi Ma'am", "98765 43210", "+91 98765-43210", "Lakshmi Raman", "లక్ష్మి రామన్"} {
+		p, ok := group.ResolveSender(sender)
+		if !ok || p.Name != "Lakshmi Raman" || p.Role != RoleTeacher {
+			t.Errorf("ResolveSender(%q) = %+v, %v", sender, p, ok)
+		}
+	}
+	if _, ok := group.ResolveSender("Stranger"); ok {
+		t.Error("expected unknown sender not to resolve")
+	}
+	if got := group.Route(RoleStudent); got != "Student Practice" {
+		t.Errorf("expected student route, got %q", got)
+	}
+}
+
+func TestLoadGroups_UnknownRole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	os.WriteFile(path, []byte("groups:\n  - name: G\n    people:\n      - name: X\n        role: singer\n"), 0644)
+	if _, err := LoadGroups(path); err == nil {
+		t.Error("expected error for unknown role")
+	}
+}
+
+func TestGroups_ForChatHistoricalTitles(t *testing.T) {
+	groups := &Groups{Groups: []Group{
+		{Name: "Veena Saturday", Titles: []string{"Veena Class", "Veena Class - Saturday Batch"}},
+		{Name: "Vocal Sunday"},
+	}}
+	if g := groups.ForChat("Veena Class", "Veena Class – Saturday Batch"); g == nil || g.Name != "Veena Saturday" {
+		t.Errorf("expected Veena Saturday, got %+v", g)
+	}
+	if g := groups.ForChat("Old Name", "vocal sunday"); g == nil || g.Name != "Vocal Sunday" {
+		t.Errorf("expected Vocal Sunday, got %+v", g)
+	}
+	if g := groups.ForChat("Unrelated"); g != nil {
+		t.Errorf("expected no group, got %+v", g)
+	}
+}
+
+func TestLoadGroups_LayoutsAndTerms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	os.WriteFile(path, []byte(`layout: "{{.Year}}/{{.Group}}"
+terms:
+  - name: Spring 2024
+    start: 2024-01-08
+    end: 2024-04-30
+file_name: "{{.Date}} {{.Group}}.{{.Ext}}"
+groups:
+  - name: Veena Class
+    layout: "{{.Term}}/{{.Date}}"
+    file_name: "{{.Date}}_{{first .Ragas}}.{{.Ext}}"
+  - name: Vocal Class
+`), 0644)
+	groups, err := LoadGroups(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := groups.LayoutFor("veena class"); got != "{{.Term}}/{{.Date}}" {
+		t.Errorf("expected the group's layout, got %q", got)
+	}
+	if got := groups.LayoutFor("Vocal Class"); got != "{{.Year}}/{{.Group}}" {
+		t.Errorf("expected the file's layout, got %q", got)
+	}
+	if got := groups.FileNameFor("Veena Class"); got != "{{.Date}}_{{first .Ragas}}.{{.Ext}}" {
+		t.Errorf("expected the group's name template, got %q", got)
+	}
+	if got := groups.FileNameFor("Vocal Class"); got != "{{.Date}} {{.Group}}.{{.Ext}}" {
+		t.Errorf("expected the file's name template, got %q", got)
+	}
+	for day, want := range map[string]string{"2024-01-08": "Spring 2024", "2024-04-30": "Spring 2024", "2024-05-01": ""} {
+		d, _ := time.ParseInLocation("2006-01-02 15:04", day+" 23:30", time.Local)
+		if got := groups.Term(d); got != want {
+			t.Errorf("Term(%s) = %q, want %q", day, got, want)
+		}
+	}
+
+	os.WriteFile(path, []byte("terms:\n  - name: Backwards\n    start: 2024-05-01\n    end: 2024-01-01\n"), 0644)
+	if _, err := LoadGroups(path); err == nil {
+		t.Error("expected a term ending before it starts to be rejected")
+	}
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"musicloud/internal/translit"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Role is the part a person plays in a class group.
+type Role string
+
+const (
+	RoleTeacher     Role = "teacher"
+	RoleStudent     Role = "student"
+	RoleAccompanist Role = "accompanist"
+	RoleAdmin       Role = "admin"
+)
+
+// Person is a canonical member of a group together with the display names and
+// phone numbers they appear under in chat exports.
+type Person struct {
+	Name    string   `yaml:"name"`
+	Role    Role     `yaml:"role"`
+	Senders []string `yaml:"senders"`
+}
+
+// Group describes one class group.
+type Group struct {
+	Name string `yaml:"name"`
+	// Titles lists the chat titles the group has used, including historical
+	// ones, so renaming the chat does not split the archive.
+	Titles []string `yaml:"titles"`
+	People []Person `yaml:"people"`
+	// Routes maps a sender's role to the Drive folder (under the upload
+	// folder) their recordings go to. Roles without a route use the upload
+	// folder itself.
+	Routes map[Role]string `yaml:"routes"`
+	// Layout is the folder layout of the group's recordings, overriding the
+	// file's layout.
+	Layout string `yaml:"layout"`
+	// FileName is the name template of the group's recordings, overriding
+	// the file's.
+	FileName string `yaml:"file_name"`
+}
+
+// Term is a named date range, such as a school term or semester, that
+// folder layouts can file recordings under.
+type Term struct {
+	Name  string `yaml:"name"`
+	Start string `yaml:"start"` // first day, YYYY-MM-DD
+	End   string `yaml:"end"`   // last day, YYYY-MM-DD
+}
+
+// Groups is the group configuration file.
+type Groups struct {
+	// Layout is the folder layout of recordings of every group without a
+	// layout of its own.
+	Layout string `yaml:"layout"`
+	// FileName is the name template of recordings of every group without
+	// one of its own.
+	FileName string  `yaml:"file_name"`
+	Terms    []Term  `yaml:"terms"`
+	Groups   []Group `yaml:"groups"`
+}
+
+// LoadGroups reads the group configuration from a YAML file.
+func LoadGroups(path string) (*Groups, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read group config: %v", err)
+	}
+	var g Groups
+	if err := yaml.Unmarshal(b, &g); err != nil {
+		return nil, fmt.Errorf("unable to parse group config %s: %v", path, err)
+	}
+	for _, group := range g.Groups {
+		for _, p := range group.People {
+			switch p.Role {
+			case RoleTeacher, RoleStudent, RoleAccompanist, RoleAdmin:
+			default:
+				return nil, fmt.Errorf("%s: group %q: person %q has unknown role %q", path, group.Name, p.Name, p.Role)
+			}
+		}
+	}
+	for _, t := range g.Terms {
+		start, err1 := time.Parse(dateLayout, t.Start)
+		end, err2 := time.Parse(dateLayout, t.End)
+		if t.Name == "" || err1 != nil || err2 != nil || end.Before(start) {
+			return nil, fmt.Errorf("%s: term %q needs a name and start and end dates (YYYY-MM-DD) in order", path, t.Name)
+		}
+	}
+	return &g, nil
+}
+
+const dateLayout = "2006-01-02"
+
+// Term returns the name of the first term that includes the day of t, or
+// "" if none does.
+func (g *Groups) Term(t time.Time) string {
+	if g == nil || t.IsZero() {
+		return ""
+	}
+	day := t.Format(dateLayout)
+	for _, term := range g.Terms {
+		if term.Start <= day && day <= term.End {
+			return term.Name
+		}
+	}
+	return ""
+}
+
+// LayoutFor returns the folder layout of a group's recordings: the group's
+// own, or the file's. It is "" when neither is set.
+func (g *Groups) LayoutFor(group string) string {
+	if g == nil {
+		return ""
+	}
+	if gr := g.ForChat(group); gr != nil && gr.Layout != "" {
+		return gr.Layout
+	}
+	return g.Layout
+}
+
+// FileNameFor returns the name template of a group's recordings: the
+// group's own, or the file's. It is "" when neither is set.
+func (g *Groups) FileNameFor(group string) string {
+	if g == nil {
+		return ""
+	}
+	if gr := g.ForChat(group); gr != nil && gr.FileName != "" {
+		return gr.FileName
+	}
+	return g.FileName
+}
+
+// ForChat returns the group a chat belongs to, or nil if none is configured.
+// Titles are the chat's titles, oldest first; the most recent one that
+// matches a group's name or one of its titles wins.
+func (g *Groups) ForChat(titles ...string) *Group {
+	if g == nil {
+		return nil
+	}
+	for t := len(titles) - 1; t >= 0; t-- {
+		for i := range g.Groups {
+			if g.Groups[i].hasTitle(titles[t]) {
+				return &g.Groups[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (g *Group) hasTitle(title string) bool {
+	if sameTitle(g.Name, title) {
+		return true
+	}
+	for _, t := range g.Titles {
+		if sameTitle(t, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameTitle compares chat titles ignoring case, surrounding space and the
+// kind of dash used.
+func sameTitle(a, b string) bool {
+	norm := strings.NewReplacer("–", "-", "—", "-")
+	if strings.EqualFold(norm.Replace(strings.TrimSpace(a)), norm.Replace(strings.TrimSpace(b))) {
+		return true
+	}
+	return sameName(a, b)
+}
+
+// sameName compares names written in different scripts or romanizations,
+// such as "లక్ష్మి రామన్" and "Lakshmi Raman".
+func sameName(a, b string) bool {
+	if !translit.HasIndic(a) && !translit.HasIndic(b) {
+		return false
+	}
+	ka := translit.Key(a)
+	return ka != "" && ka == translit.Key(b)
+}
+
+// ResolveSender maps a chat sender (display name or phone number) to a person.
+func (g *Group) ResolveSender(sender string) (Person, bool) {
+	if g == nil {
+		return Person{}, false
+	}
+	for _, p := range g.People {
+		if senderMatches(p.Name, sender) {
+			return p, true
+		}
+		for _, s := range p.Senders {
+			if senderMatches(s, sender) {
+				return p, true
+			}
+		}
+	}
+	return Person{}, false
+}
+
+// Route returns the folder recordings from the given role are routed to.
+func (g *Group) Route(role Role) string {
+	if g == nil {
+		return ""
+	}
+	return g.Routes[role]
+}
+
+// senderMatches compares names case-insensitively and phone numbers by their
+// digits, ignoring a missing country code.
+func senderMatches(configured, sender string) bool {
+	a, b := phoneDigits(configured), phoneDigits(sender)
+	if a != "" && b != "" {
+		return lastDigits(a, 10) == lastDigits(b, 10)
+	}
+	sender = strings.TrimSpace(strings.TrimPrefix(sender, "~"))
+	return strings.EqualFold(strings.TrimSpace(configured), sender) || sameName(configured, sender)
+}
+
+// phoneDigits returns the digits of s if s looks like a phone number.
+func phoneDigits(s string) string {
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case unicode.IsDigit(r):
+			digits.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return ""
+		}
+	}
+	if digits.Len() < 7 {
+		return ""
+	}
+	return digits.String()
+}
+
+func lastDigits(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
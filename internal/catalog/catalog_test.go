@@ -0,0 +1,164 @@
+package catalog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMelakartas(t *testing.T) {
+	cases := []struct {
+		n                int
+		name, aro, avaro string
+	}{
+		{1, "Kanakangi", "S R1 G1 M1 P D1 N1 S", "S N1 D1 P M1 G1 R1 S"},
+		{15, "Mayamalavagowla", "S R1 G3 M1 P D1 N3 S", "S N3 D1 P M1 G3 R1 S"},
+		{22, "Kharaharapriya", "S R2 G2 M1 P D2 N2 S", "S N2 D2 P M1 G2 R2 S"},
+		{29, "Sankarabharanam", "S R2 G3 M1 P D2 N3 S", "S N3 D2 P M1 G3 R2 S"},
+		{65, "Kalyani", "S R2 G3 M2 P D2 N3 S", "S N3 D2 P M2 G3 R2 S"},
+		{72, "Rasikapriya", "S R3 G3 M2 P D3 N3 S", "S N3 D3 P M2 G3 R3 S"},
+	}
+	for _, c := range cases {
+		r, ok := Melakarta(c.n)
+		if !ok || r.Name != c.name || r.Arohana != c.aro || r.Avarohana != c.avaro {
+			t.Errorf("Melakarta(%d) = %+v", c.n, r)
+		}
+	}
+	if len(AllRagas()) < 72+50 {
+		t.Errorf("expected the melakartas and a broad set of janyas, got %d ragas", len(AllRagas()))
+	}
+}
+
+func TestMatchRaga(t *testing.T) {
+	cases := []struct {
+		in, want string
+		exact    bool
+	}{
+		{"Sankarabharanam", "Sankarabharanam", true},
+		{"Shankarabharanam", "Sankarabharanam", true},
+		{"Dheera Sankarabharanam", "Sankarabharanam", true},
+		{"Thodi", "Todi", true},
+		{"Hanumatodi", "Todi", true},
+		{"keeravani", "Keeravani", true},
+		{"Kiravani", "Keeravani", true},
+		{"Mohana", "Mohanam", true},
+		{"Hamsadhvani", "Hamsadhwani", true},
+		{"Mayamalavagaula", "Mayamalavagowla", true},
+		{"Kalyan", "Kalyani", false},
+		{"Kharaharapriyaa", "Kharaharapriya", true},
+		{"Kharahrapriya", "Kharaharapriya", false},
+		{"தோடி", "Todi", true},
+		{"ஹம்சத்வனி", "Hamsadhwani", true},
+		{"மோகனம்", "Mohanam", true},
+		{"சங்கராபரணம்", "Sankarabharanam", true},
+		{"Kannada", "Kannada", true},
+		{"Kanada", "Kanada", true},
+		{"Kaanada", "Kanada", true},
+		{"Abheri", "Abheri", true},
+		{"Sindhu Bhairavi", "Sindhubhairavi", true},
+		{"Kedaragaula", "Kedaragowla", true},
+		{"Yamuna Kalyani", "Yamunakalyani", true},
+	}
+	for _, c := range cases {
+		r, exact, ok := MatchRaga(c.in)
+		if !ok || r.Name != c.want || exact != c.exact {
+			t.Errorf("MatchRaga(%q) = %s exact=%v ok=%v, want %s exact=%v", c.in, r.Name, exact, ok, c.want, c.exact)
+		}
+	}
+	for _, in := range []string{"Sama", "Nata", "Xyz", ""} {
+		r, exact, ok := MatchRaga(in)
+		if in == "Xyz" || in == "" {
+			if ok {
+				t.Errorf("MatchRaga(%q) = %s, want no match", in, r.Name)
+			}
+			continue
+		}
+		if !ok || !exact || r.Name != in {
+			t.Errorf("MatchRaga(%q) = %s exact=%v ok=%v", in, r.Name, exact, ok)
+		}
+	}
+
+	r, _ := LookupRaga("Hamsadhwani")
+	if r.String() != "Hamsadhwani (janya of 29 Sankarabharanam)" || r.ParentRaga().MelaName != "Dheerasankarabharanam" {
+		t.Errorf("unexpected janya description %q", r.String())
+	}
+}
+
+func TestCanonicalRagas(t *testing.T) {
+	out, unknown, corrected := CanonicalRagas([]string{"Shankarabharanam", "Dheera Sankarabharanam", "Kalyan", "Ragamalika"})
+	if !reflect.DeepEqual(out, []string{"Sankarabharanam", "Kalyani", "Ragamalika"}) {
+		t.Errorf("out = %v", out)
+	}
+	if !reflect.DeepEqual(unknown, []string{"Ragamalika"}) || !reflect.DeepEqual(corrected, []string{"Kalyan -> Kalyani"}) {
+		t.Errorf("unknown = %v, corrected = %v", unknown, corrected)
+	}
+}
+
+func TestParseTala(t *testing.T) {
+	cases := []struct {
+		in, name, structure string
+		aksharas            int
+	}{
+		{"Adi", "Adi", "I4 O O", 8},
+		{"Aadi talam", "Adi", "I4 O O", 8},
+		{"Chatusra jati Triputa", "Adi", "I4 O O", 8},
+		{"Triputa", "Tisra Jati Triputa", "I3 O O", 7},
+		{"Rupakam", "Rupakam", "O I4", 6},
+		{"Khanda Chapu", "Khanda Chapu", "2+3", 5},
+		{"misra chapu", "Misra Chapu", "3+4", 7},
+		{"Jhampa", "Misra Jati Jhampa", "I7 U O", 10},
+		{"Khanda Ata", "Khanda Jati Ata", "I5 I5 O O", 14},
+		{"Sankeerna jathi Dhruva", "Sankeerna Jati Dhruva", "I9 O I9 I9", 29},
+		{"Tisra Eka", "Tisra Jati Eka", "I3", 3},
+		{"Adi tala tisra nadai", "Adi (Tisra Nadai)", "I4 O O", 8},
+		{"Adi 2 kalai", "Adi (2 Kalai)", "I4 O O", 8},
+	}
+	for _, c := range cases {
+		tala, ok := ParseTala(c.in)
+		if !ok || tala.Name() != c.name || tala.Structure() != c.structure || tala.Aksharas() != c.aksharas {
+			t.Errorf("ParseTala(%q) = %v ok=%v, want %s [%s, %d aksharas]", c.in, tala, ok, c.name, c.structure, c.aksharas)
+		}
+	}
+	for _, in := range []string{"", "Ragamalika", "Chatusra Chapu", "Tisra Adi", "Adi Rupakam"} {
+		if tala, ok := ParseTala(in); ok {
+			t.Errorf("ParseTala(%q) = %v, want no match", in, tala)
+		}
+	}
+}
+
+func TestTalaCatalog(t *testing.T) {
+	suladi := SuladiTalas()
+	if len(suladi) != 35 {
+		t.Fatalf("expected 35 suladi talas, got %d", len(suladi))
+	}
+	names := map[string]bool{}
+	for _, tala := range append(suladi, ChapuTalas()...) {
+		if names[tala.Name()] {
+			t.Errorf("duplicate label %q", tala.Name())
+		}
+		names[tala.Name()] = true
+		if parsed, ok := ParseTala(tala.Name()); !ok || parsed.Name() != tala.Name() {
+			t.Errorf("label %q does not parse back", tala.Name())
+		}
+	}
+
+	out, unknown := CanonicalTalas([]string{"Aadi", "Chatusra jati Triputa", "Rupakam", "Misra Chapu", "Deshadi"})
+	if !reflect.DeepEqual(out, []string{"Adi", "Rupakam", "Misra Chapu", "Deshadi"}) || !reflect.DeepEqual(unknown, []string{"Deshadi"}) {
+		t.Errorf("CanonicalTalas = %v, unknown %v", out, unknown)
+	}
+}
+
+func TestRagaKeysAreDistinct(t *testing.T) {
+	owner := map[string]string{}
+	for _, r := range AllRagas() {
+		for _, n := range append([]string{r.Name, r.MelaName}, r.Aliases...) {
+			if n == "" {
+				continue
+			}
+			k := Key(n)
+			if other, ok := owner[k]; ok && other != r.Name {
+				t.Errorf("%s (%s) and %s share the key %q", n, r.Name, other, k)
+			}
+			owner[k] = r.Name
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package catalog
+
+import (
+	"strings"
+
+	"musicloud/internal/translit"
+)
+
+// Key reduces a name to the form used to compare names: translit.Key, so
+// spellings and scripts agree, with a final "m" dropped ("Mohanam", "Mohana").
+func Key(name string) string {
+	s := translit.Key(name)
+	if len(s) > 3 {
+		s = strings.TrimSuffix(s, "m")
+	}
+	return s
+}
+
+// maxDistance is how many edits a fuzzy match may need for a key of this
+// length; short names are only matched exactly.
+func maxDistance(key string) int {
+	switch n := len([]rune(key)); {
+	case n < 5:
+		return 0
+	case n < 9:
+		return 1
+	}
+	return 2
+}
+
+// distance is the Levenshtein distance between two strings.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
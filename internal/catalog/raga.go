@@ -0,0 +1,276 @@
+// Package catalog holds built-in knowledge about Carnatic ragas and talas,
+// used to validate and canonicalize recording metadata.
+package catalog
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Raga is a raga in the catalog.
+type Raga struct {
+	Name      string // canonical name, the one commonly used
+	MelaName  string // Katapayadi name of a melakarta, when it differs from Name
+	Number    int    // melakarta number, 1 to 72; 0 for janya ragas
+	Parent    int    // melakarta number a janya raga derives from
+	Arohana   string // ascending scale, e.g. "S R2 G3 P N3 S"
+	Avarohana string
+	Aliases   []string
+}
+
+// Melakarta reports whether the raga is one of the 72 parent scales.
+func (r Raga) Melakarta() bool {
+	return r.Number > 0
+}
+
+// ParentRaga returns the melakarta a janya raga derives from, or the raga
+// itself for a melakarta.
+func (r Raga) ParentRaga() Raga {
+	if r.Melakarta() {
+		return r
+	}
+	return melakartas[r.Parent-1]
+}
+
+// String describes the raga, e.g. "Hamsadhwani (janya of 29 Sankarabharanam)".
+func (r Raga) String() string {
+	if r.Melakarta() {
+		return fmt.Sprintf("%s (melakarta %d)", r.Name, r.Number)
+	}
+	p := r.ParentRaga()
+	return fmt.Sprintf("%s (janya of %d %s)", r.Name, p.Number, p.Name)
+}
+
+// melaNames are the 72 melakartas in order; a second name is the common name
+// used as canonical.
+var melaNames = [72][2]string{
+	{"Kanakangi"}, {"Ratnangi"}, {"Ganamurti"}, {"Vanaspati"}, {"Manavati"}, {"Tanarupi"},
+	{"Senavati"}, {"Hanumatodi", "Todi"}, {"Dhenuka"}, {"Natakapriya"}, {"Kokilapriya"}, {"Rupavati"},
+	{"Gayakapriya"}, {"Vakulabharanam"}, {"Mayamalavagowla"}, {"Chakravakam"}, {"Suryakantam"}, {"Hatakambari"},
+	{"Jhankaradhwani"}, {"Natabhairavi"}, {"Keeravani"}, {"Kharaharapriya"}, {"Gourimanohari"}, {"Varunapriya"},
+	{"Mararanjani"}, {"Charukesi"}, {"Sarasangi"}, {"Harikambhoji"}, {"Dheerasankarabharanam", "Sankarabharanam"}, {"Naganandini"},
+	{"Yagapriya"}, {"Ragavardhini"}, {"Gangeyabhushani"}, {"Vagadheeswari"}, {"Shulini"}, {"Chalanata"},
+	{"Salagam"}, {"Jalarnavam"}, {"Jhalavarali"}, {"Navaneetam"}, {"Pavani"}, {"Raghupriya"},
+	{"Gavambodhi"}, {"Bhavapriya"}, {"Shubhapantuvarali"}, {"Shadvidamargini"}, {"Suvarnangi"}, {"Divyamani"},
+	{"Dhavalambari"}, {"Namanarayani"}, {"Kamavardhini", "Pantuvarali"}, {"Ramapriya"}, {"Gamanashrama"}, {"Vishwambari"},
+	{"Shamalangi"}, {"Shanmukhapriya"}, {"Simhendramadhyamam"}, {"Hemavati"}, {"Dharmavati"}, {"Neetimati"},
+	{"Kantamani"}, {"Rishabhapriya"}, {"Latangi"}, {"Vachaspati"}, {"Mechakalyani", "Kalyani"}, {"Chitrambari"},
+	{"Sucharitra"}, {"Jyotiswarupini"}, {"Dhatuvardhani"}, {"Nasikabhushani"}, {"Kosalam"}, {"Rasikapriya"},
+}
+
+// melaAliases are other names in use for melakartas, by number.
+var melaAliases = map[int][]string{
+	8:  {"Thodi"},
+	15: {"Mayamalavagaula", "Malavagowla"},
+	21: {"Kiravani"},
+	29: {"Shankarabharanam"},
+	45: {"Subhapantuvarali"},
+	51: {"Panthuvarali"},
+	53: {"Gamakakriya"},
+	65: {"Mechakalyan"},
+}
+
+// melaScale derives the scale of a melakarta from its number: the first 36
+// take M1 and the rest M2; within each half, the chakra fixes R and G and the
+// position in the chakra fixes D and N.
+func melaScale(n int) (arohana, avarohana string) {
+	rg := [6][2]string{{"R1", "G1"}, {"R1", "G2"}, {"R1", "G3"}, {"R2", "G2"}, {"R2", "G3"}, {"R3", "G3"}}
+	dn := [6][2]string{{"D1", "N1"}, {"D1", "N2"}, {"D1", "N3"}, {"D2", "N2"}, {"D2", "N3"}, {"D3", "N3"}}
+	m := "M1"
+	if n > 36 {
+		m = "M2"
+	}
+	i := (n - 1) % 36
+	r, g := rg[i/6][0], rg[i/6][1]
+	d, ni := dn[i%6][0], dn[i%6][1]
+	arohana = strings.Join([]string{"S", r, g, m, "P", d, ni, "S"}, " ")
+	avarohana = strings.Join([]string{"S", ni, d, "P", m, g, r, "S"}, " ")
+	return arohana, avarohana
+}
+
+var melakartas = func() []Raga {
+	out := make([]Raga, 72)
+	for i, names := range melaNames {
+		n := i + 1
+		r := Raga{Name: names[0], Number: n, Parent: n, Aliases: melaAliases[n]}
+		if names[1] != "" {
+			r.Name, r.MelaName = names[1], names[0]
+		}
+		r.Arohana, r.Avarohana = melaScale(n)
+		out[i] = r
+	}
+	return out
+}()
+
+// janyas are commonly sung ragas derived from a melakarta. Scales with
+// vakra (zigzag) phrases are given the way they are usually taught.
+var janyas = []Raga{
+	{Name: "Hamsadhwani", Parent: 29, Arohana: "S R2 G3 P N3 S", Avarohana: "S N3 P G3 R2 S", Aliases: []string{"Hamsadhvani"}},
+	{Name: "Mohanam", Parent: 28, Arohana: "S R2 G3 P D2 S", Avarohana: "S D2 P G3 R2 S", Aliases: []string{"Mohana"}},
+	{Name: "Hindolam", Parent: 20, Arohana: "S G2 M1 D1 N2 S", Avarohana: "S N2 D1 M1 G2 S"},
+	{Name: "Madhyamavati", Parent: 22, Arohana: "S R2 M1 P N2 S", Avarohana: "S N2 P M1 R2 S"},
+	{Name: "Abhogi", Parent: 22, Arohana: "S R2 G2 M1 D2 S", Avarohana: "S D2 M1 G2 R2 S"},
+	{Name: "Sriranjani", Parent: 22, Arohana: "S R2 G2 M1 D2 N2 S", Avarohana: "S N2 D2 M1 G2 R2 S"},
+	{Name: "Shuddha Dhanyasi", Parent: 20, Arohana: "S G2 M1 P N2 S", Avarohana: "S N2 P M1 G2 S", Aliases: []string{"Suddha Dhanyasi"}},
+	{Name: "Kambhoji", Parent: 28, Arohana: "S R2 G3 M1 P D2 S", Avarohana: "S N2 D2 P M1 G3 R2 S", Aliases: []string{"Kamboji", "Khambhoji"}},
+	{Name: "Bilahari", Parent: 29, Arohana: "S R2 G3 P D2 S", Avarohana: "S N3 D2 P M1 G3 R2 S"},
+	{Name: "Arabhi", Parent: 29, Arohana: "S R2 M1 P D2 S", Avarohana: "S N3 D2 P M1 G3 R2 S"},
+	{Name: "Begada", Parent: 29, Arohana: "S G3 R2 G3 M1 P D2 P S", Avarohana: "S N2 D2 P M1 G3 R2 S"},
+	{Name: "Kedaram", Parent: 29, Arohana: "S M1 G3 M1 P N3 S", Avarohana: "S N3 P M1 G3 R2 S"},
+	{Name: "Atana", Parent: 29, Arohana: "S R2 M1 P N3 S", Avarohana: "S N3 D2 P M1 G3 R2 S", Aliases: []string{"Athana"}},
+	{Name: "Kadanakuthuhalam", Parent: 29, Arohana: "S R2 M1 D2 N3 G3 P S", Avarohana: "S N3 D2 P M1 G3 R2 S", Aliases: []string{"Kadanakutuhalam"}},
+	{Name: "Nilambari", Parent: 29, Arohana: "S R2 G3 M1 P D2 P S", Avarohana: "S N3 P M1 G3 R2 G3 S", Aliases: []string{"Neelambari"}},
+	{Name: "Shuddha Saveri", Parent: 29, Arohana: "S R2 M1 P D2 S", Avarohana: "S D2 P M1 R2 S", Aliases: []string{"Suddha Saveri"}},
+	{Name: "Devagandhari", Parent: 29, Arohana: "S R2 M1 P D2 S", Avarohana: "S N3 D2 P M1 G3 R2 S"},
+	{Name: "Kurinji", Parent: 29, Arohana: "S N3 S R2 G3 M1 P D2", Avarohana: "D2 P M1 G3 R2 S N3 S"},
+	{Name: "Kannada", Parent: 29, Arohana: "S R2 G3 M1 P M1 D2 N3 S", Avarohana: "S N3 S D2 P M1 G3 M1 R2 S"},
+	{Name: "Behag", Parent: 29, Arohana: "S G3 M1 P N3 D2 N3 S", Avarohana: "S N3 D2 P M2 G3 M1 G3 R2 S"},
+	{Name: "Saveri", Parent: 15, Arohana: "S R1 M1 P D1 S", Avarohana: "S N3 D1 P M1 G3 R1 S"},
+	{Name: "Malahari", Parent: 15, Arohana: "S R1 M1 P D1 S", Avarohana: "S D1 P M1 G3 R1 S"},
+	{Name: "Gowla", Parent: 15, Arohana: "S R1 M1 P N3 S", Avarohana: "S N3 P M1 R1 G3 M1 R1 S", Aliases: []string{"Gaula"}},
+	{Name: "Lalitha", Parent: 15, Arohana: "S R1 G3 M1 D1 N3 S", Avarohana: "S N3 D1 M1 G3 R1 S", Aliases: []string{"Lalita"}},
+	{Name: "Bowli", Parent: 15, Arohana: "S R1 G3 P D1 S", Avarohana: "S N3 D1 P G3 R1 S", Aliases: []string{"Bauli"}},
+	{Name: "Jaganmohini", Parent: 15, Arohana: "S G3 M1 P N3 S", Avarohana: "S N3 P M1 G3 R1 S"},
+	{Name: "Bhairavi", Parent: 20, Arohana: "S G2 R2 G2 M1 P D2 N2 S", Avarohana: "S N2 D1 P M1 G2 R2 S"},
+	{Name: "Anandabhairavi", Parent: 20, Arohana: "S G2 R2 G2 M1 P D2 P S", Avarohana: "S N2 D2 P M1 G2 R2 S"},
+	{Name: "Jonpuri", Parent: 20, Arohana: "S R2 M1 P D1 N2 S", Avarohana: "S N2 D1 P M1 G2 R2 S", Aliases: []string{"Jaunpuri"}},
+	{Name: "Reetigowla", Parent: 22, Arohana: "S G2 R2 G2 M1 N2 D2 M1 N2 S", Avarohana: "S N2 D2 M1 G2 M1 P M1 G2 R2 S", Aliases: []string{"Ritigowla", "Ritigaula"}},
+	{Name: "Abheri", Parent: 22, Arohana: "S G2 M1 P N2 S", Avarohana: "S N2 D2 P M1 G2 R2 S", Aliases: []string{"Karnataka Devagandhari"}},
+	{Name: "Mukhari", Parent: 22, Arohana: "S R2 M1 P N2 D2 S", Avarohana: "S N2 D1 P M1 G2 R2 S"},
+	{Name: "Kapi", Parent: 22, Arohana: "S R2 M1 P N3 S", Avarohana: "S N2 D2 N2 P M1 G2 R2 S"},
+	{Name: "Darbar", Parent: 22, Arohana: "S R2 M1 P D2 N2 S", Avarohana: "S N2 D2 P M1 R2 G2 R2 S"},
+	{Name: "Kanada", Parent: 22, Arohana: "S R2 G2 M1 D2 N2 S", Avarohana: "S N2 P M1 G2 M1 R2 S"},
+	{Name: "Sri", Parent: 22, Arohana: "S R2 M1 P N2 S", Avarohana: "S N2 P D2 N2 P M1 R2 G2 R2 S", Aliases: []string{"Shree", "Sree", "Sriragam"}},
+	{Name: "Manirangu", Parent: 22, Arohana: "S R2 M1 P N2 S", Avarohana: "S N2 P M1 G2 R2 S"},
+	{Name: "Andolika", Parent: 22, Arohana: "S R2 M1 P N2 S", Avarohana: "S N2 D2 M1 R2 S"},
+	{Name: "Huseni", Parent: 22, Arohana: "S R2 G2 M1 P N2 D2 N2 S", Avarohana: "S N2 D2 P M1 G2 R2 S", Aliases: []string{"Husseni"}},
+	{Name: "Nayaki", Parent: 22, Arohana: "S R2 M1 P D2 N2 D2 P S", Avarohana: "S N2 D2 P M1 R2 G2 R2 S"},
+	{Name: "Natakurinji", Parent: 28, Arohana: "S R2 G3 M1 N2 D2 N2 P D2 N2 S", Avarohana: "S N2 D2 M1 G3 M1 P G3 R2 S"},
+	{Name: "Yadukula Kambhoji", Parent: 28, Arohana: "S R2 M1 P D2 S", Avarohana: "S N2 D2 P M1 G3 R2 S", Aliases: []string{"Yadukulakamboji"}},
+	{Name: "Kedaragowla", Parent: 28, Arohana: "S R2 M1 P N2 S", Avarohana: "S N2 D2 P M1 G3 R2 S", Aliases: []string{"Kedaragaula"}},
+	{Name: "Kamas", Parent: 28, Arohana: "S M1 G3 M1 P D2 N2 S", Avarohana: "S N2 D2 P M1 G3 R2 S", Aliases: []string{"Khamas"}},
+	{Name: "Sahana", Parent: 28, Arohana: "S R2 G3 M1 P M1 D2 N2 S", Avarohana: "S N2 S D2 N2 D2 P M1 G3 M1 R2 G3 R2 S"},
+	{Name: "Surati", Parent: 28, Arohana: "S R2 M1 P N2 S", Avarohana: "S N2 D2 P M1 G3 P M1 R2 S", Aliases: []string{"Surutti"}},
+	{Name: "Dwijavanti", Parent: 28, Arohana: "S R2 G3 M1 P D2 S", Avarohana: "S N2 D2 P M1 G3 R2 S", Aliases: []string{"Dvijavanti"}},
+	{Name: "Valaji", Parent: 28, Arohana: "S G3 P D2 N2 S", Avarohana: "S N2 D2 P G3 S"},
+	{Name: "Bahudari", Parent: 28, Arohana: "S G3 M1 P D2 N2 S", Avarohana: "S N2 P M1 G3 S"},
+	{Name: "Kuntalavarali", Parent: 28, Arohana: "S M1 P D2 N2 D2 S", Avarohana: "S N2 D2 P M1 S"},
+	{Name: "Sama", Parent: 28, Arohana: "S R2 M1 P D2 S", Avarohana: "S D2 P M1 G3 R2 S", Aliases: []string{"Shama"}},
+	{Name: "Balahamsa", Parent: 28, Arohana: "S R2 M1 P D2 S", Avarohana: "S N2 D2 P M1 R2 M1 G3 R2 S"},
+	{Name: "Desh", Parent: 28, Arohana: "S R2 M1 P N3 S", Avarohana: "S N2 D2 P M1 G3 R2 G3 S", Aliases: []string{"Des"}},
+	{Name: "Saranga", Parent: 65, Arohana: "S R2 G3 M2 P D2 N3 S", Avarohana: "S N3 D2 P M2 R2 G3 M1 R2 S"},
+	{Name: "Hamirkalyani", Parent: 65, Arohana: "S P M2 P D2 N3 S", Avarohana: "S N3 D2 P M2 G3 P M1 R2 S", Aliases: []string{"Hameerkalyani"}},
+	{Name: "Yamunakalyani", Parent: 65, Arohana: "S R2 G3 P M2 P D2 S", Avarohana: "S D2 P M2 P G3 R2 S", Aliases: []string{"Yamuna Kalyani"}},
+	{Name: "Mohanakalyani", Parent: 65, Arohana: "S R2 G3 P D2 S", Avarohana: "S N3 D2 P M2 G3 R2 S"},
+	{Name: "Amritavarshini", Parent: 66, Arohana: "S G3 M2 P N3 S", Avarohana: "S N3 P M2 G3 S", Aliases: []string{"Amruthavarshini"}},
+	{Name: "Saraswati", Parent: 64, Arohana: "S R2 M2 P D2 S", Avarohana: "S N2 D2 P M2 R2 S", Aliases: []string{"Saraswathi"}},
+	{Name: "Vasanta", Parent: 17, Arohana: "S M1 G3 D2 N3 S", Avarohana: "S N3 D2 M1 G3 R1 S", Aliases: []string{"Vasantha"}},
+	{Name: "Nata", Parent: 36, Arohana: "S R3 G3 M1 P N3 S", Avarohana: "S N3 P M1 R3 S", Aliases: []string{"Nattai"}},
+	{Name: "Varali", Parent: 39, Arohana: "S G1 R1 G1 M2 P D1 N3 S", Avarohana: "S N3 D1 P M2 G1 R1 S"},
+	{Name: "Purvikalyani", Parent: 53, Arohana: "S R1 G3 M2 P D2 P S", Avarohana: "S N3 D2 P M2 G3 R1 S", Aliases: []string{"Poorvikalyani"}},
+	{Name: "Hamsanandi", Parent: 53, Arohana: "S R1 G3 M2 D2 N3 S", Avarohana: "S N3 D2 M2 G3 R1 S"},
+	{Name: "Ranjani", Parent: 59, Arohana: "S R2 G2 M2 D2 S", Avarohana: "S N3 D2 M2 G2 S R2 G2 S"},
+	{Name: "Revati", Parent: 2, Arohana: "S R1 M1 P N2 S", Avarohana: "S N2 P M1 R1 S", Aliases: []string{"Revathi"}},
+	{Name: "Kalyana Vasantham", Parent: 21, Arohana: "S G2 M1 P D1 N3 S", Avarohana: "S N3 D1 P M1 G2 R2 S"},
+	{Name: "Asaveri", Parent: 8, Arohana: "S R1 M1 P D1 S", Avarohana: "S N2 S P D1 M1 P G2 R1 S"},
+	{Name: "Punnagavarali", Parent: 8, Arohana: "N2 S R1 G2 M1 P D1 N2", Avarohana: "N2 D1 P M1 G2 R1 S N2"},
+	{Name: "Sindhubhairavi", Parent: 8, Arohana: "S R2 G2 M1 P D1 N2 S", Avarohana: "S N2 D1 P M1 G2 R1 S", Aliases: []string{"Sindhu Bhairavi"}},
+	{Name: "Dhanyasi", Parent: 8, Arohana: "S G2 M1 P N2 S", Avarohana: "S N2 D1 P M1 G2 R1 S"},
+	{Name: "Ahiri", Parent: 14, Arohana: "S R1 S G3 M1 P D1 N2 S", Avarohana: "S N2 D1 P M1 G3 R1 S"},
+}
+
+// AllRagas returns the catalog: the 72 melakartas in order, then the janya ragas.
+func AllRagas() []Raga {
+	return append(append([]Raga(nil), melakartas...), janyas...)
+}
+
+// Melakarta returns the melakarta with the given number.
+func Melakarta(n int) (Raga, bool) {
+	if n < 1 || n > 72 {
+		return Raga{}, false
+	}
+	return melakartas[n-1], true
+}
+
+// ragaIndex finds ragas by the Key of their names. A key shared by two
+// ragas finds neither, so a name is never read as the wrong raga.
+var ragaIndex = func() map[string]Raga {
+	idx := map[string]Raga{}
+	shared := map[string]bool{}
+	for _, r := range AllRagas() {
+		names := append([]string{r.Name, r.MelaName}, r.Aliases...)
+		for _, n := range names {
+			if n == "" {
+				continue
+			}
+			k := Key(n)
+			if other, taken := idx[k]; taken && other.Name != r.Name {
+				shared[k] = true
+			}
+			idx[k] = r
+		}
+	}
+	for k := range shared {
+		delete(idx, k)
+	}
+	return idx
+}()
+
+// LookupRaga finds a raga by its name, an alias, or a spelling that only
+// differs in the ways Key ignores.
+func LookupRaga(name string) (Raga, bool) {
+	r, ok := ragaIndex[Key(name)]
+	return r, ok
+}
+
+// MatchRaga finds a raga like LookupRaga and, failing that, by the closest
+// name within a small edit distance. exact is false for such fuzzy matches.
+func MatchRaga(name string) (r Raga, exact bool, ok bool) {
+	if r, ok := LookupRaga(name); ok {
+		return r, true, true
+	}
+	key := Key(name)
+	if key == "" {
+		return Raga{}, false, false
+	}
+	best, bestDist, tie := "", maxDistance(key)+1, false
+	for k := range ragaIndex {
+		d := distance(key, k)
+		switch {
+		case d < bestDist:
+			best, bestDist, tie = k, d, false
+		case d == bestDist && ragaIndex[k].Name != ragaIndex[best].Name:
+			tie = true
+		}
+	}
+	if best == "" || tie {
+		return Raga{}, false, false
+	}
+	return ragaIndex[best], false, true
+}
+
+// CanonicalRagas replaces each name with its catalog name, dropping
+// duplicates. Names that are not in the catalog are kept as given and also
+// returned in unknown; fuzzy corrections are returned in corrected as
+// "typed -> canonical".
+func CanonicalRagas(names []string) (out, unknown, corrected []string) {
+	seen := map[string]bool{}
+	for _, n := range names {
+		canonical := n
+		r, exact, ok := MatchRaga(n)
+		switch {
+		case !ok:
+			unknown = append(unknown, n)
+		case !exact:
+			corrected = append(corrected, n+" -> "+r.Name)
+			canonical = r.Name
+		default:
+			canonical = r.Name
+		}
+		if !seen[Key(canonical)] {
+			seen[Key(canonical)] = true
+			out = append(out, canonical)
+		}
+	}
+	return out, unknown, corrected
+}
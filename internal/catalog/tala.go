@@ -0,0 +1,330 @@
+package catalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Jati is a family of counts: the beats of a laghu in suladi talas, the
+// subdivisions of a beat in a nadai, and the length of a chapu tala.
+type Jati int
+
+const (
+	Tisra     Jati = 3
+	Chatusra  Jati = 4
+	Khanda    Jati = 5
+	Misra     Jati = 7
+	Sankeerna Jati = 9
+)
+
+// Jatis are the five jatis in order of count.
+var Jatis = []Jati{Tisra, Chatusra, Khanda, Misra, Sankeerna}
+
+func (j Jati) String() string {
+	switch j {
+	case Tisra:
+		return "Tisra"
+	case Chatusra:
+		return "Chatusra"
+	case Khanda:
+		return "Khanda"
+	case Misra:
+		return "Misra"
+	case Sankeerna:
+		return "Sankeerna"
+	}
+	return strconv.Itoa(int(j))
+}
+
+// jatiSpellings are the ways each jati is written.
+var jatiSpellings = map[Jati][]string{
+	Tisra:     {"Tisra", "Tishra", "Trisra", "Thisra", "Tryasra"},
+	Chatusra:  {"Chatusra", "Chaturasra", "Chatushra", "Chatursra", "Chaturashra"},
+	Khanda:    {"Khanda", "Kanda"},
+	Misra:     {"Misra", "Mishra"},
+	Sankeerna: {"Sankeerna", "Sankirna", "Samkeerna"},
+}
+
+// Anga is one limb of a suladi tala.
+type Anga struct {
+	Symbol string // "I" laghu, "O" drutam, "U" anudrutam
+	Beats  int
+}
+
+// Tala is a tala with its structure.
+type Tala struct {
+	Family string // "suladi" or "chapu"
+	Base   string // suladi tala name, e.g. "Triputa"; "Chapu" for chapu talas
+	Jati   Jati   // laghu jati of a suladi tala; the jati of a chapu tala
+	Nadai  Jati   // subdivision of each beat; Chatusra unless stated
+	Kalai  int    // beats per akshara; 1 unless stated
+	Angas  []Anga // suladi talas only
+}
+
+// suladi are the seven suladi talas with their angas ("I" stands for a laghu
+// of the jati) and the jati a bare name means.
+var suladi = []struct {
+	name        string
+	spellings   []string
+	angas       string
+	defaultJati Jati
+}{
+	{"Dhruva", []string{"Dhruva", "Dhruvam"}, "I O I I", Chatusra},
+	{"Matya", []string{"Matya", "Mathya", "Matyam", "Madhya"}, "I O I", Chatusra},
+	{"Rupaka", []string{"Rupaka", "Rupakam", "Roopaka", "Roopakam"}, "O I", Chatusra},
+	{"Jhampa", []string{"Jhampa", "Jampa", "Jhumpa"}, "I U O", Misra},
+	{"Triputa", []string{"Triputa", "Triputai", "Thriputa"}, "I O O", Tisra},
+	{"Ata", []string{"Ata", "Atta", "Attam"}, "I I O O", Khanda},
+	{"Eka", []string{"Eka", "Ekam"}, "I", Chatusra},
+}
+
+// chapuParts is how the beats of a chapu tala are grouped.
+var chapuParts = map[Jati]string{Tisra: "1+2", Khanda: "2+3", Misra: "3+4", Sankeerna: "4+5"}
+
+func newSuladi(i int, jati Jati) Tala {
+	s := suladi[i]
+	t := Tala{Family: "suladi", Base: s.name, Jati: jati, Nadai: Chatusra, Kalai: 1}
+	for _, a := range strings.Fields(s.angas) {
+		switch a {
+		case "I":
+			t.Angas = append(t.Angas, Anga{Symbol: "I", Beats: int(jati)})
+		case "O":
+			t.Angas = append(t.Angas, Anga{Symbol: "O", Beats: 2})
+		case "U":
+			t.Angas = append(t.Angas, Anga{Symbol: "U", Beats: 1})
+		}
+	}
+	return t
+}
+
+func newChapu(jati Jati) Tala {
+	return Tala{Family: "chapu", Base: "Chapu", Jati: jati, Nadai: Chatusra, Kalai: 1}
+}
+
+// SuladiTalas returns the 35 suladi talas: each of the seven talas in each
+// of the five jatis.
+func SuladiTalas() []Tala {
+	var out []Tala
+	for i := range suladi {
+		for _, j := range Jatis {
+			out = append(out, newSuladi(i, j))
+		}
+	}
+	return out
+}
+
+// ChapuTalas returns the chapu talas.
+func ChapuTalas() []Tala {
+	return []Tala{newChapu(Tisra), newChapu(Khanda), newChapu(Misra), newChapu(Sankeerna)}
+}
+
+// Aksharas is the number of beats in one cycle of the tala.
+func (t Tala) Aksharas() int {
+	if t.Family == "chapu" {
+		return int(t.Jati)
+	}
+	n := 0
+	for _, a := range t.Angas {
+		n += a.Beats
+	}
+	return n
+}
+
+// Structure shows the angas, e.g. "I4 O O" for Adi, or the beat groups of a
+// chapu tala, e.g. "3+4".
+func (t Tala) Structure() string {
+	if t.Family == "chapu" {
+		return chapuParts[t.Jati]
+	}
+	var parts []string
+	for _, a := range t.Angas {
+		if a.Symbol == "I" {
+			parts = append(parts, "I"+strconv.Itoa(a.Beats))
+		} else {
+			parts = append(parts, a.Symbol)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Name is the canonical label of the tala, used in reports and folder names:
+// "Adi", "Rupakam", "Misra Chapu", "Khanda Jati Ata", with the nadai and
+// kalai added when they are not the usual ones, e.g. "Adi (2 Kalai, Tisra Nadai)".
+func (t Tala) Name() string {
+	var name string
+	switch {
+	case t.Family == "chapu":
+		name = t.Jati.String() + " Chapu"
+	case t.Base == "Triputa" && t.Jati == Chatusra:
+		name = "Adi"
+	case t.Base == "Rupaka" && t.Jati == Chatusra:
+		name = "Rupakam"
+	default:
+		name = t.Jati.String() + " Jati " + t.Base
+	}
+	var extra []string
+	if t.Kalai > 1 {
+		extra = append(extra, fmt.Sprintf("%d Kalai", t.Kalai))
+	}
+	if t.Nadai != 0 && t.Nadai != Chatusra {
+		extra = append(extra, t.Nadai.String()+" Nadai")
+	}
+	if len(extra) > 0 {
+		name += " (" + strings.Join(extra, ", ") + ")"
+	}
+	return name
+}
+
+func (t Tala) String() string {
+	return fmt.Sprintf("%s [%s, %d aksharas]", t.Name(), t.Structure(), t.Aksharas())
+}
+
+// talaFiller are words that carry no information in a tala name.
+var talaFiller = []string{"tala", "talam", "jati", "jaati", "jathi"}
+
+// ParseTala reads a tala name as people write it: "Adi", "Aadi talam",
+// "Chatusra jati Triputa", "Rupakam", "Misra Chapu", "Khanda Ata",
+// "Adi tisra nadai", "Adi 2 kalai". A suladi tala named without a jati takes
+// its usual one, so "Jhampa" is Misra Jati Jhampa.
+func ParseTala(name string) (Tala, bool) {
+	words := strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	var tokens []string
+	for _, w := range words {
+		if k := Key(w); !keyIn(k, talaFiller...) && k != "" || isNumber(w) {
+			tokens = append(tokens, w)
+		}
+	}
+
+	var jati, nadai Jati
+	kalai := 1
+	base := ""
+	for i := 0; i < len(tokens); i++ {
+		w := tokens[i]
+		next := ""
+		if i+1 < len(tokens) {
+			next = Key(tokens[i+1])
+		}
+		if n, err := strconv.Atoi(w); err == nil {
+			if !keyIn(next, "kalai", "kala") || n < 1 || n > 4 {
+				return Tala{}, false
+			}
+			kalai = n
+			i++
+			continue
+		}
+		if j, ok := parseJati(w); ok {
+			if keyIn(next, "nadai", "nadi", "gati", "gatti") {
+				nadai = j
+				i++
+				continue
+			}
+			if jati != 0 {
+				return Tala{}, false
+			}
+			jati = j
+			continue
+		}
+		if base != "" {
+			return Tala{}, false
+		}
+		switch k := Key(w); {
+		case keyIn(k, "adi"):
+			base = "Adi"
+		case keyIn(k, "chapu"):
+			base = "Chapu"
+		default:
+			for _, s := range suladi {
+				for _, sp := range s.spellings {
+					if Key(sp) == k {
+						base = s.name
+					}
+				}
+			}
+			if base == "" {
+				return Tala{}, false
+			}
+		}
+	}
+
+	var t Tala
+	switch base {
+	case "":
+		return Tala{}, false
+	case "Adi":
+		if jati != 0 && jati != Chatusra {
+			return Tala{}, false
+		}
+		t = newSuladi(4, Chatusra)
+	case "Chapu":
+		if jati == 0 {
+			jati = Misra
+		}
+		if jati == Chatusra {
+			return Tala{}, false
+		}
+		t = newChapu(jati)
+	default:
+		for i, s := range suladi {
+			if s.name == base {
+				if jati == 0 {
+					jati = s.defaultJati
+				}
+				t = newSuladi(i, jati)
+			}
+		}
+	}
+	if nadai != 0 {
+		t.Nadai = nadai
+	}
+	t.Kalai = kalai
+	return t, true
+}
+
+func parseJati(w string) (Jati, bool) {
+	k := Key(w)
+	for j, spellings := range jatiSpellings {
+		for _, s := range spellings {
+			if Key(s) == k {
+				return j, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// keyIn reports whether key is the Key of one of words.
+func keyIn(key string, words ...string) bool {
+	for _, w := range words {
+		if Key(w) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// CanonicalTalas replaces each name with its canonical label, dropping
+// duplicates. Names that cannot be read are kept as given and also returned
+// in unknown.
+func CanonicalTalas(names []string) (out, unknown []string) {
+	seen := map[string]bool{}
+	for _, n := range names {
+		label := n
+		if t, ok := ParseTala(n); ok {
+			label = t.Name()
+		} else {
+			unknown = append(unknown, n)
+		}
+		if !seen[Key(label)] {
+			seen[Key(label)] = true
+			out = append(out, label)
+		}
+	}
+	return out, unknown
+}
@@ -9,10 +9,17 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
+
+	"musicloud/internal/metadata"
+	"musicloud/internal/translit"
 )
 
 var (
@@ -112,6 +119,150 @@ func UploadFile(filePath string, folderID string) error {
 	return nil
 }
 
+// UploadFileWithMetadata uploads a file to Google Drive and records the
+// recording metadata in the file's description and appProperties. It returns
+// the ID of the new Drive file.
+func UploadFileWithMetadata(filePath string, folderID string, meta *metadata.Metadata) (string, error) {
+	return UploadFileAs(filePath, filepath.Base(filePath), folderID, meta)
+}
+
+// UploadFileAs is UploadFileWithMetadata, naming the Drive file name. When
+// that differs from the local file's name, the local name is kept in the
+// OriginalName appProperty.
+func UploadFileAs(filePath, name, folderID string, meta *metadata.Metadata) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to open file: %v", err)
+	}
+	defer file.Close()
+
+	fileMetadata := &drive.File{
+		Name:          name,
+		Parents:       []string{folderID},
+		Description:   Description(meta),
+		AppProperties: AppProperties(meta),
+	}
+	if original := filepath.Base(filePath); original != name {
+		fileMetadata.AppProperties[OriginalName] = truncateUTF8(original, maxAppProperty-len(OriginalName))
+	}
+
+	f, err := driveService.Files.Create(fileMetadata).Media(file).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to upload file: %v", err)
+	}
+
+	fmt.Printf("File uploaded successfully: %s\n", f.WebViewLink)
+	return f.Id, nil
+}
+
+// maxAppProperty is the Drive limit on the combined size of an appProperties
+// key and value, in bytes.
+const maxAppProperty = 124
+
+// AppProperties returns the metadata as Drive appProperties, which are private
+// to this application and searchable.
+func AppProperties(meta *metadata.Metadata) map[string]string {
+	props := map[string]string{}
+	set := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if limit := maxAppProperty - len(key); len(value) > limit {
+			value = truncateUTF8(value, limit)
+		}
+		props[key] = value
+	}
+	if meta == nil {
+		return props
+	}
+	set("group", meta.GroupName)
+	set("teacher", meta.Teacher)
+	set("session_type", meta.SessionType)
+	set("songs", strings.Join(meta.SongsTaught, "; "))
+	set("ragas", strings.Join(meta.Ragas, "; "))
+	set("talas", strings.Join(meta.Talas, "; "))
+	set("composers", strings.Join(meta.Composers, "; "))
+	if !meta.RecordedAt.IsZero() {
+		set("recorded_at", meta.RecordedAt.Format(time.RFC3339))
+		set("date_source", meta.DateSource)
+	}
+	if meta.Duration > 0 {
+		set("duration", meta.Duration.String())
+	}
+	set("performers", strings.Join(meta.Performers, "; "))
+	set("instruments", strings.Join(meta.Instruments, "; "))
+	if meta.Lesson > 0 {
+		set("lesson", strconv.Itoa(meta.Lesson))
+	}
+	set("source", meta.Source)
+	set("tags", strings.Join(meta.Tags, "; "))
+	set("schema", strconv.Itoa(metadata.SchemaVersion))
+	// Values in Indian scripts also get a Latin copy, so they can be found
+	// by the everyday spelling.
+	for _, key := range []string{"group", "teacher", "songs", "ragas", "talas", "composers", "performers"} {
+		if v, ok := props[key]; ok && translit.HasIndic(v) {
+			set(key+"_latin", translit.ASCII(v))
+		}
+	}
+	return props
+}
+
+// Description returns a human-readable summary of the metadata for the Drive
+// file description.
+func Description(meta *metadata.Metadata) string {
+	if meta == nil {
+		return ""
+	}
+	var lines []string
+	add := func(label string, values ...string) {
+		var kept []string
+		for _, v := range values {
+			if v != "" {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) > 0 {
+			lines = append(lines, label+": "+strings.Join(kept, ", "))
+		}
+	}
+	add("Group", meta.GroupName)
+	add("Teacher", meta.Teacher)
+	add("Session", meta.SessionType)
+	add("Songs", meta.SongsTaught...)
+	add("Ragas", meta.Ragas...)
+	add("Talas", meta.Talas...)
+	add("Composers", meta.Composers...)
+	if !meta.RecordedAt.IsZero() {
+		recorded := meta.RecordedAt.Format("2006-01-02 15:04")
+		if meta.DateSource != "" {
+			recorded += " (from " + meta.DateSource + ")"
+		}
+		add("Recorded", recorded)
+	}
+	if meta.Duration > 0 {
+		add("Duration", meta.Duration.String())
+	}
+	add("Performers", meta.Performers...)
+	add("Instruments", meta.Instruments...)
+	if meta.Lesson > 0 {
+		add("Lesson", strconv.Itoa(meta.Lesson))
+	}
+	add("Source", meta.Source)
+	add("Tags", meta.Tags...)
+	return strings.Join(lines, "\n")
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
 // GetCredentialsFile returns the path to the credentials file from the MUSICLOUD_CONFIG environment variable, or an error if not set.
 func GetCredentialsFile() (string, error) {
 	path := os.Getenv("MUSICLOUD_CONFIG")
@@ -147,7 +298,12 @@ func GetOrCreateFolderID(service *drive.Service, folderName string) (string, err
 	return created.Id, nil
 }
 
+// escapeQuery escapes a value for use inside a quoted Drive query string.
+func escapeQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
 // GetDriveService returns the initialized Google Drive service instance.
 func GetDriveService() *drive.Service {
 	return driveService
-}
\ No newline at end of file
+}
@@ -3,7 +3,13 @@ package drive
 import (
 	"context"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"musicloud/internal/metadata"
 )
 
 func getEnvWithDefault(key, def string) string {
@@ -72,3 +78,52 @@ func TestGoogleDriveFolderName_Default(t *testing.T) {
 		t.Errorf("expected default 'Recordings', got %s", name)
 	}
 }
+
+func TestAppProperties(t *testing.T) {
+	meta := &metadata.Metadata{GroupName: "Veena Class", Teacher: "Lakshmi", Ragas: []string{"Thodi", "Kalyani"}, SongsTaught: []string{strings.Repeat("x", 200)}}
+	props := AppProperties(meta)
+	if props["group"] != "Veena Class" || props["teacher"] != "Lakshmi" || props["ragas"] != "Thodi; Kalyani" {
+		t.Errorf("unexpected properties: %v", props)
+	}
+	if len("songs")+len(props["songs"]) > maxAppProperty {
+		t.Errorf("expected songs to be truncated to the Drive limit, got %d bytes", len(props["songs"]))
+	}
+	if _, ok := props["talas"]; ok {
+		t.Error("expected empty fields to be omitted")
+	}
+	if _, ok := props["ragas_latin"]; ok {
+		t.Error("expected no Latin copy of Latin values")
+	}
+
+	recorded := time.Date(2024, 3, 5, 18, 30, 0, 0, time.UTC)
+	full := &metadata.Metadata{RecordedAt: recorded, Duration: metadata.Duration(45 * time.Minute), Lesson: 12, Source: metadata.SourceZoom, Performers: []string{"Ravi"}}
+	props = AppProperties(full)
+	if props["recorded_at"] != "2024-03-05T18:30:00Z" || props["duration"] != "45m0s" || props["lesson"] != "12" || props["source"] != "zoom" || props["schema"] != "3" {
+		t.Errorf("unexpected properties for the newer fields: %v", props)
+	}
+	if desc := Description(full); !strings.Contains(desc, "Recorded: 2024-03-05 18:30") || !strings.Contains(desc, "Performers: Ravi") {
+		t.Errorf("unexpected description %q", desc)
+	}
+
+	props = AppProperties(&metadata.Metadata{Teacher: "లక్ష్మి", Ragas: []string{"கல்யாணி"}})
+	if props["teacher"] != "లక్ష్మి" || props["teacher_latin"] != "lakshmi" || props["ragas_latin"] != "kalyani" {
+		t.Errorf("expected Latin copies of values in Indian scripts, got %v", props)
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	remote := NewMockRemote()
+	self, _ := remote.Create(&drive.File{Name: "2024-03-05_Kalyani.m4a", Parents: []string{"root"}}, nil)
+	if got, _ := UniqueName(remote, "root", "2024-03-05_Kalyani.m4a", self.Id); got != "2024-03-05_Kalyani.m4a" {
+		t.Errorf("expected a file to keep its own name, got %q", got)
+	}
+	remote.Create(&drive.File{Name: "2024-03-05_Kalyani_2.m4a", Parents: []string{"root"}}, nil)
+	for i := 0; i < 2; i++ {
+		if got, _ := UniqueName(remote, "root", "2024-03-05_Kalyani.m4a", ""); got != "2024-03-05_Kalyani_3.m4a" {
+			t.Errorf("expected the first free suffix, got %q", got)
+		}
+	}
+	if got, _ := UniqueName(remote, "root", "Todi.m4a", ""); got != "Todi.m4a" {
+		t.Errorf("expected a free name to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,221 @@
+package drive
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// MockRemote is an in-memory Drive for tests. Files keep their content and
+// the number of revisions uploaded.
+type MockRemote struct {
+	Files     map[string]*drive.File
+	Content   map[string][]byte
+	Revisions map[string]int
+	// Calls counts the calls that change Drive, by method.
+	Calls  map[string]int
+	nextID int
+}
+
+// NewMockRemote returns an empty MockRemote holding only the folder "root".
+func NewMockRemote() *MockRemote {
+	return &MockRemote{
+		Files:     map[string]*drive.File{"root": {Id: "root", Name: "My Drive", MimeType: FolderMimeType}},
+		Content:   map[string][]byte{},
+		Revisions: map[string]int{},
+		Calls:     map[string]int{},
+	}
+}
+
+func (m *MockRemote) Get(id string) (*drive.File, error) {
+	f, ok := m.Files[id]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", id)
+	}
+	return copyFile(f), nil
+}
+
+func (m *MockRemote) Find(parentID, name, mimeType string) ([]*drive.File, error) {
+	var out []*drive.File
+	for _, id := range m.sortedIDs() {
+		f := m.Files[id]
+		if f.Name == name && !f.Trashed && hasParent(f, parentID) && (mimeType == "" || f.MimeType == mimeType) {
+			out = append(out, copyFile(f))
+		}
+	}
+	return out, nil
+}
+
+// Children lists the files directly in a folder, in creation order.
+func (m *MockRemote) Children(parentID string) []*drive.File {
+	var out []*drive.File
+	for _, id := range m.sortedIDs() {
+		if f := m.Files[id]; hasParent(f, parentID) && !f.Trashed {
+			out = append(out, copyFile(f))
+		}
+	}
+	return out
+}
+
+func (m *MockRemote) List(parentID string) ([]*drive.File, error) {
+	return m.Children(parentID), nil
+}
+
+func (m *MockRemote) Shortcuts(targetID string) ([]*drive.File, error) {
+	var out []*drive.File
+	for _, id := range m.sortedIDs() {
+		f := m.Files[id]
+		if f.MimeType == ShortcutMimeType && !f.Trashed && f.AppProperties[ShortcutOf] == targetID {
+			out = append(out, copyFile(f))
+		}
+	}
+	return out, nil
+}
+
+func (m *MockRemote) Create(f *drive.File, media io.Reader) (*drive.File, error) {
+	m.Calls["Create"]++
+	m.nextID++
+	created := copyFile(f)
+	created.Id = fmt.Sprintf("id%03d", m.nextID)
+	m.Files[created.Id] = created
+	if media != nil {
+		b, err := ioutil.ReadAll(media)
+		if err != nil {
+			return nil, err
+		}
+		m.setContent(created, b)
+		m.Revisions[created.Id] = 1
+	}
+	return copyFile(created), nil
+}
+
+func (m *MockRemote) Update(id string, f *drive.File, addParents, removeParents string, clear []string, media io.Reader) (*drive.File, error) {
+	m.Calls["Update"]++
+	existing, ok := m.Files[id]
+	if !ok {
+		return nil, fmt.Errorf("file %s not found", id)
+	}
+	if f.Name != "" {
+		existing.Name = f.Name
+	}
+	if f.Description != "" {
+		existing.Description = f.Description
+	}
+	if f.Trashed {
+		existing.Trashed = true
+	}
+	for _, field := range f.ForceSendFields {
+		if field == "Trashed" {
+			existing.Trashed = f.Trashed // restored from the trash
+		}
+	}
+	if len(f.AppProperties) > 0 && existing.AppProperties == nil {
+		existing.AppProperties = map[string]string{}
+	}
+	for k, v := range f.AppProperties {
+		existing.AppProperties[k] = v
+	}
+	for _, k := range clear {
+		delete(existing.AppProperties, k)
+	}
+	for _, p := range splitIDs(removeParents) {
+		if !hasParent(existing, p) {
+			return nil, fmt.Errorf("file %s is not in %s", id, p)
+		}
+		var kept []string
+		for _, q := range existing.Parents {
+			if q != p {
+				kept = append(kept, q)
+			}
+		}
+		existing.Parents = kept
+	}
+	for _, p := range splitIDs(addParents) {
+		if _, ok := m.Files[p]; !ok {
+			return nil, fmt.Errorf("parent %s not found", p)
+		}
+		if !hasParent(existing, p) {
+			existing.Parents = append(existing.Parents, p)
+		}
+	}
+	if media != nil {
+		b, err := ioutil.ReadAll(media)
+		if err != nil {
+			return nil, err
+		}
+		m.setContent(existing, b)
+		m.Revisions[id]++
+	}
+	return copyFile(existing), nil
+}
+
+func (m *MockRemote) Download(id string) (io.ReadCloser, error) {
+	b, ok := m.Content[id]
+	if !ok {
+		return nil, fmt.Errorf("file %s has no content", id)
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+// setContent stores a file's content and, as Drive does, its size and
+// checksum.
+func (m *MockRemote) setContent(f *drive.File, b []byte) {
+	m.Content[f.Id] = b
+	sum := md5.Sum(b)
+	f.Md5Checksum, f.Size = hex.EncodeToString(sum[:]), int64(len(b))
+}
+
+// sortedIDs returns the file IDs in creation order.
+func (m *MockRemote) sortedIDs() []string {
+	ids := []string{}
+	if _, ok := m.Files["root"]; ok {
+		ids = append(ids, "root")
+	}
+	for i := 1; i <= m.nextID; i++ {
+		if id := fmt.Sprintf("id%03d", i); m.Files[id] != nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func hasParent(f *drive.File, parentID string) bool {
+	for _, p := range f.Parents {
+		if p == parentID {
+			return true
+		}
+	}
+	return false
+}
+
+func splitIDs(s string) []string {
+	var out []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func copyFile(f *drive.File) *drive.File {
+	c := *f
+	c.Parents = append([]string(nil), f.Parents...)
+	if f.ShortcutDetails != nil {
+		details := *f.ShortcutDetails
+		c.ShortcutDetails = &details
+	}
+	if f.AppProperties != nil {
+		c.AppProperties = map[string]string{}
+		for k, v := range f.AppProperties {
+			c.AppProperties[k] = v
+		}
+	}
+	return &c
+}
@@ -0,0 +1,160 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// FolderMimeType is the MIME type of Drive folders.
+const FolderMimeType = "application/vnd.google-apps.folder"
+
+// ShortcutMimeType is the MIME type of Drive shortcuts, which point at a
+// file without copying it.
+const ShortcutMimeType = "application/vnd.google-apps.shortcut"
+
+// ShortcutOf is the appProperty that holds the ID of the file a shortcut
+// made by musicloud points at. Drive cannot search by a shortcut's target,
+// but it can by appProperties.
+const ShortcutOf = "shortcut_of"
+
+// fileFields are the file fields musicloud reads back from Drive.
+const fileFields = "id, name, mimeType, parents, description, appProperties, md5Checksum, size, shortcutDetails, trashed"
+
+// OriginalName is the appProperty that holds the name a file had before
+// musicloud renamed it, such as "AUD-20240105-WA0003.opus".
+const OriginalName = "original_name"
+
+// Remote is the part of Google Drive musicloud works with after upload.
+// Service implements it on the Drive API; MockRemote stands in for it in
+// tests.
+type Remote interface {
+	// Get returns a file's metadata.
+	Get(id string) (*drive.File, error)
+	// Find lists the files named name directly in parent, of the given MIME
+	// type if it is not empty, oldest first. Trashed files are left out.
+	Find(parentID, name, mimeType string) ([]*drive.File, error)
+	// List lists the files directly in parent, oldest first. Trashed files
+	// are left out.
+	List(parentID string) ([]*drive.File, error)
+	// Shortcuts lists the shortcuts whose ShortcutOf appProperty is
+	// targetID. Trashed shortcuts are left out.
+	Shortcuts(targetID string) ([]*drive.File, error)
+	// Create makes a file, with content if media is not nil.
+	Create(f *drive.File, media io.Reader) (*drive.File, error)
+	// Update changes the fields set in f, moves the file between the given
+	// parents (comma-separated, may be empty) and, if media is not nil,
+	// uploads it as a new revision. Properties listed in clear are removed.
+	Update(id string, f *drive.File, addParents, removeParents string, clear []string, media io.Reader) (*drive.File, error)
+	// Download opens a file's content.
+	Download(id string) (io.ReadCloser, error)
+}
+
+// Service implements Remote on the Drive API.
+type Service struct {
+	*drive.Service
+}
+
+// NewService returns the initialized Drive service as a Remote.
+func NewService() (*Service, error) {
+	if driveService == nil {
+		return nil, fmt.Errorf("drive service is not initialized")
+	}
+	return &Service{driveService}, nil
+}
+
+func (s *Service) Get(id string) (*drive.File, error) {
+	return s.Files.Get(id).Fields(fileFields).Do()
+}
+
+func (s *Service) Find(parentID, name, mimeType string) ([]*drive.File, error) {
+	query := fmt.Sprintf("name='%s' and '%s' in parents and trashed=false", escapeQuery(name), escapeQuery(parentID))
+	if mimeType != "" {
+		query += fmt.Sprintf(" and mimeType='%s'", escapeQuery(mimeType))
+	}
+	list, err := s.Files.List().Q(query).OrderBy("createdTime").Fields("files(" + fileFields + ")").Do()
+	if err != nil {
+		return nil, err
+	}
+	return list.Files, nil
+}
+
+func (s *Service) List(parentID string) ([]*drive.File, error) {
+	return s.list(fmt.Sprintf("'%s' in parents and trashed=false", escapeQuery(parentID)))
+}
+
+func (s *Service) Shortcuts(targetID string) ([]*drive.File, error) {
+	return s.list(fmt.Sprintf("appProperties has { key='%s' and value='%s' } and mimeType='%s' and trashed=false",
+		ShortcutOf, escapeQuery(targetID), ShortcutMimeType))
+}
+
+// list returns every file matching query, across result pages.
+func (s *Service) list(query string) ([]*drive.File, error) {
+	var files []*drive.File
+	err := s.Files.List().Q(query).OrderBy("createdTime").Fields("nextPageToken, files("+fileFields+")").
+		Pages(context.Background(), func(page *drive.FileList) error {
+			files = append(files, page.Files...)
+			return nil
+		})
+	return files, err
+}
+
+func (s *Service) Create(f *drive.File, media io.Reader) (*drive.File, error) {
+	call := s.Files.Create(f).Fields(fileFields)
+	if media != nil {
+		call = call.Media(media)
+	}
+	return call.Do()
+}
+
+func (s *Service) Update(id string, f *drive.File, addParents, removeParents string, clear []string, media io.Reader) (*drive.File, error) {
+	for _, key := range clear {
+		f.NullFields = append(f.NullFields, "AppProperties."+key)
+	}
+	call := s.Files.Update(id, f).Fields(fileFields)
+	if addParents != "" {
+		call = call.AddParents(addParents)
+	}
+	if removeParents != "" {
+		call = call.RemoveParents(removeParents)
+	}
+	if media != nil {
+		call = call.Media(media)
+	}
+	return call.Do()
+}
+
+func (s *Service) Download(id string) (io.ReadCloser, error) {
+	resp, err := s.Files.Get(id).Download()
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// UniqueName returns name, or name with "_2", "_3" and so on before its
+// extension, whichever no other file in the folder has. The file selfID,
+// if it is in the folder, does not count, so a file keeps its own name.
+func UniqueName(r Remote, folderID, name, selfID string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 2; ; n++ {
+		found, err := r.Find(folderID, candidate, "")
+		if err != nil {
+			return "", fmt.Errorf("error searching for %q: %v", candidate, err)
+		}
+		taken := false
+		for _, f := range found {
+			taken = taken || f.Id != selfID
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s_%d%s", base, n, ext)
+	}
+}
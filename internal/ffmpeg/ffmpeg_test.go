@@ -0,0 +1,84 @@
+package ffmpeg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"musicloud/internal/metadata"
+)
+
+func TestTags(t *testing.T) {
+	m := metadata.Metadata{
+		GroupName:   "Veena Class",
+		Teacher:     "Lakshmi Raman",
+		SongsTaught: []string{"Vatapi Ganapatim", "Raghuvamsa Sudha"},
+		Ragas:       []string{"Hamsadhwani", "Kadanakuthuhalam"},
+		Talas:       []string{"Adi"},
+		Composers:   []string{"Muthuswami Dikshitar", "Patnam Subramania Iyer"},
+	}
+	date := time.Date(2024, 1, 5, 18, 30, 0, 0, time.UTC)
+	want := [][2]string{
+		{"title", "Vatapi Ganapatim / Raghuvamsa Sudha"},
+		{"artist", "Lakshmi Raman"},
+		{"date", "2024-01-05"},
+		{"album", "Veena Class 2024-01-05"},
+		{"genre", "Hamsadhwani, Kadanakuthuhalam"},
+		{"comment", "Tala: Adi; Composer: Muthuswami Dikshitar, Patnam Subramania Iyer"},
+	}
+	if got := Tags(m, date); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags = %v, want %v", got, want)
+	}
+	if got := Tags(metadata.Metadata{}, time.Time{}); len(got) != 0 {
+		t.Errorf("expected no tags for empty metadata, got %v", got)
+	}
+}
+
+func TestTagArgs(t *testing.T) {
+	tagging := Tagging{Metadata: metadata.Metadata{Teacher: "Lakshmi"}, Cover: "cover.jpg"}
+	got := strings.Join(tagArgs("in.m4a", "out.m4a", tagging, "chapters.txt", []string{"-codec:a", "copy"}), " ")
+	want := "-y -i in.m4a -i cover.jpg -f ffmetadata -i chapters.txt -map 0:a -map 1:v -disposition:v:0 attached_pic -map_chapters 2 -codec:a copy -codec:v copy -metadata artist=Lakshmi out.m4a"
+	if got != want {
+		t.Errorf("tagArgs =\n%s\nwant\n%s", got, want)
+	}
+
+	got = strings.Join(tagArgs("in.mkv", "in.mkv.mp4", Tagging{}, "", []string{"-codec:a", "aac"}), " ")
+	want = "-y -i in.mkv -map 0:a -map 0:v? -codec:a aac in.mkv.mp4"
+	if got != want {
+		t.Errorf("tagArgs =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestChapterFile(t *testing.T) {
+	chapters := []metadata.Chapter{{Start: 0, Title: "Varnam; Kalyani"}, {Start: 90 * time.Second, Title: "Vatapi Ganapatim"}}
+	got := ChapterFile(chapters, 5*time.Minute)
+	want := ";FFMETADATA1\n\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=90000\ntitle=Varnam\\; Kalyani\n" +
+		"\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=90000\nEND=300000\ntitle=Vatapi Ganapatim\n"
+	if got != want {
+		t.Errorf("ChapterFile = %q, want %q", got, want)
+	}
+	// Without the length, the last chapter is left empty.
+	if got := ChapterFile(chapters[1:], 0); !strings.Contains(got, "START=90000\nEND=90000\n") {
+		t.Errorf("unexpected chapter file %q", got)
+	}
+}
+
+func TestParseCreationTime(t *testing.T) {
+	got, ok := parseCreationTime("\n1970-01-01T00:00:00.000000Z\n2024-03-05T13:00:12.000000Z\n")
+	if !ok || !got.Equal(time.Date(2024, 3, 5, 13, 0, 12, 0, time.UTC)) {
+		t.Errorf("got %v, %v", got, ok)
+	}
+	if _, ok := parseCreationTime("1904-01-01T00:00:00Z\n"); ok {
+		t.Error("expected an unset creation time to be skipped")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	if got, ok := parseDuration("2531.413000\n"); !ok || got.Round(time.Second) != 42*time.Minute+11*time.Second {
+		t.Errorf("got %v, %v", got, ok)
+	}
+	if _, ok := parseDuration("N/A\n"); ok {
+		t.Error("expected an unknown duration to be rejected")
+	}
+}
@@ -0,0 +1,77 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IsFFprobeInstalled checks if ffprobe, which comes with FFmpeg, is on the PATH.
+func IsFFprobeInstalled() bool {
+	_, err := exec.LookPath("ffprobe")
+	return err == nil
+}
+
+// CreationTime reads the creation_time the recording device wrote into a
+// media file's container, or into its first stream when the container has
+// none.
+func CreationTime(path string) (time.Time, error) {
+	if !IsFFprobeInstalled() {
+		return time.Time{}, fmt.Errorf("ffprobe not found")
+	}
+	out, err := exec.Command("ffprobe", "-v", "error",
+		"-show_entries", "format_tags=creation_time:stream_tags=creation_time",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ffprobe failed on %s: %v", path, err)
+	}
+	t, ok := parseCreationTime(string(out))
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s has no creation time", path)
+	}
+	return t, nil
+}
+
+// parseCreationTime reads the first usable time in ffprobe's output. Files
+// whose recorder never set the time carry the MP4 or Unix epoch, which is
+// skipped.
+func parseCreationTime(out string) (time.Time, bool) {
+	for _, line := range strings.Split(out, "\n") {
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(line))
+		if err != nil || t.Year() <= 1970 {
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// Duration reads the length of a media file.
+func Duration(path string) (time.Duration, error) {
+	if !IsFFprobeInstalled() {
+		return 0, fmt.Errorf("ffprobe not found")
+	}
+	out, err := exec.Command("ffprobe", "-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed on %s: %v", path, err)
+	}
+	d, ok := parseDuration(string(out))
+	if !ok {
+		return 0, fmt.Errorf("%s has no duration", path)
+	}
+	return d, nil
+}
+
+// parseDuration reads ffprobe's duration in seconds, such as "2531.413000".
+// Streams without a known length print "N/A".
+func parseDuration(out string) (time.Duration, bool) {
+	secs, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
@@ -0,0 +1,167 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"musicloud/internal/metadata"
+)
+
+// Tagging is what is written into a file's container besides the audio.
+type Tagging struct {
+	// Metadata gives the tags, and its chapters mark the parts of the
+	// recording.
+	Metadata metadata.Metadata
+	// Date is when the recording was made; it is added to the album and
+	// written as the date tag when set.
+	Date time.Time
+	// Cover is an optional JPEG or PNG image attached as cover art.
+	Cover string
+}
+
+// Tags maps recording metadata to the standard tags music players show:
+// title from the songs, artist from the teacher, album from the group and
+// date, genre from the ragas, and a comment with the talas and composers.
+func Tags(m metadata.Metadata, date time.Time) [][2]string {
+	var tags [][2]string
+	add := func(key, value string) {
+		if value != "" {
+			tags = append(tags, [2]string{key, value})
+		}
+	}
+	add("title", strings.Join(m.SongsTaught, " / "))
+	add("artist", m.Teacher)
+	album := m.GroupName
+	if !date.IsZero() {
+		album = strings.TrimSpace(album + " " + date.Format("2006-01-02"))
+		add("date", date.Format("2006-01-02"))
+	}
+	add("album", album)
+	add("genre", strings.Join(m.Ragas, ", "))
+	var comment []string
+	if len(m.Talas) > 0 {
+		comment = append(comment, "Tala: "+strings.Join(m.Talas, ", "))
+	}
+	if len(m.Composers) > 0 {
+		comment = append(comment, "Composer: "+strings.Join(m.Composers, ", "))
+	}
+	add("comment", strings.Join(comment, "; "))
+	return tags
+}
+
+// ConvertWithTags converts inputFile to MP4 like ConvertToMP4 and writes the
+// tags, cover and chapters into the result. The picture of a video is copied as it
+// is, or re-encoded when MP4 cannot hold its codec.
+func ConvertWithTags(inputFile, outputFile string, t Tagging) error {
+	audio := []string{"-codec:a", "aac", "-b:a", "192k"}
+	if err := run(inputFile, outputFile, t, append([]string{"-codec:v", "copy"}, audio...)); err == nil || t.Cover != "" {
+		return err
+	}
+	return run(inputFile, outputFile, t, audio)
+}
+
+// WriteTags copies inputFile to outputFile without re-encoding, adding the
+// tags, cover and chapters. It is used for files that are already MP4.
+func WriteTags(inputFile, outputFile string, t Tagging) error {
+	return run(inputFile, outputFile, t, []string{"-codec:v", "copy", "-codec:a", "copy"})
+}
+
+// CanCopy reports whether a file can be tagged by copying its streams into an
+// MP4 container, without converting it.
+func CanCopy(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4a":
+		return true
+	}
+	return false
+}
+
+func run(inputFile, outputFile string, t Tagging, codec []string) error {
+	var chapters string
+	if len(t.Metadata.Chapters) > 0 {
+		end := time.Duration(t.Metadata.Duration)
+		if end == 0 {
+			end, _ = Duration(inputFile)
+		}
+		f, err := os.CreateTemp("", "musicloud-chapters-*.txt")
+		if err != nil {
+			return err
+		}
+		chapters = f.Name()
+		defer os.Remove(chapters)
+		_, err = f.WriteString(ChapterFile(t.Metadata.Chapters, end))
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	out, err := exec.Command("ffmpeg", tagArgs(inputFile, outputFile, t, chapters, codec)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg failed on %s: %v: %s", inputFile, err, lastLine(out))
+	}
+	return nil
+}
+
+// tagArgs builds the ffmpeg command line; chapters is the path of an
+// FFMETADATA file, or empty, and codec chooses the encoders.
+func tagArgs(inputFile, outputFile string, t Tagging, chapters string, codec []string) []string {
+	args := []string{"-y", "-i", inputFile}
+	meta := "1"
+	if t.Cover != "" {
+		args = append(args, "-i", t.Cover)
+		meta = "2"
+	}
+	if chapters != "" {
+		args = append(args, "-f", "ffmetadata", "-i", chapters)
+	}
+	if t.Cover != "" {
+		args = append(args, "-map", "0:a", "-map", "1:v", "-disposition:v:0", "attached_pic")
+	} else {
+		// keep the picture of video recordings
+		args = append(args, "-map", "0:a", "-map", "0:v?")
+	}
+	if chapters != "" {
+		args = append(args, "-map_chapters", meta)
+	}
+	args = append(args, codec...)
+	if t.Cover != "" {
+		args = append(args, "-codec:v", "copy") // the cover image as it is
+	}
+	for _, tag := range Tags(t.Metadata, t.Date) {
+		args = append(args, "-metadata", tag[0]+"="+tag[1])
+	}
+	return append(args, outputFile)
+}
+
+// ChapterFile renders chapters in FFmpeg's FFMETADATA format. Each chapter
+// ends where the next starts and the last at end, the length of the
+// recording, when that is known.
+func ChapterFile(chapters []metadata.Chapter, end time.Duration) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, c := range chapters {
+		stop := end
+		if i+1 < len(chapters) {
+			stop = chapters[i+1].Start
+		}
+		if stop < c.Start {
+			stop = c.Start
+		}
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", c.Start.Milliseconds(), stop.Milliseconds(), escapeMeta(c.Title))
+	}
+	return b.String()
+}
+
+// escapeMeta escapes the characters FFMETADATA treats specially.
+func escapeMeta(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n").Replace(s)
+}
+
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines[len(lines)-1]
+}
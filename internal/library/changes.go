@@ -0,0 +1,119 @@
+package library
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"musicloud/internal/metadata"
+)
+
+// Change is one row of a changes spreadsheet: a recording and the fields to
+// give it.
+type Change struct {
+	Line      int
+	Recording string            // ID, Drive ID, local path or Drive name
+	Set       map[string]string // field values; "-" clears a field
+}
+
+// Apply returns m with the change's fields set. Errors name the field; the
+// caller adds the line.
+func (c Change) Apply(m metadata.Metadata) (metadata.Metadata, error) {
+	for _, field := range metadata.Fields {
+		v, ok := c.Set[field]
+		if !ok {
+			continue
+		}
+		if v == "-" {
+			v = ""
+		}
+		if err := metadata.SetField(&m, field, v); err != nil {
+			return metadata.Metadata{}, fmt.Errorf("%s: %v", field, err)
+		}
+	}
+	return m, nil
+}
+
+// ReadTable reads a CSV or TSV spreadsheet with a header row. Header names
+// are lower-cased and their spaces replaced by underscores. Each row is
+// returned as a map from header name to its trimmed value, with its line
+// number.
+func ReadTable(r io.Reader, tabs bool) (header []string, rows []map[string]string, lines []int, err error) {
+	cr := csv.NewReader(r)
+	if tabs {
+		cr.Comma = '\t'
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	first := true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		if first {
+			for _, h := range record {
+				header = append(header, strings.ReplaceAll(strings.ToLower(strings.TrimSpace(h)), " ", "_"))
+			}
+			first = false
+			continue
+		}
+		row := map[string]string{}
+		empty := true
+		for i, v := range record {
+			if i < len(header) && header[i] != "" {
+				row[header[i]] = strings.TrimSpace(v)
+				empty = empty && strings.TrimSpace(v) == ""
+			}
+		}
+		if !empty {
+			rows = append(rows, row)
+			lines = append(lines, line)
+		}
+	}
+	return header, rows, lines, nil
+}
+
+// ReadChanges reads a changes spreadsheet. Its header names a "recording"
+// column and the fields to change, by their metadata file names. Empty cells
+// leave a field as it is, "-" clears it, and list fields are separated by
+// semicolons.
+func ReadChanges(r io.Reader, tabs bool) ([]Change, error) {
+	header, rows, lines, err := ReadTable(r, tabs)
+	if err != nil {
+		return nil, err
+	}
+	known := map[string]bool{"recording": true}
+	for _, f := range metadata.Fields {
+		known[f] = true
+	}
+	hasRecording := false
+	for _, h := range header {
+		if !known[h] {
+			return nil, fmt.Errorf("line 1: unknown column %q", h)
+		}
+		hasRecording = hasRecording || h == "recording"
+	}
+	if !hasRecording {
+		return nil, fmt.Errorf("line 1: header has no recording column")
+	}
+	var changes []Change
+	for i, row := range rows {
+		c := Change{Line: lines[i], Recording: row["recording"], Set: map[string]string{}}
+		if c.Recording == "" {
+			return nil, fmt.Errorf("line %d: no recording given", c.Line)
+		}
+		for k, v := range row {
+			if k != "recording" && v != "" {
+				c.Set[k] = v
+			}
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
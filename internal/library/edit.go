@@ -0,0 +1,226 @@
+package library
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/ffmpeg"
+	"musicloud/internal/metadata"
+	"musicloud/internal/organizer"
+)
+
+// Editor changes the metadata of uploaded recordings, in the library and in
+// Drive.
+type Editor struct {
+	Remote drive.Remote
+	// Root is the Drive ID of the upload folder that Recording.Folder is
+	// relative to.
+	Root string
+	// Place gives the folder and file name recording r should have once its
+	// metadata is m; an empty name keeps the current one. When nil,
+	// recordings keep the folder and name they have.
+	Place func(r Recording, m metadata.Metadata) (folder, name string)
+	// Retag rewrites the tags embedded in the file and uploads it to Drive
+	// as a new revision.
+	Retag bool
+	// Applied, when set, is called after a recording is updated, with its
+	// record before and after, such as to update what lists it elsewhere in
+	// Drive. It reports its own errors: the recording itself is updated.
+	Applied func(before, after Recording)
+}
+
+// Edit is what changing a recording's metadata does.
+type Edit struct {
+	Recording *Recording
+	Changes   []string // changed fields, as metadata.Diff lists them
+	Name      string   // new file name, if the file is renamed
+	Move      bool     // whether the file moves to Folder
+	Folder    string   // new folder, empty for the upload folder itself
+	Retag     bool
+}
+
+// Empty reports whether the edit changes nothing.
+func (e Edit) Empty() bool {
+	return len(e.Changes) == 0 && e.Name == "" && !e.Move && !e.Retag
+}
+
+func (e Edit) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", e.Recording.ID, e.Recording.Name)
+	if e.Empty() {
+		b.WriteString(": unchanged")
+	}
+	for _, c := range e.Changes {
+		b.WriteString("\n  " + c)
+	}
+	if e.Name != "" {
+		fmt.Fprintf(&b, "\n  rename to %q", e.Name)
+	}
+	if e.Move {
+		folder := e.Folder
+		if folder == "" {
+			folder = "(upload folder)"
+		}
+		fmt.Fprintf(&b, "\n  move to %q", folder)
+	}
+	if e.Retag {
+		b.WriteString("\n  rewrite embedded tags")
+	}
+	return b.String()
+}
+
+// Plan works out what giving r the metadata m would change, without
+// changing anything.
+func (e *Editor) Plan(r *Recording, m metadata.Metadata) (Edit, error) {
+	if err := m.Validate(); err != nil {
+		return Edit{}, err
+	}
+	edit := Edit{Recording: r, Changes: metadata.Diff(r.Metadata, m)}
+	if e.Place != nil {
+		folder, name := e.Place(*r, m)
+		if name != "" && name != r.Name {
+			edit.Name = name
+		}
+		if folder != r.Folder {
+			edit.Move, edit.Folder = true, folder
+		}
+	}
+	edit.Retag = e.Retag && len(edit.Changes) > 0
+	return edit, nil
+}
+
+// Apply gives r the metadata m: the Drive file's description and
+// appProperties are rewritten, the file is renamed and moved if its name or
+// folder depend on the metadata, and with Retag its embedded tags are
+// rewritten as a new revision. All Drive changes to the file are made in one
+// request, so it is either fully updated or left as it was, and folders
+// made for a move that fails are removed again. The library record is
+// updated but not saved.
+func (e *Editor) Apply(r *Recording, m metadata.Metadata) (Edit, error) {
+	edit, err := e.Plan(r, m)
+	if err != nil || edit.Empty() {
+		return edit, err
+	}
+
+	file := &gdrive.File{Name: edit.Name, Description: drive.Description(&m), AppProperties: drive.AppProperties(&m)}
+	var clear []string
+	for key := range drive.AppProperties(&r.Metadata) {
+		if _, ok := file.AppProperties[key]; !ok {
+			clear = append(clear, key)
+		}
+	}
+
+	var media io.Reader
+	if edit.Retag {
+		tmp, err := os.MkdirTemp("", "musicloud-")
+		if err != nil {
+			return Edit{}, err
+		}
+		defer os.RemoveAll(tmp)
+		tagged, err := e.retag(r, m, tmp)
+		if err != nil {
+			return Edit{}, err
+		}
+		f, err := os.Open(tagged)
+		if err != nil {
+			return Edit{}, err
+		}
+		defer f.Close()
+		media = f
+	}
+
+	update := func(folderID string) error {
+		var addParent, removeParent string
+		if edit.Move {
+			addParent, removeParent = folderID, r.FolderID
+		}
+		if edit.Name != "" {
+			// Names from a template can be taken in the folder; a suffix
+			// keeps them apart.
+			folder := folderID
+			if folder == "" {
+				folder = r.FolderID
+			}
+			if folder == "" {
+				folder = e.Root
+			}
+			name, err := drive.UniqueName(e.Remote, folder, edit.Name, r.DriveID)
+			if err != nil {
+				return err
+			}
+			if edit.Name = name; name == r.Name {
+				edit.Name = ""
+			}
+			file.Name = edit.Name
+			if r.Original == "" && edit.Name != "" {
+				file.AppProperties[drive.OriginalName] = r.Name
+			}
+		}
+		if _, err := e.Remote.Update(r.DriveID, file, addParent, removeParent, clear, media); err != nil {
+			return fmt.Errorf("unable to update %s in Drive: %v", r.Name, err)
+		}
+		return nil
+	}
+	// The folders a move needs are removed again if the update fails.
+	var folderID string
+	if edit.Move {
+		folderID, err = organizer.New(e.Remote, e.Root, nil).Within(edit.Folder, update)
+	} else {
+		err = update("")
+	}
+	if err != nil {
+		return Edit{}, err
+	}
+
+	before := *r
+	r.Metadata = m
+	if edit.Name != "" {
+		if r.Original == "" {
+			r.Original = r.Name
+		}
+		r.Name = edit.Name
+	}
+	if edit.Move {
+		r.Folder, r.FolderID = edit.Folder, folderID
+	}
+	if e.Applied != nil {
+		e.Applied(before, *r)
+	}
+	return edit, nil
+}
+
+// retag downloads the recording and writes the tags of m into a copy in dir.
+func (e *Editor) retag(r *Recording, m metadata.Metadata, dir string) (string, error) {
+	if ok, _ := ffmpeg.IsFFmpegInstalled(); !ok {
+		return "", fmt.Errorf("rewriting tags needs FFmpeg")
+	}
+	if !ffmpeg.CanCopy(r.Name) {
+		return "", fmt.Errorf("cannot rewrite the tags of %s without converting it", r.Name)
+	}
+	in, err := e.Remote.Download(r.DriveID)
+	if err != nil {
+		return "", fmt.Errorf("unable to download %s: %v", r.Name, err)
+	}
+	defer in.Close()
+	original := filepath.Join(dir, "original"+filepath.Ext(r.Name))
+	f, err := os.Create(original)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.ReadFrom(in); err != nil {
+		f.Close()
+		return "", err
+	}
+	f.Close()
+	tagged := filepath.Join(dir, r.Name)
+	if err := ffmpeg.WriteTags(original, tagged, ffmpeg.Tagging{Metadata: m, Date: m.RecordedAt}); err != nil {
+		return "", err
+	}
+	return tagged, nil
+}
@@ -0,0 +1,421 @@
+package library
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"musicloud/internal/catalog"
+	"musicloud/internal/metadata"
+	"musicloud/internal/parser"
+)
+
+// Row is a line of an import spreadsheet: how to find the recordings it
+// describes and the metadata to give them.
+type Row struct {
+	Line     int
+	File     string    // file name or glob pattern, e.g. "AUD-20190305-*.opus"
+	Hash     string    // SHA256 of the file, or a prefix of at least 8 digits
+	Date     time.Time // day of the class, matched with Metadata.GroupName
+	Metadata metadata.Metadata
+}
+
+// importAliases maps the column names admins tend to use to metadata fields.
+var importAliases = map[string]string{
+	"song": "songs", "title": "songs", "raga": "ragas", "ragam": "ragas",
+	"tala": "talas", "talam": "talas", "composer": "composers",
+	"performer": "performers", "instrument": "instruments", "tag": "tags",
+	"filename": "file", "file_name": "file", "pattern": "file",
+	"sha256": "hash", "checksum": "hash", "session": "session_type",
+}
+
+// ReadImport reads an import spreadsheet. Rows are matched to recordings by
+// their file, hash, or date and group columns; every other known column is
+// metadata, with list values separated by semicolons. Columns that mean
+// nothing to musicloud are returned in ignored. Dates are ISO dates, or
+// follow order when written with slashes or dots.
+func ReadImport(r io.Reader, tabs bool, order parser.DateOrder) (rows []Row, ignored []string, err error) {
+	header, table, lines, err := ReadTable(r, tabs)
+	if err != nil {
+		return nil, nil, err
+	}
+	known := map[string]bool{"file": true, "hash": true, "date": true}
+	for _, f := range metadata.Fields {
+		known[f] = true
+	}
+	columns := map[string]string{}
+	for _, h := range header {
+		name := h
+		if alias, ok := importAliases[h]; ok {
+			name = alias
+		}
+		if !known[name] {
+			ignored = append(ignored, h)
+			continue
+		}
+		columns[h] = name
+	}
+	if !hasColumn(columns, "file") && !hasColumn(columns, "hash") && !(hasColumn(columns, "date") && hasColumn(columns, "group")) {
+		return nil, nil, fmt.Errorf("line 1: header needs a file, hash, or date and group column to match recordings")
+	}
+
+	for i, values := range table {
+		row := Row{Line: lines[i]}
+		for _, h := range header {
+			name, ok := columns[h]
+			v := values[h]
+			if !ok || v == "" {
+				continue
+			}
+			switch name {
+			case "file":
+				row.File = v
+			case "hash":
+				row.Hash = strings.ToLower(v)
+				if !shortHash.MatchString(row.Hash) {
+					err = fmt.Errorf("hash %q needs at least %d hex digits", v, minHashDigits)
+				}
+			case "date":
+				row.Date, err = parseImportDate(v, order)
+			default:
+				err = metadata.SetField(&row.Metadata, name, v)
+			}
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %v", row.Line, err)
+			}
+		}
+		if err := row.Metadata.Validate(); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %v", row.Line, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows, ignored, nil
+}
+
+func hasColumn(columns map[string]string, name string) bool {
+	for _, n := range columns {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// minHashDigits is how much of a SHA256 a hash column must give.
+const minHashDigits = 8
+
+var shortHash = regexp.MustCompile(fmt.Sprintf(`^[0-9a-f]{%d,64}$`, minHashDigits))
+
+var slashDate = regexp.MustCompile(`^(\d{1,4})[./-](\d{1,2})[./-](\d{1,4})$`)
+
+func parseImportDate(s string, order parser.DateOrder) (time.Time, error) {
+	if t, err := metadata.ParseDate(s); err == nil {
+		return t, nil
+	}
+	m := slashDate.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	if order == "" {
+		return time.Time{}, fmt.Errorf("date %q could be day/month or month/day; give the date format", s)
+	}
+	var y, mo, d string
+	switch order {
+	case parser.DayMonthYear:
+		d, mo, y = m[1], m[2], m[3]
+	case parser.MonthDayYear:
+		mo, d, y = m[1], m[2], m[3]
+	default:
+		y, mo, d = m[1], m[2], m[3]
+	}
+	year, _ := strconv.Atoi(y)
+	if len(y) == 2 {
+		year += 2000
+	}
+	month, _ := strconv.Atoi(mo)
+	day, _ := strconv.Atoi(d)
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+	if t.Day() != day || int(t.Month()) != month {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
+
+// Candidate is a recording a row can describe: one already uploaded, from
+// the library, or a local file that has not been uploaded.
+type Candidate struct {
+	Path      string     // local file; empty for uploads whose file is gone
+	Recording *Recording // library record, nil for local files
+	Name      string
+	Date      time.Time
+	Group     string
+	hash      string
+}
+
+// Hash returns the SHA256 of the candidate, computing it for local files.
+func (c *Candidate) Hash() string {
+	if c.hash == "" && c.Path != "" {
+		c.hash, _ = HashFile(c.Path)
+	}
+	return c.hash
+}
+
+// hasName reports whether pattern matches the candidate's name, or the
+// name it had before the name template renamed it.
+func (c *Candidate) hasName(pattern string) bool {
+	names := []string{c.Name}
+	if c.Recording != nil {
+		names = append(names, c.Recording.Original)
+	}
+	if c.Path != "" {
+		names = append(names, filepath.Base(c.Path))
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, name); ok || strings.EqualFold(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Candidate) String() string {
+	if c.Recording != nil {
+		return fmt.Sprintf("%s %s (uploaded)", c.Recording.ID, c.Name)
+	}
+	return c.Path
+}
+
+// Candidates lists the recordings rows can be matched to: every recording
+// in the library, and the media files in dir (and its subfolders) that are
+// not in the library. dir may be empty.
+func Candidates(lib *Library, dir string, isMedia func(string) bool) ([]*Candidate, error) {
+	var out []*Candidate
+	uploaded := map[string]bool{}
+	for _, r := range lib.Recordings {
+		out = append(out, &Candidate{Path: r.Path, Recording: r, Name: r.Name, Date: r.Metadata.RecordedAt, Group: r.Metadata.GroupName, hash: r.SHA256})
+		uploaded[r.Path] = true
+	}
+	if dir == "" {
+		return out, nil
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !isMedia(path) {
+			return err
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if uploaded[path] {
+			return nil
+		}
+		// A broken sidecar is reported when the file is uploaded; here it
+		// only means less to match on.
+		meta := localMetadata(path, dir)
+		date := meta.RecordedAt
+		if date.IsZero() {
+			date = dateInName(filepath.Base(path))
+		}
+		out = append(out, &Candidate{Path: path, Name: filepath.Base(path), Date: date, Group: meta.GroupName})
+		return nil
+	})
+	return out, err
+}
+
+// localMetadata is what is known about a local file before upload.
+func localMetadata(path, root string) metadata.Metadata {
+	folder, _ := metadata.FolderDefaults(path, root)
+	embedded, _ := metadata.Embedded(path)
+	sidecar, _, _ := metadata.Sidecar(path)
+	return metadata.Merge(embedded, folder, sidecar)
+}
+
+// nameDate finds a date in a file name: "AUD-20240305-WA0001.opus",
+// "2024-03-05 18.30.12 Class", "class_2024_03_05.m4a".
+var nameDate = regexp.MustCompile(`(?:^|\D)(20\d{2}|19\d{2})[-_.]?(\d{2})[-_.]?(\d{2})(?:\D|$)`)
+
+func dateInName(name string) time.Time {
+	m := nameDate.FindStringSubmatch(name)
+	if m == nil {
+		return time.Time{}
+	}
+	year, _ := strconv.Atoi(m[1])
+	month, _ := strconv.Atoi(m[2])
+	day, _ := strconv.Atoi(m[3])
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local)
+	if t.Day() != day || int(t.Month()) != month {
+		return time.Time{}
+	}
+	return t
+}
+
+// Match is a row and the recordings it describes.
+type Match struct {
+	Row        Row
+	By         string // "hash", "file" or "date and group"
+	Recordings []*Candidate
+}
+
+// Conflict is a recording that several rows describe.
+type Conflict struct {
+	Recording *Candidate
+	Lines     []int
+}
+
+// Reconciliation is the outcome of matching a spreadsheet to recordings.
+type Reconciliation struct {
+	Matches             []Match // rows that describe at least one recording no other row describes
+	UnmatchedRows       []Row
+	Conflicts           []Conflict // recordings left alone because rows disagree about them
+	UnmatchedRecordings []*Candidate
+}
+
+// Reconcile matches rows to recordings. A row is matched by the most precise
+// key it has: its hash, then its file name pattern, then its date and group.
+// A row may describe several recordings, such as the parts of one class. A
+// recording described by more than one row is a conflict and is not changed.
+func Reconcile(rows []Row, candidates []*Candidate) Reconciliation {
+	var rec Reconciliation
+	matched := map[*Candidate][]int{}
+	var matches []Match
+	for _, row := range rows {
+		m := Match{Row: row}
+		switch {
+		case row.Hash != "":
+			m.By = "hash"
+			for _, c := range candidates {
+				if len(row.Hash) >= minHashDigits && strings.HasPrefix(c.Hash(), row.Hash) {
+					m.Recordings = append(m.Recordings, c)
+				}
+			}
+		case row.File != "":
+			m.By = "file"
+			for _, c := range candidates {
+				if c.hasName(row.File) {
+					m.Recordings = append(m.Recordings, c)
+				}
+			}
+		case !row.Date.IsZero() && row.Metadata.GroupName != "":
+			m.By = "date and group"
+			for _, c := range candidates {
+				if sameDay(c.Date, row.Date) && catalog.Key(c.Group) == catalog.Key(row.Metadata.GroupName) {
+					m.Recordings = append(m.Recordings, c)
+				}
+			}
+		}
+		if len(m.Recordings) == 0 {
+			rec.UnmatchedRows = append(rec.UnmatchedRows, row)
+			continue
+		}
+		for _, c := range m.Recordings {
+			matched[c] = append(matched[c], row.Line)
+		}
+		matches = append(matches, m)
+	}
+
+	for _, c := range candidates {
+		switch lines := matched[c]; {
+		case len(lines) == 0:
+			rec.UnmatchedRecordings = append(rec.UnmatchedRecordings, c)
+		case len(lines) > 1:
+			rec.Conflicts = append(rec.Conflicts, Conflict{Recording: c, Lines: lines})
+		}
+	}
+	for _, m := range matches {
+		var kept []*Candidate
+		for _, c := range m.Recordings {
+			if len(matched[c]) == 1 {
+				kept = append(kept, c)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		m.Recordings = kept
+		rec.Matches = append(rec.Matches, m)
+	}
+	return rec
+}
+
+func sameDay(a, b time.Time) bool {
+	if a.IsZero() || b.IsZero() {
+		return false
+	}
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Metadata is the metadata a matched recording gets: its current metadata
+// with the row's values, and the row's date when the recording has none.
+func (m Match) Metadata(current metadata.Metadata) metadata.Metadata {
+	next := metadata.Merge(current, m.Row.Metadata)
+	if next.RecordedAt.IsZero() && !m.Row.Date.IsZero() {
+		next.RecordedAt, next.DateSource = m.Row.Date, metadata.DateFromMetadata
+	}
+	return next
+}
+
+// WriteReport writes the reconciliation as Markdown: the matches, then
+// everything that needs a person to look at it.
+func (r Reconciliation) WriteReport(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Import Reconciliation\n\n")
+	fmt.Fprintf(&b, "%d rows matched, %d rows unmatched, %d recordings in conflict, %d recordings unmatched.\n", len(r.Matches), len(r.UnmatchedRows), len(r.Conflicts), len(r.UnmatchedRecordings))
+
+	if len(r.Matches) > 0 {
+		b.WriteString("\n## Matched\n\n| Line | Matched by | Recordings |\n|---|---|---|\n")
+		for _, m := range r.Matches {
+			var names []string
+			for _, c := range m.Recordings {
+				names = append(names, c.String())
+			}
+			fmt.Fprintf(&b, "| %d | %s | %s |\n", m.Row.Line, m.By, escapeCell(strings.Join(names, "<br>")))
+		}
+	}
+	if len(r.UnmatchedRows) > 0 {
+		b.WriteString("\n## Rows Without a Recording\n\n| Line | File | Hash | Date | Group |\n|---|---|---|---|---|\n")
+		for _, row := range r.UnmatchedRows {
+			date := ""
+			if !row.Date.IsZero() {
+				date = row.Date.Format("2006-01-02")
+			}
+			fmt.Fprintf(&b, "| %d | %s | %s | %s | %s |\n", row.Line, escapeCell(row.File), row.Hash, date, escapeCell(row.Metadata.GroupName))
+		}
+	}
+	if len(r.Conflicts) > 0 {
+		b.WriteString("\n## Recordings Described by Several Rows\n\nThese were left unchanged.\n\n| Recording | Lines |\n|---|---|\n")
+		for _, c := range r.Conflicts {
+			var lines []string
+			for _, l := range c.Lines {
+				lines = append(lines, strconv.Itoa(l))
+			}
+			fmt.Fprintf(&b, "| %s | %s |\n", escapeCell(c.Recording.String()), strings.Join(lines, ", "))
+		}
+	}
+	if len(r.UnmatchedRecordings) > 0 {
+		b.WriteString("\n## Recordings Without a Row\n\n")
+		var names []string
+		for _, c := range r.UnmatchedRecordings {
+			names = append(names, c.String())
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(&b, "- %s\n", n)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func escapeCell(s string) string {
+	return strings.ReplaceAll(s, "|", "\\|")
+}
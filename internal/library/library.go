@@ -0,0 +1,152 @@
+// Package library keeps the local record of every recording musicloud has
+// uploaded: where it came from, where it is in Drive and its metadata.
+package library
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"musicloud/internal/metadata"
+)
+
+// Recording is an uploaded recording.
+type Recording struct {
+	ID         string            `json:"id"`     // first 12 hex digits of SHA256
+	SHA256     string            `json:"sha256"` // of the local file it was uploaded from
+	Path       string            `json:"path"`   // local file it was uploaded from
+	DriveID    string            `json:"drive_id"`
+	Name       string            `json:"name"`                    // file name in Drive
+	Original   string            `json:"original_name,omitempty"` // file name before it was renamed by the name template
+	Folder     string            `json:"folder,omitempty"`        // folder path below the upload folder
+	FolderID   string            `json:"folder_id"`
+	UploadedAt time.Time         `json:"uploaded_at"`
+	Metadata   metadata.Metadata `json:"metadata"`
+}
+
+// Library is the set of uploaded recordings, saved as JSON.
+type Library struct {
+	path       string
+	Version    int          `json:"version"` // metadata schema version of the recordings
+	Recordings []*Recording `json:"recordings"`
+}
+
+// Load reads the library from path. A missing file yields an empty library
+// that will be created on Save. Metadata stored under an earlier schema
+// version is upgraded.
+func Load(path string) (*Library, error) {
+	l := &Library{path: path}
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read library: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, l); err != nil {
+			return nil, fmt.Errorf("unable to parse library %s: %v", path, err)
+		}
+	}
+	for _, r := range l.Recordings {
+		m, err := metadata.Migrate(r.Metadata, l.Version)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read library %s: %v", path, err)
+		}
+		r.Metadata = m
+	}
+	l.Version = metadata.SchemaVersion
+	return l, nil
+}
+
+// Save writes the library back to the file it was loaded from.
+func (l *Library) Save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it, so an interrupted save keeps the old one.
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// Add records a recording, replacing an earlier record of the same file.
+func (l *Library) Add(r Recording) *Recording {
+	for i, existing := range l.Recordings {
+		if existing.ID == r.ID || r.DriveID != "" && existing.DriveID == r.DriveID {
+			l.Recordings[i] = &r
+			return &r
+		}
+	}
+	l.Recordings = append(l.Recordings, &r)
+	return &r
+}
+
+// Find looks a recording up by ID or a unique prefix of it, by Drive file
+// ID, by the local path it was uploaded from, or by its name in Drive or the
+// name it had before it was renamed.
+func (l *Library) Find(ref string) (*Recording, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, fmt.Errorf("no recording given")
+	}
+	abs, _ := filepath.Abs(ref)
+	var matches []*Recording
+	for _, r := range l.Recordings {
+		if r.ID == ref || r.DriveID == ref || r.Path == ref || abs != "" && r.Path == abs {
+			return r, nil
+		}
+		if strings.HasPrefix(r.ID, ref) || r.Name == ref || r.Original == ref {
+			matches = append(matches, r)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no recording matches %q", ref)
+	case 1:
+		return matches[0], nil
+	}
+	var ids []string
+	for _, r := range matches {
+		ids = append(ids, r.ID+" "+r.Name)
+	}
+	sort.Strings(ids)
+	return nil, fmt.Errorf("%q matches several recordings: %s", ref, strings.Join(ids, ", "))
+}
+
+// HashFile returns the SHA256 of a file's content in hex.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// NewRecording describes the local file at path for the library, with its
+// hash and ID filled in.
+func NewRecording(path string, meta metadata.Metadata) (Recording, error) {
+	sum, err := HashFile(path)
+	if err != nil {
+		return Recording{}, fmt.Errorf("unable to hash %s: %v", path, err)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return Recording{ID: sum[:12], SHA256: sum, Path: path, Name: filepath.Base(path), Metadata: meta, UploadedAt: time.Now()}, nil
+}
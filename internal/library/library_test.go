@@ -0,0 +1,284 @@
+package library
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/metadata"
+	"musicloud/internal/parser"
+)
+
+func TestLibrary_LoadSaveFind(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "library.json")
+	// A library written before metadata had a schema version.
+	os.WriteFile(path, []byte(`{"recordings": [{"id": "abc123def456", "drive_id": "d1", "path": "/rec/class.m4a", "name": "class.m4a",
+		"metadata": {"group": "Veena Class", "session_type": "Online", "songs": ["Vatapi", ""]}}]}`), 0644)
+	lib, err := Load(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := lib.Find("abc1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Metadata.SessionType != "virtual" || !reflect.DeepEqual(r.Metadata.SongsTaught, []string{"Vatapi"}) {
+		t.Errorf("expected migrated metadata, got %+v", r.Metadata)
+	}
+	for _, ref := range []string{"abc123def456", "d1", "/rec/class.m4a", "class.m4a"} {
+		if found, err := lib.Find(ref); err != nil || found != r {
+			t.Errorf("Find(%q) = %v, %v", ref, found, err)
+		}
+	}
+
+	media := filepath.Join(dir, "lesson.m4a")
+	os.WriteFile(media, []byte("audio"), 0644)
+	rec, err := NewRecording(media, metadata.Metadata{GroupName: "Veena Class"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lib.Add(rec)
+	if err := lib.Save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := Load(path)
+	if err != nil || len(again.Recordings) != 2 || again.Version != metadata.SchemaVersion {
+		t.Fatalf("unexpected library after save: %+v, %v", again, err)
+	}
+	if _, err := again.Find("nothing"); err == nil {
+		t.Error("expected an error for an unknown recording")
+	}
+}
+
+func TestEditor_Apply(t *testing.T) {
+	remote := drive.NewMockRemote()
+	old := metadata.Metadata{GroupName: "Veena Class", Ragas: []string{"கல்யாணி"}, Talas: []string{"Adi"}}
+	f, _ := remote.Create(&gdrive.File{Name: "class.m4a", Parents: []string{"root"}, AppProperties: drive.AppProperties(&old)}, strings.NewReader("audio"))
+	r := &Recording{ID: "abc", DriveID: f.Id, Name: "class.m4a", FolderID: "root", Metadata: old}
+
+	editor := &Editor{Remote: remote, Root: "root", Place: func(r Recording, m metadata.Metadata) (string, string) {
+		return "By Raga/" + m.Ragas[0], m.Ragas[0] + ".m4a"
+	}}
+	m := old
+	m.Ragas = []string{"Kalyani"}
+	m.Talas = nil
+	edit, err := editor.Apply(r, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(edit.Changes) != 2 || edit.Name != "Kalyani.m4a" || !edit.Move || edit.Folder != "By Raga/Kalyani" {
+		t.Errorf("unexpected edit %s", edit)
+	}
+
+	got, _ := remote.Get(f.Id)
+	if got.Name != "Kalyani.m4a" || got.AppProperties["ragas"] != "Kalyani" || !strings.Contains(got.Description, "Ragas: Kalyani") {
+		t.Errorf("unexpected Drive file %+v", got)
+	}
+	for _, key := range []string{"talas", "ragas_latin"} {
+		if _, ok := got.AppProperties[key]; ok {
+			t.Errorf("expected %s to be removed, got %v", key, got.AppProperties)
+		}
+	}
+	folder, _ := remote.Find("root", "By Raga", drive.FolderMimeType)
+	if len(folder) != 1 {
+		t.Fatalf("expected the folder to be created, got %v", folder)
+	}
+	kalyani, _ := remote.Find(folder[0].Id, "Kalyani", drive.FolderMimeType)
+	if len(kalyani) != 1 || !reflect.DeepEqual(got.Parents, []string{kalyani[0].Id}) {
+		t.Errorf("expected the file to move to By Raga/Kalyani, parents %v", got.Parents)
+	}
+	if r.Name != "Kalyani.m4a" || r.Folder != "By Raga/Kalyani" || r.FolderID != kalyani[0].Id || r.Metadata.Ragas[0] != "Kalyani" {
+		t.Errorf("unexpected library record %+v", r)
+	}
+
+	// Applying the same metadata again changes nothing.
+	updates := remote.Calls["Update"]
+	if edit, err := editor.Apply(r, m); err != nil || !edit.Empty() || remote.Calls["Update"] != updates {
+		t.Errorf("expected no change, got %s, %v", edit, err)
+	}
+	bad := m
+	bad.Lesson = -1
+	if _, err := editor.Apply(r, bad); err == nil {
+		t.Error("expected invalid metadata to be rejected")
+	}
+
+	// A move that fails leaves no new folders behind.
+	editor.Remote = &failingRemote{MockRemote: remote, fileID: f.Id}
+	m.Ragas = []string{"Todi"}
+	if _, err := editor.Apply(r, m); err == nil {
+		t.Fatal("expected the update to fail")
+	}
+	if todi, _ := remote.Find(folder[0].Id, "Todi", drive.FolderMimeType); len(todi) != 0 {
+		t.Errorf("expected the folder made for the move removed, got %v", todi)
+	}
+	if r.Folder != "By Raga/Kalyani" || r.Metadata.Ragas[0] != "Kalyani" {
+		t.Errorf("expected the library record unchanged, got %+v", r)
+	}
+}
+
+// failingRemote fails every update of the file fileID.
+type failingRemote struct {
+	*drive.MockRemote
+	fileID string
+}
+
+func (f *failingRemote) Update(id string, file *gdrive.File, add, remove string, clear []string, media io.Reader) (*gdrive.File, error) {
+	if id == f.fileID {
+		return nil, errors.New("backend error")
+	}
+	return f.MockRemote.Update(id, file, add, remove, clear, media)
+}
+
+func TestReadChanges(t *testing.T) {
+	in := "Recording,Ragas,Session Type,Talas,Lesson\nabc,Kalyani; Todi,online,-,\n\n"
+	changes, err := ReadChanges(strings.NewReader(in), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Line != 2 || changes[0].Recording != "abc" {
+		t.Fatalf("unexpected changes %+v", changes)
+	}
+	m, err := changes[0].Apply(metadata.Metadata{Talas: []string{"Adi"}, Lesson: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := metadata.Metadata{Ragas: []string{"Kalyani", "Todi"}, SessionType: "virtual", Lesson: 3}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+
+	bad, _ := ReadChanges(strings.NewReader("recording,lesson\nabc,twelve\n"), false)
+	if _, err := bad[0].Apply(metadata.Metadata{}); err == nil || err.Error() != `lesson: invalid lesson number "twelve"` {
+		t.Errorf("expected a lesson error without the line, got %v", err)
+	}
+
+	if _, err := ReadChanges(strings.NewReader("recording,tempo\nabc,fast\n"), false); err == nil || !strings.Contains(err.Error(), `unknown column "tempo"`) {
+		t.Errorf("expected unknown column error, got %v", err)
+	}
+	if _, err := ReadChanges(strings.NewReader("ragas\nKalyani\n"), false); err == nil {
+		t.Error("expected an error without a recording column")
+	}
+}
+
+func TestImport_Reconcile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"AUD-20240305-WA0001.opus", "AUD-20240305-WA0002.opus", "class 2024-03-12.m4a", "other.m4a"} {
+		os.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "musicloud.yaml"), []byte("group: Veena Class\n"), 0644)
+	lib := &Library{}
+	lib.Add(Recording{ID: "aaaaaaaaaaaa", SHA256: "aaaaaaaaaaaa1111", DriveID: "d1", Name: "uploaded.m4a",
+		Metadata: metadata.Metadata{GroupName: "Veena Class", RecordedAt: time.Date(2024, 2, 1, 18, 0, 0, 0, time.Local)}})
+
+	sheet := "File,Hash,Date,Group,Raga,Composer,Teacher Notes\n" +
+		",aaaaaaaa,,,Todi,,x\n" +
+		"class*.m4a,,,,Kalyani,Tyagaraja,\n" +
+		",,05/03/2024,veena class,Mohanam,,\n" +
+		",,06/03/2024,Veena Class,Hamsadhwani,,\n" +
+		"AUD-20240305-WA0002.opus,,,,Sahana,,\n"
+	if _, _, err := ReadImport(strings.NewReader(sheet), false, ""); err == nil || !strings.Contains(err.Error(), "date format") {
+		t.Errorf("expected an ambiguous date error, got %v", err)
+	}
+	if _, _, err := ReadImport(strings.NewReader("hash,raga\naaaa1,Todi\n"), false, ""); err == nil || !strings.Contains(err.Error(), "line 2: hash") {
+		t.Errorf("expected a short hash to be rejected, got %v", err)
+	}
+	// Of several bad cells, the leftmost is reported.
+	for i := 0; i < 5; i++ {
+		if _, _, err := ReadImport(strings.NewReader("date,hash,file\nsoon,aaaa1,x\n"), false, ""); err == nil || !strings.Contains(err.Error(), "invalid date") {
+			t.Fatalf("expected the date error, got %v", err)
+		}
+	}
+	rows, ignored, err := ReadImport(strings.NewReader(sheet), false, parser.DayMonthYear)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(ignored, []string{"teacher_notes"}) || len(rows) != 5 {
+		t.Fatalf("unexpected rows %+v, ignored %v", rows, ignored)
+	}
+	if rows[2].Date.Month() != time.March || rows[2].Date.Day() != 5 || rows[1].Metadata.Composers[0] != "Tyagaraja" {
+		t.Errorf("unexpected row %+v", rows[2])
+	}
+
+	candidates, err := Candidates(lib, dir, func(p string) bool { return filepath.Ext(p) != ".yaml" })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec := Reconcile(rows, candidates)
+	matched := map[int][]string{}
+	for _, m := range rec.Matches {
+		for _, c := range m.Recordings {
+			matched[m.Row.Line] = append(matched[m.Row.Line], c.Name)
+		}
+	}
+	want := map[int][]string{
+		2: {"uploaded.m4a"},
+		3: {"class 2024-03-12.m4a"},
+		// Both parts of the class on the 5th, but part two is also named on
+		// line 6, so it is a conflict and left alone.
+		4: {"AUD-20240305-WA0001.opus"},
+	}
+	if !reflect.DeepEqual(matched, want) {
+		t.Errorf("matched %v, want %v", matched, want)
+	}
+	if len(rec.UnmatchedRows) != 1 || rec.UnmatchedRows[0].Line != 5 {
+		t.Errorf("unexpected unmatched rows %+v", rec.UnmatchedRows)
+	}
+	if len(rec.Conflicts) != 1 || rec.Conflicts[0].Recording.Name != "AUD-20240305-WA0002.opus" || !reflect.DeepEqual(rec.Conflicts[0].Lines, []int{4, 6}) {
+		t.Errorf("unexpected conflicts %+v", rec.Conflicts)
+	}
+	if len(rec.UnmatchedRecordings) != 1 || rec.UnmatchedRecordings[0].Name != "other.m4a" {
+		t.Errorf("unexpected unmatched recordings %v", rec.UnmatchedRecordings)
+	}
+
+	var report strings.Builder
+	if err := rec.WriteReport(&report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range []string{"## Matched", "| 4 | date and group |", "## Rows Without a Recording", "| 5 |  |  | 2024-03-06 | Veena Class |", "| 4, 6 |", "other.m4a"} {
+		if !strings.Contains(report.String(), s) {
+			t.Errorf("report lacks %q:\n%s", s, report.String())
+		}
+	}
+
+	m := rec.Matches[2]
+	next := m.Metadata(metadata.Metadata{GroupName: "Veena Class"})
+	if next.Ragas[0] != "Mohanam" || !sameDay(next.RecordedAt, rows[2].Date) {
+		t.Errorf("unexpected metadata %+v", next)
+	}
+	path := m.Recordings[0].Path
+	if err := metadata.WriteSidecar(path, next); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	saved, ok, err := metadata.Sidecar(path)
+	if err != nil || !ok || saved.Ragas[0] != "Mohanam" || !sameDay(saved.RecordedAt, rows[2].Date) {
+		t.Errorf("unexpected sidecar %+v, %v, %v", saved, ok, err)
+	}
+}
+
+func TestImport_RenamedUpload(t *testing.T) {
+	lib := &Library{}
+	lib.Add(Recording{ID: "aaaaaaaaaaaa", DriveID: "d1", Path: "/phone/AUD-20190305-WA0001.opus",
+		Name: "2019-03-05_Todi.opus", Original: "AUD-20190305-WA0001.opus"})
+	if r, err := lib.Find("AUD-20190305-WA0001.opus"); err != nil || r.ID != "aaaaaaaaaaaa" {
+		t.Errorf("expected to find the recording by its original name, got %v, %v", r, err)
+	}
+
+	rows, _, err := ReadImport(strings.NewReader("file,raga\nAUD-20190305-*.opus,Todi\n"), false, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	candidates, _ := Candidates(lib, "", nil)
+	rec := Reconcile(rows, candidates)
+	if len(rec.Matches) != 1 || rec.Matches[0].Recordings[0].Recording.ID != "aaaaaaaaaaaa" {
+		t.Errorf("expected the row to match the renamed upload, got %+v", rec)
+	}
+}
@@ -0,0 +1,260 @@
+package metadata
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"musicloud/internal/catalog"
+)
+
+// Composition is a song with the raga, tala and composer it is sung in.
+type Composition struct {
+	Title    string   `json:"title"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Raga     string   `json:"raga"`
+	Tala     string   `json:"tala"`
+	Composer string   `json:"composer"`
+}
+
+// Compositions is a catalog of compositions looked up by title.
+type Compositions struct {
+	byKey map[string]Composition
+}
+
+// builtinCompositions are well-known compositions from the beginner and
+// concert repertoire.
+var builtinCompositions = []Composition{
+	{Title: "Sri Gananatha", Raga: "Malahari", Tala: "Rupakam", Composer: "Purandara Dasa"},
+	{Title: "Lambodara Lakumikara", Aliases: []string{"Lambodara"}, Raga: "Malahari", Tala: "Rupakam", Composer: "Purandara Dasa"},
+	{Title: "Kunda Gowra", Raga: "Malahari", Tala: "Rupakam", Composer: "Purandara Dasa"},
+	{Title: "Padumanabha", Raga: "Malahari", Tala: "Tisra Triputa", Composer: "Purandara Dasa"},
+	{Title: "Ninnukori", Raga: "Mohanam", Tala: "Adi", Composer: "Poochi Srinivasa Iyengar"},
+	{Title: "Sami Ninne Kori", Raga: "Sankarabharanam", Tala: "Adi", Composer: "Veena Kuppayyar"},
+	{Title: "Evvari Bodhana", Raga: "Abhogi", Tala: "Adi", Composer: "Patnam Subramania Iyer"},
+	{Title: "Vatapi Ganapatim", Aliases: []string{"Vatapi"}, Raga: "Hamsadhwani", Tala: "Adi", Composer: "Muthuswami Dikshitar"},
+	{Title: "Mahaganapatim", Raga: "Nata", Tala: "Adi", Composer: "Muthuswami Dikshitar"},
+	{Title: "Bhajare Re Chitta", Raga: "Kalyani", Tala: "Misra Chapu", Composer: "Muthuswami Dikshitar"},
+	{Title: "Sri Subrahmanyaya Namaste", Raga: "Kambhoji", Tala: "Rupakam", Composer: "Muthuswami Dikshitar"},
+	{Title: "Akhilandeswari", Raga: "Dwijavanti", Tala: "Adi", Composer: "Muthuswami Dikshitar"},
+	{Title: "Meenakshi Me Mudam", Raga: "Gamakakriya", Tala: "Adi", Composer: "Muthuswami Dikshitar"},
+	{Title: "Endaro Mahanubhavulu", Aliases: []string{"Endaro"}, Raga: "Sri", Tala: "Adi", Composer: "Tyagaraja"},
+	{Title: "Jagadananda Karaka", Raga: "Nata", Tala: "Adi", Composer: "Tyagaraja"},
+	{Title: "Dudukugala", Raga: "Gowla", Tala: "Adi", Composer: "Tyagaraja"},
+	{Title: "Sadinchene", Raga: "Arabhi", Tala: "Adi", Composer: "Tyagaraja"},
+	{Title: "Kanakana Ruchira", Raga: "Varali", Tala: "Adi", Composer: "Tyagaraja"},
+	{Title: "Samajavaragamana", Raga: "Hindolam", Tala: "Adi", Composer: "Tyagaraja"},
+	{Title: "Nidhi Chala Sukhama", Raga: "Kalyani", Tala: "Misra Chapu", Composer: "Tyagaraja"},
+	{Title: "Etavunara", Raga: "Kalyani", Tala: "Adi", Composer: "Tyagaraja"},
+	{Title: "Rama Nannu Brovara", Raga: "Harikambhoji", Tala: "Rupakam", Composer: "Tyagaraja"},
+	{Title: "Kamakshi", Raga: "Bhairavi", Tala: "Misra Chapu", Composer: "Syama Sastri"},
+	{Title: "Marivere Gati", Raga: "Anandabhairavi", Tala: "Misra Chapu", Composer: "Syama Sastri"},
+	{Title: "Saroja Dala Netri", Raga: "Sankarabharanam", Tala: "Adi", Composer: "Syama Sastri"},
+	{Title: "Raghuvamsa Sudha", Raga: "Kadanakuthuhalam", Tala: "Adi", Composer: "Patnam Subramania Iyer"},
+	{Title: "Brochevarevarura", Raga: "Khamas", Tala: "Adi", Composer: "Mysore Vasudevachar"},
+	{Title: "Manasa Sancharare", Raga: "Sama", Tala: "Adi", Composer: "Sadasiva Brahmendra"},
+	{Title: "Alaipayuthe", Raga: "Kanada", Tala: "Adi", Composer: "Oothukkadu Venkata Kavi"},
+	{Title: "Bho Shambho", Raga: "Revati", Tala: "Adi", Composer: "Dayananda Saraswati"},
+}
+
+// NewCompositions returns a catalog holding the built-in compositions.
+func NewCompositions() *Compositions {
+	c := &Compositions{byKey: map[string]Composition{}}
+	for _, comp := range builtinCompositions {
+		c.Add(comp)
+	}
+	return c
+}
+
+// Add puts a composition in the catalog, replacing any with the same title
+// or alias.
+func (c *Compositions) Add(comp Composition) {
+	for _, t := range append([]string{comp.Title}, comp.Aliases...) {
+		if k := catalog.Key(t); k != "" {
+			c.byKey[k] = comp
+		}
+	}
+}
+
+// Len is the number of titles and aliases in the catalog.
+func (c *Compositions) Len() int {
+	return len(c.byKey)
+}
+
+// Lookup finds a composition by title or alias, ignoring spacing, case and
+// common spelling differences.
+func (c *Compositions) Lookup(title string) (Composition, bool) {
+	if c == nil {
+		return Composition{}, false
+	}
+	comp, ok := c.byKey[catalog.Key(title)]
+	return comp, ok
+}
+
+// LoadFile adds the compositions in a CSV or JSON file, chosen by extension.
+// Entries in the file replace built-in ones with the same title.
+//
+// A CSV (or tab-separated .tsv) file has a header naming its columns:
+// title, raga, tala, composer and optionally aliases, separated by
+// semicolons. A JSON file holds a list of objects with the same fields.
+func (c *Compositions) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var comps []Composition
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.NewDecoder(f).Decode(&comps); err != nil {
+			return fmt.Errorf("unable to parse compositions %s: %v", path, err)
+		}
+	case ".csv", ".tsv":
+		comps, err = readCompositionsCSV(f, strings.EqualFold(filepath.Ext(path), ".tsv"))
+		if err != nil {
+			return fmt.Errorf("unable to parse compositions %s: %v", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported compositions file %s (use .csv, .tsv or .json)", path)
+	}
+	for i, comp := range comps {
+		if strings.TrimSpace(comp.Title) == "" {
+			return fmt.Errorf("%s: entry %d has no title", path, i+1)
+		}
+		c.Add(comp)
+	}
+	return nil
+}
+
+func readCompositionsCSV(r io.Reader, tabs bool) ([]Composition, error) {
+	cr := csv.NewReader(r)
+	if tabs {
+		cr.Comma = '\t'
+	}
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	col := map[string]int{}
+	for i, h := range rows[0] {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	if _, ok := col["title"]; !ok {
+		return nil, fmt.Errorf("line 1: header has no title column")
+	}
+	for h := range col {
+		switch h {
+		case "title", "raga", "tala", "composer", "aliases":
+		default:
+			return nil, fmt.Errorf("line 1: unknown column %q", h)
+		}
+	}
+	get := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var out []Composition
+	for _, row := range rows[1:] {
+		comp := Composition{Title: get(row, "title"), Raga: get(row, "raga"), Tala: get(row, "tala"), Composer: get(row, "composer")}
+		for _, a := range strings.Split(get(row, "aliases"), ";") {
+			if a = strings.TrimSpace(a); a != "" {
+				comp.Aliases = append(comp.Aliases, a)
+			}
+		}
+		out = append(out, comp)
+	}
+	return out, nil
+}
+
+// Conflict is a difference between what was entered for a song and what the
+// composition catalog says.
+type Conflict struct {
+	Song    string
+	Field   string // "raga", "tala" or "composer"
+	Given   []string
+	Catalog string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s is given as %s, the catalog says %s", c.Song, c.Field, strings.Join(c.Given, ", "), c.Catalog)
+}
+
+// Fill completes the ragas, talas and composers of m from the songs it
+// names. Fields that were already given are kept; when a song's catalog
+// entry disagrees with them, the difference is returned as a conflict.
+func (c *Compositions) Fill(m Metadata) (Metadata, []Conflict) {
+	var ragas, talas, composers []string
+	var conflicts []Conflict
+	for _, song := range m.SongsTaught {
+		comp, ok := c.Lookup(song)
+		if !ok {
+			continue
+		}
+		ragas = appendNew(ragas, comp.Raga, ragaKey)
+		talas = appendNew(talas, comp.Tala, talaKey)
+		composers = appendNew(composers, comp.Composer, catalog.Key)
+		check := func(field string, given []string, value string, key func(string) string) {
+			if len(given) == 0 || value == "" {
+				return
+			}
+			for _, g := range given {
+				if key(g) == key(value) {
+					return
+				}
+			}
+			conflicts = append(conflicts, Conflict{Song: song, Field: field, Given: given, Catalog: value})
+		}
+		check("raga", m.Ragas, comp.Raga, ragaKey)
+		check("tala", m.Talas, comp.Tala, talaKey)
+		check("composer", m.Composers, comp.Composer, catalog.Key)
+	}
+	if len(m.Ragas) == 0 {
+		m.Ragas = ragas
+	}
+	if len(m.Talas) == 0 {
+		m.Talas = talas
+	}
+	if len(m.Composers) == 0 {
+		m.Composers = composers
+	}
+	return m, conflicts
+}
+
+// ragaKey compares ragas by their catalog name, so aliases agree.
+func ragaKey(name string) string {
+	if r, ok := catalog.LookupRaga(name); ok {
+		return catalog.Key(r.Name)
+	}
+	return catalog.Key(name)
+}
+
+// talaKey compares talas by their canonical label.
+func talaKey(name string) string {
+	if t, ok := catalog.ParseTala(name); ok {
+		return catalog.Key(t.Name())
+	}
+	return catalog.Key(name)
+}
+
+func appendNew(list []string, v string, key func(string) string) []string {
+	if v == "" {
+		return list
+	}
+	for _, existing := range list {
+		if key(existing) == key(v) {
+			return list
+		}
+	}
+	return append(list, v)
+}
@@ -1,13 +1,28 @@
 package metadata
 
+import "time"
+
+// Metadata describes a recording. It is the one model shared by the chat
+// parsers, the upload pipeline, the organizer and the stored records; see
+// schema.go for its encodings, validation and versions.
 type Metadata struct {
-	GroupName   string
-	Teacher     string
-	SessionType string // in-person or virtual
-	SongsTaught []string
-	Ragas       []string
-	Talas       []string
-	Composers   []string
+	GroupName   string   `json:"group,omitempty" yaml:"group,omitempty"`
+	Teacher     string   `json:"teacher,omitempty" yaml:"teacher,omitempty"`
+	SessionType string   `json:"session_type,omitempty" yaml:"session_type,omitempty"` // in-person or virtual
+	SongsTaught []string `json:"songs,omitempty" yaml:"songs,omitempty"`
+	Ragas       []string `json:"ragas,omitempty" yaml:"ragas,omitempty"`
+	Talas       []string `json:"talas,omitempty" yaml:"talas,omitempty"`
+	Composers   []string `json:"composers,omitempty" yaml:"composers,omitempty"`
+
+	RecordedAt  time.Time `json:"recorded_at,omitempty" yaml:"recorded_at,omitempty"`
+	DateSource  string    `json:"date_source,omitempty" yaml:"date_source,omitempty"` // where RecordedAt came from, one of DateSources
+	Duration    Duration  `json:"duration,omitempty" yaml:"duration,omitempty"`
+	Performers  []string  `json:"performers,omitempty" yaml:"performers,omitempty"`
+	Instruments []string  `json:"instruments,omitempty" yaml:"instruments,omitempty"`
+	Lesson      int       `json:"lesson,omitempty" yaml:"lesson,omitempty"` // lesson number within the course
+	Source      string    `json:"source,omitempty" yaml:"source,omitempty"` // where the recording came from, one of Sources
+	Tags        []string  `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Chapters    []Chapter `json:"chapters,omitempty" yaml:"chapters,omitempty"` // parts of the recording, in order
 }
 
 func NewMetadata(groupName, teacher, sessionType string, songsTaught, ragas, talas, composers []string) *Metadata {
@@ -48,4 +63,4 @@ func (m *Metadata) GetTalas() []string {
 
 func (m *Metadata) GetComposers() []string {
 	return m.Composers
-}
\ No newline at end of file
+}
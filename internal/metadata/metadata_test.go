@@ -1,6 +1,15 @@
 package metadata
 
-import "testing"
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestNewMetadataAndGetters(t *testing.T) {
 	m := NewMetadata("Group1", "Teacher1", "virtual", []string{"Song1"}, []string{"Raga1"}, []string{"Tala1"}, []string{"Composer1"})
@@ -17,3 +26,353 @@ func TestNewMetadataAndGetters(t *testing.T) {
 		t.Errorf("unexpected SongsTaught")
 	}
 }
+
+func TestLoadFile_Errors(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"unknown.yaml": "group: Veena Class\nraga: Thodi\n",
+		"session.yaml": "group: Veena Class\nteacher: Lakshmi\nsession_type: hybrid\n",
+		"syntax.json":  "{\n  \"group\": \"Veena Class\",\n  \"ragas\": [\"Thodi\"\n}\n",
+	}
+	want := map[string]string{
+		"unknown.yaml": "unknown.yaml:2: unknown field \"raga\"",
+		"session.yaml": "session.yaml:3: invalid session type \"hybrid\"",
+		"syntax.json":  "syntax.json:",
+	}
+	for name, body := range cases {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(body), 0644)
+		_, err := LoadFile(path)
+		if err == nil || !strings.Contains(err.Error(), want[name]) {
+			t.Errorf("%s: expected error containing %q, got %v", name, want[name], err)
+		}
+	}
+}
+
+func TestFolderDefaultsAndSidecar(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "2024-01")
+	os.MkdirAll(sub, 0755)
+	os.WriteFile(filepath.Join(root, FolderFile), []byte("group: Veena Class\nteacher: Lakshmi\nsession_type: in person\n"), 0644)
+	os.WriteFile(filepath.Join(sub, FolderFile), []byte("session_type: online\n"), 0644)
+	media := filepath.Join(sub, "recording.m4a")
+	os.WriteFile(media+".json", []byte(`{"songs": "Vatapi Ganapatim", "ragas": ["Hamsadhwani"]}`), 0644)
+
+	folder, err := FolderDefaults(media, root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if folder.GroupName != "Veena Class" || folder.SessionType != "virtual" {
+		t.Errorf("unexpected folder defaults: %+v", folder)
+	}
+	sidecar, ok, err := Sidecar(media)
+	if err != nil || !ok {
+		t.Fatalf("expected sidecar, got %v %v", ok, err)
+	}
+
+	merged := Merge(folder, Metadata{Teacher: "Lakshmi Raman"}, sidecar, Metadata{GroupName: "Override"})
+	if merged.GroupName != "Override" || merged.Teacher != "Lakshmi Raman" || merged.SessionType != "virtual" {
+		t.Errorf("unexpected merge: %+v", merged)
+	}
+	if len(merged.SongsTaught) != 1 || merged.SongsTaught[0] != "Vatapi Ganapatim" || merged.Ragas[0] != "Hamsadhwani" {
+		t.Errorf("unexpected lists: %+v", merged)
+	}
+}
+
+// Helpers building minimal tagged files.
+
+func id3Frame(id string, body []byte) []byte {
+	h := make([]byte, 10)
+	copy(h, id)
+	binary.BigEndian.PutUint32(h[4:], uint32(len(body)))
+	return append(h, body...)
+}
+
+func id3Tag(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, 16)...) // padding
+	n := len(body)
+	h := []byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+	return append(h, body...)
+}
+
+func utf16Text(s string) []byte {
+	b := []byte{1, 0xff, 0xfe}
+	for _, r := range s {
+		b = append(b, byte(r), byte(r>>8))
+	}
+	return b
+}
+
+func vorbisBlock(fields ...string) []byte {
+	var b bytes.Buffer
+	le := func(n int) { binary.Write(&b, binary.LittleEndian, uint32(n)) }
+	le(len("test"))
+	b.WriteString("test")
+	le(len(fields))
+	for _, f := range fields {
+		le(len(f))
+		b.WriteString(f)
+	}
+	return b.Bytes()
+}
+
+func oggPage(serial uint32, packet []byte) []byte {
+	h := make([]byte, 27)
+	copy(h, "OggS")
+	binary.LittleEndian.PutUint32(h[14:], serial)
+	var segments []byte
+	n := len(packet)
+	for ; n >= 255; n -= 255 {
+		segments = append(segments, 255)
+	}
+	segments = append(segments, byte(n))
+	h[26] = byte(len(segments))
+	return append(append(h, segments...), packet...)
+}
+
+func atom(kind string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	h := make([]byte, 8)
+	binary.BigEndian.PutUint32(h, uint32(8+len(body)))
+	copy(h[4:], kind)
+	return append(h, body...)
+}
+
+func mp4Text(s string) []byte {
+	return atom("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte(s))
+}
+
+func TestReadTags(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, b []byte) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, b, 0644)
+		return path
+	}
+
+	mp3 := id3Tag(
+		id3Frame("TIT2", utf16Text("Vatapi Ganapatim")),
+		id3Frame("TPE1", append([]byte{0}, "Lakshmi Raman"...)),
+		id3Frame("TALB", append([]byte{3}, "Veena Class 2024-01-05"...)),
+		id3Frame("TCON", append([]byte{0}, "(8)Hamsadhwani"...)),
+		id3Frame("COMM", append([]byte{3, 'e', 'n', 'g', 0}, "Tala: Adi; Composer: Muthuswami Dikshitar"...)),
+	)
+	mp3 = append(mp3, 0xff, 0xfb)
+
+	comments := vorbisBlock("TITLE=Vatapi Ganapatim", "artist=Lakshmi Raman", "ALBUM=Veena Class", "GENRE=Carnatic", "RAGA=Hamsadhwani", "TALA=Adi", "COMPOSER=Muthuswami Dikshitar")
+	flac := append([]byte("fLaC"), 0x80|4, 0, 0, byte(len(comments)))
+	flac = append(append(flac, comments...), 0xff, 0xf8)
+
+	opus := oggPage(7, []byte("OpusHead\x01\x02"))
+	opus = append(opus, oggPage(7, append([]byte("OpusTags"), vorbisBlock("TITLE=Vatapi Ganapatim", "ARTIST=Lakshmi Raman", "ALBUM=Veena Class", "GENRE=Hamsadhwani", "COMMENT=Tala: Adi; Composer: Muthuswami Dikshitar")...))...)
+
+	ilst := atom("ilst",
+		atom("\xa9nam", mp4Text("Vatapi Ganapatim")),
+		atom("\xa9ART", mp4Text("Lakshmi Raman")),
+		atom("\xa9alb", mp4Text("Veena Class 2024-01-05")),
+		atom("\xa9gen", mp4Text("Hamsadhwani")),
+		atom("\xa9wrt", mp4Text("Muthuswami Dikshitar")),
+		atom("----", atom("mean", []byte("\x00\x00\x00\x00com.apple.iTunes")), atom("name", []byte("\x00\x00\x00\x00TALA")), mp4Text("Adi")),
+	)
+	m4a := atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	m4a = append(m4a, atom("mdat", []byte("audio"))...)
+	m4a = append(m4a, atom("moov", atom("udta", atom("meta", []byte{0, 0, 0, 0}, atom("hdlr", make([]byte, 25)), ilst)))...)
+
+	// A moov atom of size 0 runs to the end of the file.
+	toEOF := append([]byte(nil), m4a...)
+	moov := bytes.LastIndex(toEOF, []byte("moov")) - 4
+	binary.BigEndian.PutUint32(toEOF[moov:], 0)
+
+	files := map[string]string{
+		"id3":     write("class.mp3", mp3),
+		"flac":    write("class.flac", flac),
+		"opus":    write("class.opus", opus),
+		"mp4":     write("class.m4a", m4a),
+		"mp4-eof": write("eof.m4a", toEOF),
+	}
+
+	want := Metadata{
+		GroupName:   "Veena Class",
+		Teacher:     "Lakshmi Raman",
+		SongsTaught: []string{"Vatapi Ganapatim"},
+		Ragas:       []string{"Hamsadhwani"},
+		Talas:       []string{"Adi"},
+		Composers:   []string{"Muthuswami Dikshitar"},
+	}
+	for format, path := range files {
+		got, err := Embedded(path)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Embedded = %+v, want %+v", format, got, want)
+		}
+	}
+
+	plain := write("notes.wav", []byte("RIFF...."))
+	if tags, err := ReadTags(plain); err != nil || len(tags) != 0 {
+		t.Errorf("expected no tags for an unsupported format, got %v %v", tags, err)
+	}
+	broken := write("broken.mp3", []byte("ID3\x03\x00\x00\x00\x00\x10\x00TIT2"))
+	if _, err := ReadTags(broken); err == nil {
+		t.Errorf("expected an error for a truncated ID3 tag")
+	}
+	// A moov of size 0 that is shorter than a header, and an extended size
+	// below the 16 bytes of the header itself.
+	ftyp := atom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	short := append(append([]byte(nil), ftyp...), "\x00\x00\x00\x00moov\x00\x00\x00"...)
+	if tags, err := ReadTags(write("short.m4a", short)); err != nil || len(tags) != 0 {
+		t.Errorf("expected no tags for a short moov, got %v %v", tags, err)
+	}
+	extended := append(append([]byte(nil), ftyp...), "\x00\x00\x00\x01moov\x00\x00\x00\x00\x00\x00\x00\x08"...)
+	if _, err := ReadTags(write("extended.m4a", extended)); err == nil || !strings.Contains(err.Error(), errTagSize.Error()) {
+		t.Errorf("expected an error for an extended size below 16, got %v", err)
+	}
+}
+
+func TestCompositions(t *testing.T) {
+	c := NewCompositions()
+
+	filled, conflicts := c.Fill(Metadata{SongsTaught: []string{"Vatapi Ganapathim", "Unknown Song"}})
+	if len(conflicts) != 0 {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+	if !reflect.DeepEqual(filled.Ragas, []string{"Hamsadhwani"}) || !reflect.DeepEqual(filled.Talas, []string{"Adi"}) || !reflect.DeepEqual(filled.Composers, []string{"Muthuswami Dikshitar"}) {
+		t.Errorf("unexpected fill: %+v", filled)
+	}
+
+	// given values are kept; an alias of the same raga is no conflict
+	filled, conflicts = c.Fill(Metadata{SongsTaught: []string{"Sami Ninne Kori", "Endaro"}, Ragas: []string{"Shankarabharanam"}, Talas: []string{"Aadi"}})
+	if !reflect.DeepEqual(filled.Ragas, []string{"Shankarabharanam"}) || len(filled.Composers) != 2 {
+		t.Errorf("unexpected fill: %+v", filled)
+	}
+	if len(conflicts) != 1 || conflicts[0].Song != "Endaro" || conflicts[0].Field != "raga" || conflicts[0].Catalog != "Sri" {
+		t.Errorf("expected a raga conflict for Endaro, got %v", conflicts)
+	}
+
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "songs.csv")
+	os.WriteFile(csvPath, []byte("title,raga,tala,composer,aliases\nInta Saukhya,Kapi,Adi,Tyagaraja,Intha Sowkhyamani\nVatapi Ganapatim,Hamsadhwani,Adi,Dikshitar,\n"), 0644)
+	jsonPath := filepath.Join(dir, "songs.json")
+	os.WriteFile(jsonPath, []byte(`[{"title": "Bantureethi", "raga": "Hamsanadam", "tala": "Adi", "composer": "Tyagaraja"}]`), 0644)
+	tsvPath := filepath.Join(dir, "SONGS.TSV")
+	os.WriteFile(tsvPath, []byte("title\traga\ttala\tcomposer\nNagumomu, Ganaleni\tAbheri\tAdi\tTyagaraja\n"), 0644)
+	for _, path := range []string{csvPath, jsonPath, tsvPath} {
+		if err := c.LoadFile(path); err != nil {
+			t.Fatalf("LoadFile(%s): %v", path, err)
+		}
+	}
+	if comp, ok := c.Lookup("intha sowkhyamani"); !ok || comp.Raga != "Kapi" {
+		t.Errorf("alias from CSV not found: %+v", comp)
+	}
+	if comp, _ := c.Lookup("Vatapi Ganapatim"); comp.Composer != "Dikshitar" {
+		t.Errorf("local entry should replace the built-in one, got %+v", comp)
+	}
+	if _, ok := c.Lookup("Bantureethi"); !ok {
+		t.Errorf("entry from JSON not found")
+	}
+	if comp, ok := c.Lookup("Nagumomu, Ganaleni"); !ok || comp.Raga != "Abheri" {
+		t.Errorf("entry from TSV not found: %+v", comp)
+	}
+	txtPath := filepath.Join(dir, "songs.txt")
+	os.WriteFile(txtPath, []byte("Nagumomu"), 0644)
+	if err := c.LoadFile(txtPath); err == nil || !strings.Contains(err.Error(), ".tsv") {
+		t.Errorf("expected the supported file types listed, got %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad.csv")
+	os.WriteFile(bad, []byte("song,raga\nX,Y\n"), 0644)
+	if err := c.LoadFile(bad); err == nil {
+		t.Errorf("expected an error for a CSV without a title column")
+	}
+}
+
+func TestSchema_RoundTripAndMigrate(t *testing.T) {
+	m := Metadata{
+		GroupName:   "Veena Class",
+		SessionType: "in-person",
+		SongsTaught: []string{"Vatapi Ganapatim"},
+		RecordedAt:  time.Date(2024, 3, 5, 18, 30, 0, 0, time.Local),
+		Duration:    Duration(45*time.Minute + 10*time.Second),
+		Performers:  []string{"Ravi", "Meena"},
+		Instruments: []string{"veena"},
+		Lesson:      12,
+		Source:      SourceZoom,
+		Tags:        []string{"varnam"},
+	}
+	for _, encode := range []func(Metadata) ([]byte, error){EncodeJSON, EncodeYAML} {
+		b, err := encode(m)
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		if !strings.Contains(string(b), "version") || !strings.Contains(string(b), "45m10s") {
+			t.Errorf("expected version and readable duration in %s", b)
+		}
+		got, err := Decode(b)
+		if err != nil {
+			t.Fatalf("decode %s: %v", b, err)
+		}
+		if !got.RecordedAt.Equal(m.RecordedAt) {
+			t.Errorf("recorded_at = %v, want %v", got.RecordedAt, m.RecordedAt)
+		}
+		got.RecordedAt = m.RecordedAt
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("round trip of %s = %+v", b, got)
+		}
+	}
+
+	// Records written before the schema had a version.
+	got, err := Decode([]byte(`{"group": "Veena Class", "session_type": "Online", "songs": ["Vatapi", " "]}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.SessionType != "virtual" || !reflect.DeepEqual(got.SongsTaught, []string{"Vatapi"}) {
+		t.Errorf("unexpected migration result %+v", got)
+	}
+	if _, err := Decode([]byte("version: 99\ngroup: G\n")); err == nil {
+		t.Error("expected error for a newer schema version")
+	}
+}
+
+func TestSchema_SidecarFieldsAndValidation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "class.m4a.yaml")
+	os.WriteFile(path, []byte("recorded_at: 2024-03-05 18:30\nduration: 2712\nlesson: 12\nsource: Zoom\nperformers: Ravi\n"), 0644)
+	m, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.RecordedAt.Hour() != 18 || time.Duration(m.Duration) != 2712*time.Second || m.Lesson != 12 || m.Source != SourceZoom || len(m.Performers) != 1 {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+
+	os.WriteFile(path, []byte("chapters:\n  - 0:00 Varnam\n  - 1:02:30 Vatapi Ganapatim\n"), 0644)
+	m, err = LoadFile(path)
+	if err != nil || len(m.Chapters) != 2 || m.Chapters[1].Start != time.Hour+2*time.Minute+30*time.Second || m.Chapters[1].Title != "Vatapi Ganapatim" {
+		t.Fatalf("unexpected chapters %+v, %v", m.Chapters, err)
+	}
+	var edited Metadata
+	if err := SetField(&edited, "chapters", FieldValue(m, "chapters")); err != nil || !reflect.DeepEqual(edited.Chapters, m.Chapters) {
+		t.Errorf("expected chapters to survive their text form %q, got %+v, %v", FieldValue(m, "chapters"), edited.Chapters, err)
+	}
+
+	cases := map[string]string{
+		"chapters: [12:30 Vatapi, 1:00 Varnam]\n": "class.m4a.yaml:1: chapter \"1:00 Varnam\" starts before",
+		"group: x\nchapters: [soon Varnam]\n":     "class.m4a.yaml:2: field \"chapters\": invalid chapter",
+		"recorded_at: last tuesday\n":             `class.m4a.yaml:1: field "recorded_at": invalid date`,
+		"lesson: -2\n":                            "invalid lesson number -2",
+		"source: fax\n":                           `invalid source "fax"`,
+		"duration: long\n":                        "invalid duration",
+		"group: x\ndate_source: guess\n":          `class.m4a.yaml:2: invalid date source "guess"`,
+		"tags: [a, '']\nsource: fax\n":            "class.m4a.yaml:1: tags has an empty entry",
+		"group: x\nversion: 99\n":                 "class.m4a.yaml:2: metadata schema version 99",
+	}
+	for content, want := range cases {
+		os.WriteFile(path, []byte(content), 0644)
+		if _, err := LoadFile(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: expected error containing %q, got %v", content, want, err)
+		}
+	}
+}
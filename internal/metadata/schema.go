@@ -0,0 +1,438 @@
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SchemaVersion is the version of the metadata schema written by this build.
+// Version 1 is the original seven fields; records without a version are
+// version 1. Version 2 added the recording date, duration, performers,
+// instruments, lesson number, source and tags; version 3 added chapters.
+const SchemaVersion = 3
+
+// Sources of a recording.
+const (
+	SourceWhatsApp = "whatsapp"
+	SourceTelegram = "telegram"
+	SourceZoom     = "zoom"
+	SourceFolder   = "folder" // found by scanning a folder
+	SourceImport   = "import" // described by an imported spreadsheet
+	SourceManual   = "manual" // entered by hand
+)
+
+// Sources are the valid values of Metadata.Source.
+var Sources = []string{SourceWhatsApp, SourceTelegram, SourceZoom, SourceFolder, SourceImport, SourceManual}
+
+// Where a recording date came from. The chat, file name, container and
+// modification time sources are tried in turn when no date is given.
+const (
+	DateFromMetadata  = "metadata"      // a sidecar, folder default, spreadsheet or edit
+	DateFromChat      = "chat"          // the chat message the file was shared with
+	DateFromFileName  = "filename"      // a WhatsApp or Zoom file or folder name
+	DateFromContainer = "creation_time" // the creation_time in the media container
+	DateFromModTime   = "mtime"         // the file's modification time
+)
+
+// DateSources are the valid values of Metadata.DateSource.
+var DateSources = []string{DateFromMetadata, DateFromChat, DateFromFileName, DateFromContainer, DateFromModTime}
+
+// Duration is the length of a recording. It is written like "1h2m3s" and
+// also read from a plain number of seconds.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).Round(time.Second).String()
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		*d = Duration(secs * float64(time.Second))
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q (use e.g. 1h2m3s or a number of seconds)", s)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Chapter is a named part of a recording, such as one song of a class. It
+// is written as its start time and title: "12:30 Vatapi Ganapatim".
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+func (c Chapter) String() string {
+	secs := int(c.Start / time.Second)
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d %s", secs/3600, secs/60%60, secs%60, c.Title)
+	}
+	return fmt.Sprintf("%d:%02d %s", secs/60, secs%60, c.Title)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (c Chapter) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (c *Chapter) UnmarshalText(b []byte) error {
+	s := strings.TrimSpace(string(b))
+	start, title := s, ""
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		start, title = s[:i], strings.TrimSpace(s[i+1:])
+	}
+	parts := strings.Split(start, ":")
+	var secs int
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || len(parts) < 2 || len(parts) > 3 {
+			return fmt.Errorf("invalid chapter %q (use a start time and title, e.g. 12:30 Vatapi Ganapatim)", s)
+		}
+		secs = secs*60 + n
+	}
+	*c = Chapter{Start: time.Duration(secs) * time.Second, Title: title}
+	return nil
+}
+
+// dateLayouts are the ways a recording date may be written, most precise first.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// ParseDate reads a recording date: RFC 3339, or a date with an optional
+// time of day in local time.
+func ParseDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD, optionally with HH:MM)", s)
+}
+
+// jsonRecord is the JSON form of metadata. It leaves out an unset recording
+// date, which encoding/json would otherwise write as year 1.
+type jsonRecord struct {
+	Version int `json:"version,omitempty"`
+	plainMetadata
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+}
+
+// plainMetadata is Metadata without its MarshalJSON method.
+type plainMetadata Metadata
+
+func newJSONRecord(m Metadata, version int) jsonRecord {
+	r := jsonRecord{Version: version, plainMetadata: plainMetadata(m)}
+	if !m.RecordedAt.IsZero() {
+		r.RecordedAt = &m.RecordedAt
+	}
+	return r
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m Metadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newJSONRecord(m, 0))
+}
+
+// Validate reports every value that does not fit the schema.
+func (m Metadata) Validate() error {
+	problems := m.problems()
+	if len(problems) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(problems))
+	for i, p := range problems {
+		msgs[i] = p.msg
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// problem is a value that does not fit the schema, with the field (as
+// named in metadata files) holding it.
+type problem struct {
+	field, msg string
+}
+
+func (m Metadata) problems() []problem {
+	var problems []problem
+	add := func(field, format string, args ...interface{}) {
+		problems = append(problems, problem{field, fmt.Sprintf(format, args...)})
+	}
+	switch m.SessionType {
+	case "", "in-person", "virtual":
+	default:
+		add("session_type", "invalid session type %q (use in-person or virtual)", m.SessionType)
+	}
+	if m.Source != "" && !contains(Sources, m.Source) {
+		add("source", "invalid source %q (use one of %s)", m.Source, strings.Join(Sources, ", "))
+	}
+	if m.DateSource != "" && !contains(DateSources, m.DateSource) {
+		add("date_source", "invalid date source %q (use one of %s)", m.DateSource, strings.Join(DateSources, ", "))
+	}
+	if m.Lesson < 0 {
+		add("lesson", "invalid lesson number %d", m.Lesson)
+	}
+	if m.Duration < 0 {
+		add("duration", "invalid duration %s", m.Duration)
+	}
+	if !m.RecordedAt.IsZero() && (m.RecordedAt.Year() < 1900 || m.RecordedAt.After(time.Now().Add(24*time.Hour))) {
+		add("recorded_at", "implausible recording date %s", m.RecordedAt.Format("2006-01-02"))
+	}
+	lists := []struct {
+		name   string
+		values []string
+	}{
+		{"songs", m.SongsTaught}, {"ragas", m.Ragas}, {"talas", m.Talas}, {"composers", m.Composers},
+		{"performers", m.Performers}, {"instruments", m.Instruments}, {"tags", m.Tags},
+	}
+	for _, l := range lists {
+		for _, v := range l.values {
+			if strings.TrimSpace(v) == "" {
+				add(l.name, "%s has an empty entry", l.name)
+				break
+			}
+		}
+	}
+	for i, c := range m.Chapters {
+		if c.Title == "" {
+			add("chapters", "chapter at %s has no title", strings.TrimSpace(c.String()))
+			break
+		}
+		if i > 0 && c.Start <= m.Chapters[i-1].Start {
+			add("chapters", "chapter %q starts before the one above it", c.String())
+			break
+		}
+	}
+	return problems
+}
+
+func contains(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// migrations[v] upgrades a record of version v+1 to the next version.
+var migrations = []func(m *Metadata){
+	// 1 → 2: version 1 stored session types as typed and could hold blank
+	// list entries; the new fields start out empty.
+	func(m *Metadata) {
+		if st, err := NormalizeSessionType(m.SessionType); err == nil {
+			m.SessionType = st
+		}
+		for _, l := range []*[]string{&m.SongsTaught, &m.Ragas, &m.Talas, &m.Composers} {
+			*l = trimList(*l)
+		}
+	},
+	// 2 → 3: chapters start out empty.
+	func(m *Metadata) {},
+}
+
+// Migrate upgrades metadata stored under an earlier schema version to the
+// current one. Version 0 means the record had no version and is treated as
+// version 1.
+func Migrate(m Metadata, version int) (Metadata, error) {
+	if version == 0 {
+		version = 1
+	}
+	if version > SchemaVersion {
+		return Metadata{}, fmt.Errorf("metadata schema version %d is newer than this program supports (%d)", version, SchemaVersion)
+	}
+	for v := version; v < SchemaVersion; v++ {
+		migrations[v-1](&m)
+	}
+	return m, nil
+}
+
+func trimList(list []string) []string {
+	var out []string
+	for _, v := range list {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// EncodeJSON writes m as a JSON record carrying the schema version.
+func EncodeJSON(m Metadata) ([]byte, error) {
+	return json.MarshalIndent(newJSONRecord(m, SchemaVersion), "", "  ")
+}
+
+// EncodeYAML writes m as a YAML record carrying the schema version.
+func EncodeYAML(m Metadata) ([]byte, error) {
+	return yaml.Marshal(struct {
+		Version  int `yaml:"version"`
+		Metadata `yaml:",inline"`
+	}{SchemaVersion, m})
+}
+
+// Decode reads a JSON or YAML record, upgrades it to the current schema
+// version and validates it.
+func Decode(b []byte) (Metadata, error) {
+	return parse("record", b)
+}
+
+// Fields are the names of the metadata fields, as written in metadata files
+// and spreadsheet headers.
+var Fields = []string{
+	"group", "teacher", "session_type", "songs", "ragas", "talas", "composers",
+	"recorded_at", "date_source", "duration", "performers", "instruments", "lesson", "source", "tags",
+	"chapters",
+}
+
+// list returns the list field called name, or nil if it is not a list.
+func (m *Metadata) list(name string) *[]string {
+	switch name {
+	case "songs":
+		return &m.SongsTaught
+	case "ragas":
+		return &m.Ragas
+	case "talas":
+		return &m.Talas
+	case "composers":
+		return &m.Composers
+	case "performers":
+		return &m.Performers
+	case "instruments":
+		return &m.Instruments
+	case "tags":
+		return &m.Tags
+	}
+	return nil
+}
+
+// SetField sets the field called name from its text form, as FieldValue
+// writes it: lists are separated by semicolons. An empty value clears the
+// field.
+func SetField(m *Metadata, name, value string) error {
+	value = strings.TrimSpace(value)
+	if l := m.list(name); l != nil {
+		*l = trimList(strings.Split(value, ";"))
+		return nil
+	}
+	switch name {
+	case "group":
+		m.GroupName = value
+	case "teacher":
+		m.Teacher = value
+	case "session_type":
+		st, err := NormalizeSessionType(value)
+		if err != nil {
+			return err
+		}
+		m.SessionType = st
+	case "recorded_at":
+		m.RecordedAt, m.DateSource = time.Time{}, ""
+		if value != "" {
+			t, err := ParseDate(value)
+			if err != nil {
+				return err
+			}
+			m.RecordedAt, m.DateSource = t, DateFromMetadata
+		}
+	case "date_source":
+		m.DateSource = strings.ToLower(value)
+	case "duration":
+		m.Duration = 0
+		if value != "" {
+			return m.Duration.UnmarshalText([]byte(value))
+		}
+	case "lesson":
+		m.Lesson = 0
+		if value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid lesson number %q", value)
+			}
+			m.Lesson = n
+		}
+	case "source":
+		m.Source = strings.ToLower(value)
+	case "chapters":
+		m.Chapters = nil
+		for _, v := range trimList(strings.Split(value, ";")) {
+			var c Chapter
+			if err := c.UnmarshalText([]byte(v)); err != nil {
+				return err
+			}
+			m.Chapters = append(m.Chapters, c)
+		}
+	default:
+		return fmt.Errorf("unknown field %q", name)
+	}
+	return nil
+}
+
+// FieldValue returns the field called name in the text form SetField reads.
+func FieldValue(m Metadata, name string) string {
+	if l := m.list(name); l != nil {
+		return strings.Join(*l, "; ")
+	}
+	switch name {
+	case "group":
+		return m.GroupName
+	case "teacher":
+		return m.Teacher
+	case "session_type":
+		return m.SessionType
+	case "recorded_at":
+		if m.RecordedAt.IsZero() {
+			return ""
+		}
+		if h, min, s := m.RecordedAt.Clock(); h == 0 && min == 0 && s == 0 {
+			return m.RecordedAt.Format("2006-01-02")
+		}
+		return m.RecordedAt.Format("2006-01-02 15:04")
+	case "date_source":
+		return m.DateSource
+	case "duration":
+		if m.Duration == 0 {
+			return ""
+		}
+		return m.Duration.String()
+	case "lesson":
+		if m.Lesson == 0 {
+			return ""
+		}
+		return strconv.Itoa(m.Lesson)
+	case "source":
+		return m.Source
+	case "chapters":
+		chapters := make([]string, len(m.Chapters))
+		for i, c := range m.Chapters {
+			chapters[i] = c.String()
+		}
+		return strings.Join(chapters, "; ")
+	}
+	return ""
+}
+
+// Diff lists the fields that differ between a and b as "field: old → new".
+func Diff(a, b Metadata) []string {
+	var out []string
+	for _, f := range Fields {
+		if va, vb := FieldValue(a, f), FieldValue(b, f); va != vb {
+			out = append(out, fmt.Sprintf("%s: %q → %q", f, va, vb))
+		}
+	}
+	return out
+}
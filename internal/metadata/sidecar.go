@@ -0,0 +1,292 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FolderFile is the name of the per-folder metadata defaults file.
+const FolderFile = "musicloud.yaml"
+
+// sidecarExts are the extensions tried, in order, after a media file's full
+// name to find its sidecar: recording.m4a.yaml, recording.m4a.yml, recording.m4a.json.
+var sidecarExts = []string{".yaml", ".yml", ".json"}
+
+var syntaxLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// FileError is a problem in a metadata file, pointing at the offending line.
+type FileError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// LoadFile reads metadata from a YAML or JSON file. Unknown fields and invalid
+// values are reported with their line number. List fields accept a single
+// value as well as a list. Files written under an earlier schema version are
+// upgraded.
+func LoadFile(path string) (Metadata, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return parse(path, b)
+}
+
+// parse reads the metadata in b, which came from path.
+func parse(path string, b []byte) (Metadata, error) {
+	// JSON is valid YAML, so one parser handles both and reports lines for both.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		fe := &FileError{Path: path, Err: err}
+		if m := syntaxLine.FindStringSubmatch(err.Error()); m != nil {
+			fe.Line, _ = strconv.Atoi(m[1])
+			fe.Err = errors.New(m[2])
+		}
+		return Metadata{}, fe
+	}
+	if len(doc.Content) == 0 {
+		return Metadata{}, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return Metadata{}, &FileError{Path: path, Line: root.Line, Err: fmt.Errorf("expected a mapping of metadata fields")}
+	}
+
+	var m Metadata
+	var version int
+	fields := map[string]interface{}{
+		"version":      &version,
+		"group":        &m.GroupName,
+		"teacher":      &m.Teacher,
+		"session_type": &m.SessionType,
+		"songs":        &m.SongsTaught,
+		"ragas":        &m.Ragas,
+		"talas":        &m.Talas,
+		"composers":    &m.Composers,
+		"recorded_at":  &m.RecordedAt,
+		"date_source":  &m.DateSource,
+		"duration":     &m.Duration,
+		"performers":   &m.Performers,
+		"instruments":  &m.Instruments,
+		"lesson":       &m.Lesson,
+		"source":       &m.Source,
+		"tags":         &m.Tags,
+		"chapters":     &m.Chapters,
+	}
+	lines := map[string]int{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		dst, ok := fields[key.Value]
+		if !ok {
+			return Metadata{}, &FileError{Path: path, Line: key.Line, Err: fmt.Errorf("unknown field %q", key.Value)}
+		}
+		lines[key.Value] = value.Line
+		if key.Value == "recorded_at" {
+			t, err := ParseDate(value.Value)
+			if err != nil || value.Kind != yaml.ScalarNode {
+				return Metadata{}, &FileError{Path: path, Line: value.Line, Err: fmt.Errorf("field %q: invalid date %q (use YYYY-MM-DD, optionally with HH:MM)", key.Value, value.Value)}
+			}
+			m.RecordedAt = t
+			continue
+		}
+		if _, isList := dst.(*[]string); isList && value.Kind == yaml.ScalarNode {
+			value = &yaml.Node{Kind: yaml.SequenceNode, Line: value.Line, Content: []*yaml.Node{value}}
+		}
+		if err := value.Decode(dst); err != nil {
+			return Metadata{}, &FileError{Path: path, Line: value.Line, Err: fmt.Errorf("field %q: %v", key.Value, decodeMessage(err))}
+		}
+		if key.Value == "session_type" {
+			st, err := NormalizeSessionType(m.SessionType)
+			if err != nil {
+				return Metadata{}, &FileError{Path: path, Line: value.Line, Err: err}
+			}
+			m.SessionType = st
+		}
+		if key.Value == "source" {
+			m.Source = strings.ToLower(strings.TrimSpace(m.Source))
+		}
+		if key.Value == "date_source" {
+			m.DateSource = strings.ToLower(strings.TrimSpace(m.DateSource))
+		}
+	}
+	m, err := Migrate(m, version)
+	if err != nil {
+		return Metadata{}, &FileError{Path: path, Line: lines["version"], Err: err}
+	}
+	// Of several invalid values, the one nearest the top of the file is
+	// reported.
+	if problems := m.problems(); len(problems) > 0 {
+		first := problems[0]
+		for _, p := range problems[1:] {
+			if lines[p.field] < lines[first.field] {
+				first = p
+			}
+		}
+		return Metadata{}, &FileError{Path: path, Line: lines[first.field], Err: errors.New(first.msg)}
+	}
+	return m, nil
+}
+
+// decodeMessage strips the generic prefix and line number yaml adds, since
+// FileError carries the line already.
+func decodeMessage(err error) string {
+	msg := strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n")
+	msg = strings.TrimSpace(msg)
+	if i := strings.Index(msg, ": "); strings.HasPrefix(msg, "line ") && i > 0 {
+		msg = msg[i+2:]
+	}
+	return msg
+}
+
+// NormalizeSessionType maps the ways people write a session type to
+// "in-person" or "virtual".
+func NormalizeSessionType(s string) (string, error) {
+	switch strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(s, "-", " ")), " ")) {
+	case "":
+		return "", nil
+	case "in person", "inperson", "offline", "physical":
+		return "in-person", nil
+	case "virtual", "online", "zoom", "remote":
+		return "virtual", nil
+	}
+	return "", fmt.Errorf("invalid session type %q (use in-person or virtual)", s)
+}
+
+// FolderDefaults merges the musicloud.yaml files found in root and every
+// folder below it on the way to mediaPath; deeper folders win.
+func FolderDefaults(mediaPath, root string) (Metadata, error) {
+	rel, err := filepath.Rel(root, filepath.Dir(mediaPath))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = "."
+	}
+	dirs := []string{root}
+	if rel != "." {
+		dir := root
+		for _, part := range strings.Split(rel, string(filepath.Separator)) {
+			dir = filepath.Join(dir, part)
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var merged Metadata
+	for _, dir := range dirs {
+		path := filepath.Join(dir, FolderFile)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		m, err := LoadFile(path)
+		if err != nil {
+			return Metadata{}, err
+		}
+		merged = Merge(merged, m)
+	}
+	return merged, nil
+}
+
+// Sidecar loads the sidecar file of a media file, if it has one.
+func Sidecar(mediaPath string) (Metadata, bool, error) {
+	for _, ext := range sidecarExts {
+		path := mediaPath + ext
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		m, err := LoadFile(path)
+		return m, true, err
+	}
+	return Metadata{}, false, nil
+}
+
+// WriteSidecar saves m as the sidecar of a media file, replacing the
+// sidecar it has or creating a YAML one.
+func WriteSidecar(mediaPath string, m Metadata) error {
+	path := mediaPath + sidecarExts[0]
+	for _, ext := range sidecarExts {
+		if _, err := os.Stat(mediaPath + ext); err == nil {
+			path = mediaPath + ext
+			break
+		}
+	}
+	encode := EncodeYAML
+	if filepath.Ext(path) == ".json" {
+		encode = EncodeJSON
+	}
+	b, err := encode(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// Merge combines metadata layers from least to most specific: every non-empty
+// field of a later layer replaces the same field of the layers before it.
+func Merge(layers ...Metadata) Metadata {
+	var out Metadata
+	for _, l := range layers {
+		if l.GroupName != "" {
+			out.GroupName = l.GroupName
+		}
+		if l.Teacher != "" {
+			out.Teacher = l.Teacher
+		}
+		if l.SessionType != "" {
+			out.SessionType = l.SessionType
+		}
+		if len(l.SongsTaught) > 0 {
+			out.SongsTaught = l.SongsTaught
+		}
+		if len(l.Ragas) > 0 {
+			out.Ragas = l.Ragas
+		}
+		if len(l.Talas) > 0 {
+			out.Talas = l.Talas
+		}
+		if len(l.Composers) > 0 {
+			out.Composers = l.Composers
+		}
+		if !l.RecordedAt.IsZero() {
+			// the date and where it came from go together
+			out.RecordedAt, out.DateSource = l.RecordedAt, l.DateSource
+		}
+		if l.Duration != 0 {
+			out.Duration = l.Duration
+		}
+		if len(l.Performers) > 0 {
+			out.Performers = l.Performers
+		}
+		if len(l.Instruments) > 0 {
+			out.Instruments = l.Instruments
+		}
+		if l.Lesson != 0 {
+			out.Lesson = l.Lesson
+		}
+		if l.Source != "" {
+			out.Source = l.Source
+		}
+		if len(l.Tags) > 0 {
+			out.Tags = l.Tags
+		}
+		if len(l.Chapters) > 0 {
+			out.Chapters = l.Chapters
+		}
+	}
+	return out
+}
@@ -0,0 +1,633 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// Tag names used by ReadTags, whatever the file format calls them.
+const (
+	TagTitle    = "title"
+	TagArtist   = "artist"
+	TagAlbum    = "album"
+	TagGenre    = "genre"
+	TagComment  = "comment"
+	TagComposer = "composer"
+	TagDate     = "date"
+	TagRaga     = "raga"
+	TagTala     = "tala"
+)
+
+// maxTagBytes bounds how much of a file is read as tags, so a corrupt size
+// field cannot make us load a whole recording.
+const maxTagBytes = 16 << 20
+
+var errTagSize = errors.New("tag size out of range")
+
+// ReadTags reads the tags embedded in a media file: ID3v2 (MP3, and some
+// AAC and FLAC files), MP4 ilst atoms (MP4, M4A), Vorbis comments in Ogg
+// (Opus, Vorbis) and FLAC. A file in another format, or without tags, yields
+// no tags and no error.
+func ReadTags(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var magic [12]byte
+	n, _ := io.ReadFull(f, magic[:])
+	head := magic[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var tags map[string][]string
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		tags, err = readID3(f)
+		if err == nil && len(tags) == 0 {
+			// FLAC files sometimes carry an ID3 tag in front of the stream.
+			tags, err = readFLAC(f)
+		}
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		tags, err = readFLAC(f)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		tags, err = readOgg(f)
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		tags, err = readMP4(f)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read tags of %s: %v", path, err)
+	}
+	return tags, nil
+}
+
+func addTag(tags map[string][]string, key string, values ...string) {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			tags[key] = append(tags[key], v)
+		}
+	}
+}
+
+// ID3v2
+
+// id3Frames maps ID3v2.3/2.4 and ID3v2.2 frame IDs to tag names.
+var id3Frames = map[string]string{
+	"TIT2": TagTitle, "TT2": TagTitle,
+	"TPE1": TagArtist, "TP1": TagArtist,
+	"TALB": TagAlbum, "TAL": TagAlbum,
+	"TCON": TagGenre, "TCO": TagGenre,
+	"TCOM": TagComposer, "TCM": TagComposer,
+	"TDRC": TagDate, "TYER": TagDate, "TYE": TagDate,
+}
+
+func readID3(r io.ReadSeeker) (map[string][]string, error) {
+	var h [10]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return nil, err
+	}
+	version, flags := h[3], h[5]
+	size := syncsafe(h[6:10])
+	if version < 2 || version > 4 || size > maxTagBytes {
+		return nil, errTagSize
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	if flags&0x80 != 0 && version < 4 {
+		body = unsync(body)
+	}
+	if flags&0x40 != 0 && version > 2 && len(body) >= 4 {
+		skip := int(binary.BigEndian.Uint32(body[:4])) + 4
+		if version == 4 {
+			skip = syncsafe(body[:4])
+		}
+		if skip > len(body) {
+			return nil, errTagSize
+		}
+		body = body[skip:]
+	}
+
+	tags := map[string][]string{}
+	idLen, headLen := 4, 10
+	if version == 2 {
+		idLen, headLen = 3, 6
+	}
+	for len(body) >= headLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var n int
+		var formatFlags byte
+		switch version {
+		case 2:
+			n = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(body[4:8]))
+			formatFlags = body[9]
+		case 4:
+			n = syncsafe(body[4:8])
+			formatFlags = body[9]
+		}
+		if n < 0 || headLen+n > len(body) {
+			return nil, errTagSize
+		}
+		data := body[headLen : headLen+n]
+		body = body[headLen+n:]
+
+		if version == 3 && formatFlags&0xc0 != 0 {
+			continue // compressed or encrypted
+		}
+		if version == 4 {
+			if formatFlags&0x0c != 0 {
+				continue // compressed or encrypted
+			}
+			if formatFlags&0x01 != 0 && len(data) >= 4 {
+				data = data[4:]
+			}
+			if formatFlags&0x02 != 0 {
+				data = unsync(data)
+			}
+		}
+		if len(data) == 0 {
+			continue
+		}
+		switch {
+		case id == "COMM" || id == "COM":
+			if len(data) < 4 {
+				continue
+			}
+			parts := id3Strings(data[0], data[4:])
+			if len(parts) >= 2 {
+				addTag(tags, TagComment, strings.Join(parts[1:], " "))
+			}
+		case id == "TXXX" || id == "TXX":
+			parts := id3Strings(data[0], data[1:])
+			if len(parts) >= 2 {
+				addTag(tags, strings.ToLower(parts[0]), parts[1:]...)
+			}
+		case id3Frames[id] != "":
+			values := id3Strings(data[0], data[1:])
+			if id3Frames[id] == TagGenre {
+				values = id3Genres(values)
+			}
+			addTag(tags, id3Frames[id], values...)
+		}
+	}
+	return tags, nil
+}
+
+// syncsafe decodes a 28-bit ID3v2 integer stored 7 bits per byte.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// unsync undoes ID3v2 unsynchronisation, which inserts a zero byte after
+// every 0xFF.
+func unsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// id3Strings decodes the NUL-separated strings of a text frame.
+func id3Strings(encoding byte, b []byte) []string {
+	var parts []string
+	switch encoding {
+	case 1, 2: // UTF-16 with BOM, UTF-16BE
+		bigEndian := encoding == 2
+		var units []uint16
+		flush := func() {
+			parts = append(parts, string(utf16.Decode(units)))
+			units = nil
+		}
+		for i := 0; i+1 < len(b); i += 2 {
+			switch {
+			case b[i] == 0xff && b[i+1] == 0xfe:
+				bigEndian = false
+				continue
+			case b[i] == 0xfe && b[i+1] == 0xff:
+				bigEndian = true
+				continue
+			}
+			u := binary.LittleEndian.Uint16(b[i:])
+			if bigEndian {
+				u = binary.BigEndian.Uint16(b[i:])
+			}
+			if u == 0 {
+				flush()
+				continue
+			}
+			units = append(units, u)
+		}
+		if len(units) > 0 {
+			flush()
+		}
+	case 0: // ISO-8859-1
+		for _, s := range bytes.Split(b, []byte{0}) {
+			r := make([]rune, len(s))
+			for i, c := range s {
+				r[i] = rune(c)
+			}
+			parts = append(parts, string(r))
+		}
+	default: // UTF-8
+		for _, s := range bytes.Split(b, []byte{0}) {
+			parts = append(parts, string(s))
+		}
+	}
+	for len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts
+}
+
+var genreRef = regexp.MustCompile(`^(?:\(\d+\))+`)
+
+// id3Genres drops ID3v1 genre numbers such as "(8)", keeping any text after them.
+func id3Genres(values []string) []string {
+	var out []string
+	for _, v := range values {
+		v = genreRef.ReplaceAllString(v, "")
+		if v == "" || strings.Trim(v, "0123456789") == "" {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
+
+// FLAC and Vorbis comments
+
+func readFLAC(r io.ReadSeeker) (map[string][]string, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != "fLaC" {
+		return nil, nil
+	}
+	for {
+		var h [4]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, err
+		}
+		last, kind := h[0]&0x80 != 0, h[0]&0x7f
+		n := int64(h[1])<<16 | int64(h[2])<<8 | int64(h[3])
+		if kind == 4 {
+			b := make([]byte, n)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			return vorbisComments(b)
+		}
+		if last {
+			return map[string][]string{}, nil
+		}
+		if _, err := r.Seek(n, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readOgg reads the comment header, the second packet of the first stream,
+// of an Ogg Vorbis or Opus file.
+func readOgg(r io.Reader) (map[string][]string, error) {
+	var packets [][]byte
+	var current []byte
+	var serial uint32
+	total := 0
+	for page := 0; len(packets) < 2; page++ {
+		var h [27]byte
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return nil, err
+		}
+		if string(h[:4]) != "OggS" {
+			return nil, errors.New("invalid Ogg page")
+		}
+		pageSerial := binary.LittleEndian.Uint32(h[14:18])
+		if page == 0 {
+			serial = pageSerial
+		}
+		segments := make([]byte, h[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return nil, err
+		}
+		for _, s := range segments {
+			total += int(s)
+		}
+		if total > maxTagBytes {
+			return nil, errTagSize
+		}
+		for _, s := range segments {
+			b := make([]byte, s)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			if pageSerial != serial {
+				continue
+			}
+			current = append(current, b...)
+			if s < 255 {
+				packets = append(packets, current)
+				current = nil
+			}
+		}
+	}
+	comment := packets[1]
+	switch {
+	case bytes.HasPrefix(comment, []byte("OpusTags")):
+		return vorbisComments(comment[8:])
+	case bytes.HasPrefix(comment, []byte("\x03vorbis")):
+		return vorbisComments(comment[7:])
+	}
+	return map[string][]string{}, nil
+}
+
+// vorbisKeys maps Vorbis comment field names to tag names.
+var vorbisKeys = map[string]string{
+	"TITLE": TagTitle, "ARTIST": TagArtist, "ALBUM": TagAlbum, "GENRE": TagGenre,
+	"COMMENT": TagComment, "DESCRIPTION": TagComment, "COMPOSER": TagComposer, "DATE": TagDate,
+}
+
+func vorbisComments(b []byte) (map[string][]string, error) {
+	read := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+	if _, ok := read(); !ok { // vendor string
+		return nil, errTagSize
+	}
+	if len(b) < 4 {
+		return nil, errTagSize
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	tags := map[string][]string{}
+	for i := uint32(0); i < count; i++ {
+		field, ok := read()
+		if !ok {
+			return nil, errTagSize
+		}
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		key = strings.ToUpper(key)
+		if name, ok := vorbisKeys[key]; ok {
+			key = name
+		} else {
+			key = strings.ToLower(key)
+		}
+		addTag(tags, key, value)
+	}
+	return tags, nil
+}
+
+// MP4
+
+// mp4Items maps iTunes-style ilst item names to tag names.
+var mp4Items = map[string]string{
+	"\xa9nam": TagTitle, "\xa9ART": TagArtist, "\xa9alb": TagAlbum, "\xa9gen": TagGenre,
+	"\xa9cmt": TagComment, "\xa9wrt": TagComposer, "\xa9day": TagDate,
+}
+
+func readMP4(r io.ReadSeeker) (map[string][]string, error) {
+	// find moov among the top-level atoms; mdat may come first
+	for {
+		kind, size, err := mp4Header(r)
+		if err == io.EOF {
+			return map[string][]string{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if kind == "moov" {
+			if size > maxTagBytes {
+				return nil, errTagSize
+			}
+			var moov []byte
+			if size < 0 {
+				// moov runs to the end of the file
+				if moov, err = io.ReadAll(io.LimitReader(r, maxTagBytes+1)); err != nil {
+					return nil, err
+				}
+				if len(moov) > maxTagBytes {
+					return nil, errTagSize
+				}
+			} else {
+				moov = make([]byte, size)
+				if _, err := io.ReadFull(r, moov); err != nil {
+					return nil, err
+				}
+			}
+			tags := map[string][]string{}
+			ilst := mp4Path(moov, "udta", "meta", "ilst")
+			if ilst != nil {
+				mp4Ilst(ilst, tags)
+			}
+			return tags, nil
+		}
+		if size < 0 {
+			return map[string][]string{}, nil
+		}
+		if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// mp4Header reads an atom header and returns the atom type and the size of
+// its body, or -1 when the atom runs to the end of the file.
+func mp4Header(r io.Reader) (string, int64, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return "", 0, err
+	}
+	size := int64(binary.BigEndian.Uint32(h[:4]))
+	kind := string(h[4:])
+	switch size {
+	case 0:
+		return kind, -1, nil
+	case 1:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return "", 0, err
+		}
+		ext64 := binary.BigEndian.Uint64(ext[:])
+		if ext64 < 16 || ext64 > 1<<62 {
+			return "", 0, errTagSize
+		}
+		return kind, int64(ext64) - 16, nil
+	}
+	if size < 8 {
+		return "", 0, errTagSize
+	}
+	return kind, size - 8, nil
+}
+
+// mp4Children splits an atom body into its child atoms.
+func mp4Children(b []byte, visit func(kind string, body []byte)) {
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b[:4]))
+		if size < 8 || size > len(b) {
+			return
+		}
+		visit(string(b[4:8]), b[8:size])
+		b = b[size:]
+	}
+}
+
+// mp4Path follows a path of nested atoms.
+func mp4Path(b []byte, path ...string) []byte {
+	for _, name := range path {
+		var found []byte
+		mp4Children(b, func(kind string, body []byte) {
+			if kind == name && found == nil {
+				found = body
+			}
+		})
+		if found == nil {
+			return nil
+		}
+		if name == "meta" && len(found) >= 8 && string(found[4:8]) != "hdlr" {
+			found = found[4:] // version and flags of the ISO full atom
+		}
+		b = found
+	}
+	return b
+}
+
+func mp4Ilst(ilst []byte, tags map[string][]string) {
+	mp4Children(ilst, func(item string, body []byte) {
+		var name string
+		var values []string
+		mp4Children(body, func(kind string, b []byte) {
+			switch kind {
+			case "name": // freeform "----" items
+				if len(b) >= 4 {
+					name = strings.ToLower(string(b[4:]))
+				}
+			case "data":
+				// type (UTF-8 is 1) and locale precede the value
+				if len(b) >= 8 && binary.BigEndian.Uint32(b[:4]) == 1 {
+					values = append(values, string(b[8:]))
+				}
+			}
+		})
+		if item != "----" {
+			name = mp4Items[item]
+		}
+		if name != "" {
+			addTag(tags, name, values...)
+		}
+	})
+}
+
+// From tags to metadata
+
+// genericGenres are genre tags that say nothing about the raga.
+var genericGenres = map[string]bool{
+	"carnatic": true, "carnatic music": true, "classical": true, "indian classical": true,
+	"world": true, "other": true, "music": true, "vocal": true, "instrumental": true,
+	"devotional": true, "speech": true, "podcast": true, "voice memo": true, "recording": true,
+}
+
+var albumDate = regexp.MustCompile(`\s+\d{4}-\d{2}-\d{2}$`)
+
+// FromTags turns embedded tags into metadata, reading them the way
+// ConvertWithTags writes them: title from the songs, artist from the
+// teacher, album from the group and date, genre from the ragas, and talas
+// and composers from the comment. Explicit raga, tala and composer tags win.
+func FromTags(tags map[string][]string) Metadata {
+	var m Metadata
+	first := func(key string) string {
+		if v := tags[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	m.Teacher = first(TagArtist)
+	m.GroupName = albumDate.ReplaceAllString(first(TagAlbum), "")
+	m.SongsTaught = splitTagValues(tags[TagTitle], " / ")
+	if t, err := ParseDate(first(TagDate)); err == nil {
+		m.RecordedAt = t
+	}
+
+	for _, g := range splitTagValues(tags[TagGenre], ", ", ";") {
+		if !genericGenres[strings.ToLower(g)] {
+			m.Ragas = append(m.Ragas, g)
+		}
+	}
+	for _, c := range tags[TagComment] {
+		for _, part := range strings.Split(c, ";") {
+			label, value, ok := strings.Cut(part, ":")
+			if !ok {
+				continue
+			}
+			values := splitTagValues([]string{value}, ", ")
+			switch strings.ToLower(strings.TrimSpace(label)) {
+			case "tala", "talas", "talam":
+				m.Talas = values
+			case "composer", "composers":
+				m.Composers = values
+			case "raga", "ragas", "ragam":
+				m.Ragas = values
+			}
+		}
+	}
+	if v := splitTagValues(tags[TagComposer], ", ", ";", " / "); len(v) > 0 {
+		m.Composers = v
+	}
+	if v := splitTagValues(append(tags[TagRaga], tags["ragam"]...), ", ", ";"); len(v) > 0 {
+		m.Ragas = v
+	}
+	if v := splitTagValues(append(tags[TagTala], tags["talam"]...), ", ", ";"); len(v) > 0 {
+		m.Talas = v
+	}
+	return m
+}
+
+func splitTagValues(values []string, seps ...string) []string {
+	var out []string
+	for _, v := range values {
+		parts := []string{v}
+		for _, sep := range seps {
+			var next []string
+			for _, p := range parts {
+				next = append(next, strings.Split(p, sep)...)
+			}
+			parts = next
+		}
+		for _, p := range parts {
+			if p = strings.TrimSpace(p); p != "" {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// Embedded reads the metadata stored in a media file's own tags.
+func Embedded(mediaPath string) (Metadata, error) {
+	tags, err := ReadTags(mediaPath)
+	if err != nil || len(tags) == 0 {
+		return Metadata{}, err
+	}
+	return FromTags(tags), nil
+}
@@ -0,0 +1,317 @@
+package organizer
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"musicloud/config"
+	"musicloud/internal/metadata"
+	"musicloud/internal/translit"
+)
+
+// DefaultLayout is the folder layout of FolderName and of an Organizer
+// without Layouts: one folder per class, named after its day and group.
+// Uploads with no layout configured stay in the upload or route folder.
+const DefaultLayout = "{{.Date}} - {{.Group}}"
+
+// Fields are what a layout template can use: every metadata field, such as
+// .Teacher, .SessionType or .Ragas, and shorter names and date parts.
+type Fields struct {
+	metadata.Metadata
+	Group     string   // GroupName
+	Songs     []string // SongsTaught
+	Date      string   // 2024-03-05
+	Year      string   // 2024
+	Month     string   // 03
+	MonthName string   // March
+	Day       string   // 05
+	Weekday   string   // Tuesday
+	Time      string   // 18.30
+	Term      string   // the term the date falls in, if terms are configured
+	Ext       string   // extension of the file, such as mp4; names only
+	Original  string   // file name before renaming, without extension; names only
+}
+
+// Layout is a text/template that gives the folder path of a recording
+// under the upload folder, with "/" between folders, such as
+// "{{.Year}}/{{.Group}}/{{.Date}} {{.SessionType}}", or its file name, such
+// as "{{.Date}}_{{.Group}}_{{first .Ragas}}.{{.Ext}}".
+type Layout struct {
+	text string
+	tmpl *template.Template
+	term func(time.Time) string
+}
+
+// ParseLayout parses a layout. term names the term or semester a date falls
+// in and may be nil. Besides the Fields, a layout can call:
+//
+//	slug s         "Lakshmi Raman" → "lakshmi-raman", in Latin letters
+//	first list     the first entry of a list, e.g. first .Ragas
+//	join list sep  the entries of a list joined by sep
+//	term date      the term of a date, e.g. term .RecordedAt
+//	date fmt t     a time in Go layout fmt, e.g. date "Jan 2006" .RecordedAt
+//	lower, upper   change case
+//	default d s    s, or d if s is empty
+func ParseLayout(text string, term func(time.Time) string) (*Layout, error) {
+	l := &Layout{text: text, term: func(t time.Time) string {
+		if term == nil || t.IsZero() {
+			return ""
+		}
+		return term(t)
+	}}
+	funcs := template.FuncMap{
+		"slug":  Slug,
+		"first": first,
+		"join":  strings.Join,
+		"term":  l.term,
+		"date": func(layout string, t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.Format(layout)
+		},
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"default": func(d, s string) string {
+			if s == "" {
+				return d
+			}
+			return s
+		},
+	}
+	tmpl, err := template.New("layout").Option("missingkey=error").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid layout %q: %v", text, err)
+	}
+	l.tmpl = tmpl
+	// Unknown fields only show when the template runs, so try it now.
+	sample := metadata.Metadata{GroupName: "Group", RecordedAt: time.Now(), Ragas: []string{"Raga"}}
+	if _, err := l.Folder(sample); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Layout) String() string {
+	return l.text
+}
+
+// Folder returns the folder path of a recording with metadata m. Every
+// folder name is sanitized; folders that come out empty are left out.
+func (l *Layout) Folder(m metadata.Metadata) (string, error) {
+	var b strings.Builder
+	if err := l.tmpl.Execute(&b, l.fields(m, "")); err != nil {
+		return "", fmt.Errorf("layout %q: %v", l.text, err)
+	}
+	var folders []string
+	for _, name := range strings.Split(b.String(), "/") {
+		if name = Sanitize(name); name != "" {
+			folders = append(folders, name)
+		}
+	}
+	return path.Join(folders...), nil
+}
+
+// Name returns the file name of a recording with metadata m that is named
+// original now, such as "AUD-20240105-WA0003.opus.mp4". The name is
+// sanitized like folder names, separators left doubled by empty values are
+// merged, and it keeps the original's extension whether or not the template
+// gives it. A template that comes out empty keeps the original name.
+func (l *Layout) Name(m metadata.Metadata, original string) (string, error) {
+	var b strings.Builder
+	if err := l.tmpl.Execute(&b, l.fields(m, original)); err != nil {
+		return "", fmt.Errorf("name template %q: %v", l.text, err)
+	}
+	ext := path.Ext(original)
+	name := b.String()
+	if ext != "" && strings.HasSuffix(strings.ToLower(name), strings.ToLower(ext)) {
+		name = name[:len(name)-len(ext)]
+	}
+	name = strings.Trim(doubledSeparators.ReplaceAllString(name, "$1"), "_- ")
+	if name = Sanitize(name); name == "" {
+		name = Sanitize(strings.TrimSuffix(original, ext))
+	}
+	return name + ext, nil
+}
+
+// doubledSeparators are what an empty value between two separators leaves,
+// such as the "__" of "{{.Date}}_{{.Teacher}}_{{.Group}}" without a teacher.
+var doubledSeparators = regexp.MustCompile(`([_-])[_-]+`)
+
+// fields fills in the template data, for a file named original or, for
+// folders, "". Slashes in values are replaced, so a value cannot add
+// folders of its own.
+func (l *Layout) fields(m metadata.Metadata, original string) Fields {
+	noSlash := strings.NewReplacer("/", "-", "\\", "-")
+	for _, s := range []*string{&m.GroupName, &m.Teacher, &m.SessionType, &m.Source} {
+		*s = noSlash.Replace(*s)
+	}
+	for _, list := range []*[]string{&m.SongsTaught, &m.Ragas, &m.Talas, &m.Composers, &m.Performers, &m.Instruments, &m.Tags} {
+		replaced := make([]string, len(*list))
+		for i, v := range *list {
+			replaced[i] = noSlash.Replace(v)
+		}
+		*list = replaced
+	}
+	f := Fields{Metadata: m, Group: m.GroupName, Songs: m.SongsTaught}
+	if original != "" {
+		f.Ext = strings.TrimPrefix(path.Ext(original), ".")
+		f.Original = noSlash.Replace(strings.TrimSuffix(original, path.Ext(original)))
+	}
+	if t := m.RecordedAt; !t.IsZero() {
+		f.Date, f.Year, f.Month, f.MonthName = t.Format("2006-01-02"), t.Format("2006"), t.Format("01"), t.Format("January")
+		f.Day, f.Weekday, f.Time = t.Format("02"), t.Format("Monday"), t.Format("15.04")
+		f.Term = l.term(t)
+	}
+	return f
+}
+
+func first(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
+
+// Slug turns s into lower-case Latin letters, digits and hyphens, writing
+// names in Indian scripts in Latin letters first.
+func Slug(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(translit.ASCII(s)) {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// maxNameBytes keeps names within what local file systems accept once a
+// file is synced, with room for an extension and a collision suffix.
+const maxNameBytes = 200
+
+// reservedNames are file names Windows refuses whatever their extension.
+var reservedNames = map[string]bool{"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true}
+
+// Sanitize makes s usable as a file or folder name in Drive and on the
+// file systems Drive syncs to: characters Windows or macOS reject and
+// control characters become "-", runs of spaces become one, leading and
+// trailing spaces and dots are dropped, Windows device names get a "_" and
+// long names are cut at a character boundary.
+func Sanitize(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case strings.ContainsRune(`/\:*?"<>|`, r) || unicode.IsControl(r):
+			r = '-'
+		case r == utf8.RuneError:
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	name := strings.Trim(b.String(), " .")
+	if len(name) > maxNameBytes {
+		n := maxNameBytes
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = strings.TrimRight(name[:n], " .")
+	}
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+	return name
+}
+
+// Layouts picks the folder layout and name template of each recording by
+// its group.
+type Layouts struct {
+	groups   *config.Groups
+	fallback string             // folder layout
+	name     string             // name template
+	layouts  map[string]*Layout // by template text
+}
+
+// NewLayouts parses the folder layouts and name templates of the group
+// configuration, which may be nil. fallback and name are the layout and
+// name template of groups the configuration gives none, and may be "".
+// Terms come from the configuration.
+func NewLayouts(groups *config.Groups, fallback, name string) (*Layouts, error) {
+	l := &Layouts{groups: groups, fallback: fallback, name: name, layouts: map[string]*Layout{}}
+	texts := []string{fallback, name}
+	if groups != nil {
+		texts = append(texts, groups.Layout, groups.FileName)
+		for _, g := range groups.Groups {
+			texts = append(texts, g.Layout, g.FileName)
+		}
+	}
+	for _, text := range texts {
+		if text == "" || l.layouts[text] != nil {
+			continue
+		}
+		layout, err := ParseLayout(text, groups.Term)
+		if err != nil {
+			return nil, err
+		}
+		l.layouts[text] = layout
+	}
+	return l, nil
+}
+
+// Folder returns the folder of a recording under its group's layout, or
+// "" when no layout applies to the group.
+func (l *Layouts) Folder(m metadata.Metadata) (string, error) {
+	if l == nil {
+		return "", nil
+	}
+	text := l.groups.LayoutFor(m.GroupName)
+	if text == "" {
+		text = l.fallback
+	}
+	if text == "" {
+		return "", nil
+	}
+	return l.layouts[text].Folder(m)
+}
+
+// Name returns the file name of a recording named original under its
+// group's name template, or "" when no template applies to the group.
+func (l *Layouts) Name(m metadata.Metadata, original string) (string, error) {
+	if l == nil {
+		return "", nil
+	}
+	text := l.groups.FileNameFor(m.GroupName)
+	if text == "" {
+		text = l.name
+	}
+	if text == "" {
+		return "", nil
+	}
+	return l.layouts[text].Name(m, original)
+}
@@ -0,0 +1,230 @@
+package organizer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/metadata"
+)
+
+// ManifestName and IndexName are the files musicloud keeps in every session
+// folder: the machine-readable manifest and an index for people.
+const (
+	ManifestName = "session.json"
+	IndexName    = "index.md"
+)
+
+// Manifest lists the recordings of one session folder.
+type Manifest struct {
+	Version    int               `json:"version"` // metadata schema version of the recordings
+	Folder     string            `json:"folder"`
+	FolderID   string            `json:"folder_id"`
+	Updated    time.Time         `json:"updated"`
+	Duration   metadata.Duration `json:"duration,omitempty"` // of all recordings together
+	Recordings []Entry           `json:"recordings"`
+}
+
+// Entry is a recording in a manifest.
+type Entry struct {
+	FileID   string            `json:"file_id"`
+	Name     string            `json:"name"`
+	Original string            `json:"original_name,omitempty"` // name before the name template renamed it
+	Link     string            `json:"link"`
+	Size     int64             `json:"size,omitempty"`
+	MD5      string            `json:"md5,omitempty"`    // of the content in Drive
+	SHA256   string            `json:"sha256,omitempty"` // of the local file it came from
+	Metadata metadata.Metadata `json:"metadata"`
+}
+
+// Record adds a recording to the manifest and index of the folder it is in,
+// replacing an earlier entry of the same file. Only FileID, SHA256 and
+// Metadata need to be set; the rest is read from Drive. Entries of files
+// that have since left the folder are dropped.
+func (o *Organizer) Record(folderID string, e Entry) error {
+	return o.writeManifest(folderID, &e)
+}
+
+// Refresh drops the entries of files that have left a folder from its
+// manifest, if it has one.
+func (o *Organizer) Refresh(folderID string) error {
+	return o.writeManifest(folderID, nil)
+}
+
+func (o *Organizer) writeManifest(folderID string, add *Entry) error {
+	existing, err := o.Remote.Find(folderID, ManifestName, "")
+	if err != nil {
+		return fmt.Errorf("error searching for %s: %v", ManifestName, err)
+	}
+	if len(existing) == 0 && add == nil {
+		return nil
+	}
+	folder, err := o.Remote.Get(folderID)
+	if err != nil {
+		return fmt.Errorf("unable to find folder %s: %v", folderID, err)
+	}
+	m := &Manifest{}
+	if len(existing) > 0 {
+		if m, err = o.readManifest(existing[0].Id); err != nil {
+			return err
+		}
+	}
+	var entries []Entry
+	for _, e := range m.Recordings {
+		if add != nil && e.FileID == add.FileID {
+			continue
+		}
+		if f, err := o.Remote.Get(e.FileID); err != nil || f.Trashed || !contains(f.Parents, folderID) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if add != nil {
+		f, err := o.Remote.Get(add.FileID)
+		if err != nil {
+			return fmt.Errorf("unable to find file %s: %v", add.FileID, err)
+		}
+		add.Name, add.Size, add.MD5, add.Link = f.Name, f.Size, f.Md5Checksum, fileLink(f.Id)
+		add.Original = f.AppProperties[drive.OriginalName]
+		entries = append(entries, *add)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Metadata.RecordedAt, entries[j].Metadata.RecordedAt
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	// Filing a recording again changes nothing, so nothing is written.
+	before, _ := json.Marshal(m.Recordings)
+	after, _ := json.Marshal(entries)
+	if len(existing) > 0 && bytes.Equal(before, after) {
+		return nil
+	}
+	m.Version, m.Folder, m.FolderID, m.Updated = metadata.SchemaVersion, folder.Name, folderID, time.Now()
+	m.Recordings, m.Duration = entries, 0
+	for _, e := range entries {
+		m.Duration += e.Metadata.Duration
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := o.put(folderID, ManifestName, "application/json", b); err != nil {
+		return err
+	}
+	return o.put(folderID, IndexName, "text/markdown", []byte(Index(m)))
+}
+
+// readManifest downloads a manifest, upgrading metadata written under an
+// earlier schema version.
+func (o *Organizer) readManifest(id string) (*Manifest, error) {
+	r, err := o.Remote.Download(id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %v", ManifestName, err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download %s: %v", ManifestName, err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", ManifestName, err)
+	}
+	for i := range m.Recordings {
+		if m.Recordings[i].Metadata, err = metadata.Migrate(m.Recordings[i].Metadata, m.Version); err != nil {
+			return nil, fmt.Errorf("unable to read %s: %v", ManifestName, err)
+		}
+	}
+	return m, nil
+}
+
+// put writes a file into a folder, as a new revision if it exists.
+func (o *Organizer) put(folderID, name, mimeType string, content []byte) error {
+	found, err := o.Remote.Find(folderID, name, "")
+	if err != nil {
+		return fmt.Errorf("error searching for %s: %v", name, err)
+	}
+	if len(found) > 0 {
+		_, err = o.Remote.Update(found[0].Id, &gdrive.File{}, "", "", nil, bytes.NewReader(content))
+	} else {
+		_, err = o.Remote.Create(&gdrive.File{Name: name, MimeType: mimeType, Parents: []string{folderID}}, bytes.NewReader(content))
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// Index renders a manifest as Markdown: what the session covered, then a
+// table of its recordings with links to them.
+func Index(m *Manifest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", m.Folder)
+	var songs, ragas, talas []string
+	for _, e := range m.Recordings {
+		songs = appendNew(songs, e.Metadata.SongsTaught...)
+		ragas = appendNew(ragas, e.Metadata.Ragas...)
+		talas = appendNew(talas, e.Metadata.Talas...)
+	}
+	for _, line := range []struct {
+		label  string
+		values []string
+	}{{"Songs", songs}, {"Ragas", ragas}, {"Talas", talas}} {
+		if len(line.values) > 0 {
+			fmt.Fprintf(&b, "- **%s:** %s\n", line.label, strings.Join(line.values, ", "))
+		}
+	}
+	if m.Duration > 0 {
+		fmt.Fprintf(&b, "- **Total length:** %s\n", m.Duration)
+	}
+	b.WriteString("\n| Recording | Recorded | Length | Songs | Ragas | Talas |\n|---|---|---|---|---|---|\n")
+	for _, e := range m.Recordings {
+		recorded, length := "", ""
+		if t := e.Metadata.RecordedAt; !t.IsZero() {
+			recorded = t.Format("2006-01-02 15:04")
+		}
+		if e.Metadata.Duration > 0 {
+			length = e.Metadata.Duration.String()
+		}
+		fmt.Fprintf(&b, "| [%s](%s) | %s | %s | %s | %s | %s |\n", escapeCell(e.Name), e.Link, recorded, length,
+			escapeCell(strings.Join(e.Metadata.SongsTaught, ", ")), escapeCell(strings.Join(e.Metadata.Ragas, ", ")), escapeCell(strings.Join(e.Metadata.Talas, ", ")))
+	}
+	return b.String()
+}
+
+func fileLink(id string) string {
+	return "https://drive.google.com/file/d/" + id + "/view"
+}
+
+// appendNew appends the values not yet in list.
+func appendNew(list []string, values ...string) []string {
+	for _, v := range values {
+		if v != "" && !contains(list, v) {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func escapeCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ", "[", `\[`, "]", `\]`).Replace(s)
+}
@@ -1,89 +1,228 @@
+// Package organizer files uploaded recordings into folders in Drive, named
+// by a layout from their metadata.
 package organizer
 
 import (
 	"fmt"
-	"time"
+	"path"
+	"strings"
 
-	"google.golang.org/api/drive/v3"
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/metadata"
 )
 
-type Metadata struct {
-	GroupName   string
-	Teacher     string
-	SessionType string
-	SongsTaught []string
-	Ragas       []string
-	Talas       []string
-	Composers   []string
+// Organizer finds or creates folders below the upload folder and moves
+// recordings into them. Each operation either completes or leaves Drive as
+// it found it: folders it created for an operation that then fails are
+// removed again.
+type Organizer struct {
+	Remote drive.Remote
+	// Root is the ID of the upload folder every path is relative to.
+	Root string
+	// Layouts gives the folder of a recording; nil means DefaultLayout.
+	Layouts *Layouts
+	// Views lists the browse views kept up to date, by name; see Views.
+	// With nil, shortcuts are left alone.
+	Views []string
+
+	folders map[string]string // folder IDs by path, as found or created
 }
 
-func OrganizeFiles(service *drive.Service, fileID string, metadata Metadata) error {
-	if service == nil {
-		return fmt.Errorf("service is nil")
-	}
-
-	// Create a folder in Google Drive based on the recording date
-	recordingDate := time.Now().Format("2006-01-02")
-	folderName := fmt.Sprintf("%s - %s", recordingDate, metadata.GroupName)
+// New returns an Organizer working below the folder root.
+func New(remote drive.Remote, root string, layouts *Layouts) *Organizer {
+	return &Organizer{Remote: remote, Root: root, Layouts: layouts}
+}
 
-	folderID, err := createFolder(service, folderName)
-	if err != nil {
-		return err
+// Organize moves the uploaded file fileID into the folder the layout gives
+// its metadata, below route, renames it by the name template, records it in
+// the folder's manifest, adds it to the browse views and returns the
+// folder's ID.
+func (o *Organizer) Organize(fileID, route string, meta metadata.Metadata) (string, error) {
+	if o == nil || o.Remote == nil {
+		return "", fmt.Errorf("no Drive to organize in")
+	}
+	var folder string
+	var err error
+	if o.Layouts != nil {
+		folder, err = o.Layouts.Folder(meta)
+	} else {
+		folder, err = FolderName(meta)
 	}
-
-	// Move the uploaded file to the newly created folder
-	err = moveFileToFolder(service, fileID, folderID)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	// Optionally, save metadata to a file or database
-	err = saveMetadata(metadata, folderID)
+	folderID, from, undo, err := o.move(fileID, path.Join(route, folder), &meta)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	return nil
+	if err := o.Record(folderID, Entry{FileID: fileID, Metadata: meta}); err != nil {
+		undo()
+		return "", err
+	}
+	// The folders the file left lose its entry. This is best effort: the
+	// next recording filed there drops it too.
+	for _, id := range from {
+		o.Refresh(id)
+	}
+	// The browse views come last. If they fail the recording stays filed:
+	// the error is reported and the next Browse repairs them.
+	if o.Views != nil {
+		if err := o.Browse(fileID, meta); err != nil {
+			return folderID, err
+		}
+	}
+	return folderID, nil
 }
 
-func createFolder(service *drive.Service, folderName string) (string, error) {
-	if service == nil {
-		return "", fmt.Errorf("service is nil")
+// FolderName names the session folder of a recording after the day it was
+// recorded, not the day it is uploaded, and its group.
+func FolderName(metadata metadata.Metadata) (string, error) {
+	if metadata.RecordedAt.IsZero() {
+		return "", fmt.Errorf("recording date unknown")
 	}
+	return defaultLayout.Folder(metadata)
+}
 
-	folder := &drive.File{
-		Name:     folderName,
-		MimeType: "application/vnd.google-apps.folder",
-	}
+var defaultLayout, _ = ParseLayout(DefaultLayout, nil)
 
-	createdFolder, err := service.Files.Create(folder).Do()
-	if err != nil {
-		return "", err
+// Folder finds or creates the folder at a slash-separated path below Root
+// and returns its ID and the IDs of the folders it created, outermost
+// first. When Drive holds several folders of the same name, the oldest is
+// used, so repeated runs always pick the same one.
+func (o *Organizer) Folder(folder string) (string, []string, error) {
+	if o.folders == nil {
+		o.folders = map[string]string{}
+	}
+	id, current := o.Root, ""
+	var created []string
+	for _, name := range strings.Split(folder, "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name)
+		if known, ok := o.folders[current]; ok {
+			id = known
+			continue
+		}
+		found, err := o.Remote.Find(id, name, drive.FolderMimeType)
+		if err != nil {
+			o.discard(created)
+			return "", nil, fmt.Errorf("error searching for folder %q: %v", current, err)
+		}
+		if len(found) > 0 {
+			id = found[0].Id
+		} else {
+			f, err := o.Remote.Create(&gdrive.File{Name: name, MimeType: drive.FolderMimeType, Parents: []string{id}}, nil)
+			if err != nil {
+				o.discard(created)
+				return "", nil, fmt.Errorf("error creating folder %q: %v", current, err)
+			}
+			id = f.Id
+			created = append(created, id)
+		}
+		o.folders[current] = id
 	}
+	return id, created, nil
+}
 
-	return createdFolder.Id, nil
+// Move moves a file into the folder at path below Root, creating the
+// folders it lacks, and out of every other folder it is in, and returns the
+// folder's ID. The move is a single request that adds the new parent and
+// removes the old ones, so the file is never in both or in neither.
+func (o *Organizer) Move(fileID, folder string) (string, error) {
+	id, _, _, err := o.move(fileID, folder, nil)
+	return id, err
 }
 
-func moveFileToFolder(service *drive.Service, fileID string, folderID string) error {
-	// Retrieve the existing file
-	file, err := service.Files.Get(fileID).Do()
+// move is Move, also returning the folders the file left and a function
+// that undoes it: the file goes back to its old folders and the folders
+// made for it are removed. With meta, the file is also renamed by the name
+// template in the same request, with a suffix if the name is taken in the
+// folder, and its name before the first renaming is kept in appProperties.
+func (o *Organizer) move(fileID, folder string, meta *metadata.Metadata) (string, []string, func(), error) {
+	f, err := o.Remote.Get(fileID)
 	if err != nil {
-		return err
+		return "", nil, nil, fmt.Errorf("unable to find file %s: %v", fileID, err)
 	}
-
-	// Update the file's parents to include the new folder
-	file.Parents = append(file.Parents, folderID)
-
-	_, err = service.Files.Update(fileID, file).Do()
+	target, created, err := o.Folder(folder)
 	if err != nil {
-		return err
+		return "", nil, nil, err
+	}
+	add := target
+	var remove []string
+	for _, p := range f.Parents {
+		if p == target {
+			add = ""
+		} else {
+			remove = append(remove, p)
+		}
+	}
+	update, restore := &gdrive.File{}, &gdrive.File{}
+	var unset []string
+	if meta != nil {
+		original := f.Name
+		if name := f.AppProperties[drive.OriginalName]; name != "" {
+			original = name
+		}
+		name, err := o.Layouts.Name(*meta, original)
+		if err == nil && name != "" {
+			name, err = drive.UniqueName(o.Remote, target, name, fileID)
+		}
+		if err != nil {
+			o.discard(created)
+			return "", nil, nil, err
+		}
+		if name != "" && name != f.Name {
+			update.Name, restore.Name = name, f.Name
+			if f.AppProperties[drive.OriginalName] == "" {
+				update.AppProperties = map[string]string{drive.OriginalName: f.Name}
+				unset = []string{drive.OriginalName}
+			}
+		}
+	}
+	undo := func() { o.discard(created) }
+	if add == "" && len(remove) == 0 && update.Name == "" {
+		return target, nil, undo, nil
+	}
+	if _, err := o.Remote.Update(fileID, update, add, strings.Join(remove, ","), nil, nil); err != nil {
+		o.discard(created)
+		return "", nil, nil, fmt.Errorf("unable to move %s to %q: %v", f.Name, folder, err)
 	}
+	return target, remove, func() {
+		o.Remote.Update(fileID, restore, strings.Join(remove, ","), add, unset, nil)
+		o.discard(created)
+	}, nil
+}
 
-	return nil
+// Within finds or creates the folder at path below Root and calls fill with
+// its ID, such as to upload a file into it. If fill fails, the folders made
+// for it are removed again.
+func (o *Organizer) Within(folder string, fill func(folderID string) error) (string, error) {
+	id, created, err := o.Folder(folder)
+	if err != nil {
+		return "", err
+	}
+	if err := fill(id); err != nil {
+		o.discard(created)
+		return "", err
+	}
+	return id, nil
 }
 
-func saveMetadata(metadata Metadata, folderID string) error {
-	// Implement saving metadata logic here (e.g., to a database or a file)
-	// This is a placeholder for the actual implementation
-	return nil
-}
\ No newline at end of file
+// discard moves folders made by a failed operation to the trash, innermost
+// first, and forgets them. It is best effort: the operation's own error is
+// what gets reported.
+func (o *Organizer) discard(created []string) {
+	for i := len(created) - 1; i >= 0; i-- {
+		o.Remote.Update(created[i], &gdrive.File{Trashed: true}, "", "", nil, nil)
+	}
+	for p, id := range o.folders {
+		for _, c := range created {
+			if id == c {
+				delete(o.folders, p)
+			}
+		}
+	}
+}
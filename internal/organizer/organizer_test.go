@@ -1,15 +1,469 @@
 package organizer
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
 	"testing"
-)
+	"time"
+	"unicode/utf8"
+
+	gdrive "google.golang.org/api/drive/v3"
 
-type dummyService struct{}
+	"musicloud/config"
+	"musicloud/internal/drive"
+	"musicloud/internal/metadata"
+)
 
-func TestOrganizeFiles_InvalidService(t *testing.T) {
-	meta := Metadata{GroupName: "G"}
-	err := OrganizeFiles(nil, "fileid", meta)
+func TestOrganize_InvalidService(t *testing.T) {
+	meta := metadata.Metadata{GroupName: "G"}
+	_, err := New(nil, "root", nil).Organize("fileid", "", meta)
 	if err == nil {
 		t.Error("expected error with nil service")
 	}
 }
+
+// failingRemote makes chosen calls of a MockRemote fail.
+type failingRemote struct {
+	*drive.MockRemote
+	failCreate string // name of the file whose creation fails
+	failMove   bool   // whether moving a file between folders fails
+}
+
+func (f *failingRemote) Create(file *gdrive.File, media io.Reader) (*gdrive.File, error) {
+	if file.Name == f.failCreate {
+		return nil, errors.New("quota exceeded")
+	}
+	return f.MockRemote.Create(file, media)
+}
+
+func (f *failingRemote) Update(id string, file *gdrive.File, add, remove string, clear []string, media io.Reader) (*gdrive.File, error) {
+	if f.failMove && add != "" {
+		return nil, errors.New("backend error")
+	}
+	return f.MockRemote.Update(id, file, add, remove, clear, media)
+}
+
+func TestOrganizer_FindOrCreateAndMove(t *testing.T) {
+	remote := drive.NewMockRemote()
+	upload, _ := remote.Create(&gdrive.File{Name: "Recordings", MimeType: drive.FolderMimeType, Parents: []string{"root"}}, nil)
+	day := time.Date(2024, 3, 5, 18, 30, 0, 0, time.Local)
+	var files []string
+	for _, name := range []string{"part1.m4a", "part2.m4a", "part3.m4a"} {
+		f, _ := remote.Create(&gdrive.File{Name: name, Parents: []string{upload.Id}}, strings.NewReader("audio"))
+		files = append(files, f.Id)
+	}
+
+	org := New(remote, upload.Id, nil)
+	meta := metadata.Metadata{GroupName: "Veena Class", RecordedAt: day}
+	var folderIDs []string
+	for _, id := range files {
+		folderID, err := org.Organize(id, "", meta)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		folderIDs = append(folderIDs, folderID)
+	}
+	// A new organizer finds the folder in Drive rather than in its cache.
+	again, err := New(remote, upload.Id, nil).Organize(files[0], "", meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	folders, _ := remote.Find(upload.Id, "2024-03-05 - Veena Class", drive.FolderMimeType)
+	if len(folders) != 1 || folderIDs[0] != folders[0].Id || folderIDs[2] != folderIDs[0] || again != folderIDs[0] {
+		t.Fatalf("expected one session folder for the class, got %v (used %v, %s)", folders, folderIDs, again)
+	}
+	var names []string
+	for _, f := range remote.Children(folders[0].Id) {
+		names = append(names, f.Name)
+	}
+	if want := []string{"part1.m4a", "part2.m4a", "part3.m4a", ManifestName, IndexName}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected the three parts and the manifest in the session folder, got %v", names)
+	}
+	for _, id := range files {
+		if f, _ := remote.Get(id); !reflect.DeepEqual(f.Parents, []string{folders[0].Id}) {
+			t.Errorf("expected %s to be moved out of the upload folder, parents %v", f.Name, f.Parents)
+		}
+	}
+	moves := remote.Calls["Update"]
+	if _, err := org.Organize(files[1], "", meta); err != nil || remote.Calls["Update"] != moves {
+		t.Errorf("expected organizing a filed recording again to change nothing, %v", err)
+	}
+
+	// Nested layouts below a route, with an existing duplicate folder: the
+	// oldest one is used.
+	layouts, _ := NewLayouts(nil, "{{.Year}}/{{.Date}}", "")
+	student, _ := remote.Create(&gdrive.File{Name: "Student Practice", MimeType: drive.FolderMimeType, Parents: []string{upload.Id}}, nil)
+	remote.Create(&gdrive.File{Name: "Student Practice", MimeType: drive.FolderMimeType, Parents: []string{upload.Id}}, nil)
+	org = New(remote, upload.Id, layouts)
+	folderID, err := org.Organize(files[2], "Student Practice", meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	year, _ := remote.Find(student.Id, "2024", drive.FolderMimeType)
+	if len(year) != 1 {
+		t.Fatalf("expected the year folder in the oldest Student Practice, got %v", year)
+	}
+	if date, _ := remote.Find(year[0].Id, "2024-03-05", drive.FolderMimeType); len(date) != 1 || date[0].Id != folderID {
+		t.Errorf("expected the file in Student Practice/2024/2024-03-05, got %v", date)
+	}
+}
+
+func TestOrganizer_RollsBack(t *testing.T) {
+	mock := drive.NewMockRemote()
+	remote := &failingRemote{MockRemote: mock, failMove: true}
+	f, _ := mock.Create(&gdrive.File{Name: "class.m4a", Parents: []string{"root"}}, strings.NewReader("audio"))
+	layouts, _ := NewLayouts(nil, "{{.Year}}/{{.Group}}", "")
+	org := New(remote, "root", layouts)
+	meta := metadata.Metadata{GroupName: "Veena Class", RecordedAt: time.Date(2024, 3, 5, 0, 0, 0, 0, time.Local)}
+
+	if _, err := org.Organize(f.Id, "", meta); err == nil || !strings.Contains(err.Error(), "backend error") {
+		t.Fatalf("expected the move to fail, got %v", err)
+	}
+	if got := mock.Children("root"); len(got) != 1 || got[0].Id != f.Id {
+		t.Errorf("expected the folders made for the move to be removed, root holds %v", got)
+	}
+
+	// A failed folder creation removes the folders created before it.
+	remote.failMove, remote.failCreate = false, "Veena Class"
+	if _, err := org.Organize(f.Id, "", meta); err == nil {
+		t.Fatal("expected the folder creation to fail")
+	}
+	if got := mock.Children("root"); len(got) != 1 {
+		t.Errorf("expected no folders left behind, root holds %v", got)
+	}
+
+	// Once Drive works again, the folders are created anew rather than
+	// taken from the cache.
+	remote.failCreate = ""
+	folderID, err := org.Organize(f.Id, "", meta)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := mock.Get(folderID); got.Trashed || got.Name != "Veena Class" {
+		t.Errorf("expected a live folder, got %+v", got)
+	}
+
+	// An upload that fails inside Within leaves no folders either.
+	if _, err := org.Within("2025/New Group", func(string) error { return errors.New("upload failed") }); err == nil {
+		t.Fatal("expected the upload error")
+	}
+	if got, _ := mock.Find("root", "2025", drive.FolderMimeType); len(got) != 0 {
+		t.Errorf("expected the folders made for the upload to be removed, got %v", got)
+	}
+}
+
+func TestOrganizer_Manifest(t *testing.T) {
+	remote := drive.NewMockRemote()
+	org := New(remote, "root", nil)
+	day := time.Date(2024, 3, 16, 18, 30, 0, 0, time.Local)
+	upload := func(name, content string, meta metadata.Metadata) string {
+		f, _ := remote.Create(&gdrive.File{Name: name, Parents: []string{"root"}}, strings.NewReader(content))
+		if _, err := org.Organize(f.Id, "", meta); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return f.Id
+	}
+	second := upload("part2.m4a", "second", metadata.Metadata{GroupName: "Veena Class", RecordedAt: day.Add(time.Hour), Duration: metadata.Duration(20 * time.Minute),
+		SongsTaught: []string{"Ninnukori"}, Ragas: []string{"Mohanam"}, Talas: []string{"Adi"}})
+	first := upload("part1.m4a", "first", metadata.Metadata{GroupName: "Veena Class", RecordedAt: day, Duration: metadata.Duration(40 * time.Minute),
+		SongsTaught: []string{"Sami Ninne | Varnam"}, Ragas: []string{"Sriranjani"}, Talas: []string{"Adi"}})
+
+	folders, _ := remote.Find("root", "2024-03-16 - Veena Class", drive.FolderMimeType)
+	read := func(name string) []byte {
+		found, _ := remote.Find(folders[0].Id, name, "")
+		if len(found) != 1 {
+			t.Fatalf("expected one %s, got %v", name, found)
+		}
+		return remote.Content[found[0].Id]
+	}
+	var m Manifest
+	if err := json.Unmarshal(read(ManifestName), &m); err != nil {
+		t.Fatalf("unable to parse manifest: %v", err)
+	}
+	if m.Folder != "2024-03-16 - Veena Class" || m.Version != metadata.SchemaVersion || time.Duration(m.Duration) != time.Hour || len(m.Recordings) != 2 {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+	e := m.Recordings[0]
+	if e.FileID != first || e.Name != "part1.m4a" || e.Size != 5 || e.MD5 != "8b04d5e3775d298e78455efc5ca404d5" || e.Metadata.Ragas[0] != "Sriranjani" {
+		t.Errorf("expected the earlier recording first with its checksum, got %+v", e)
+	}
+	index := string(read(IndexName))
+	for _, want := range []string{"# 2024-03-16 - Veena Class", "**Ragas:** Sriranjani, Mohanam", "**Talas:** Adi\n", "Sami Ninne \\| Varnam",
+		"[part2.m4a](https://drive.google.com/file/d/" + second + "/view) | 2024-03-16 19:30 | 20m0s"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("expected %q in the index:\n%s", want, index)
+		}
+	}
+
+	// A recording moved to another session leaves this one's manifest.
+	updates := remote.Calls["Update"]
+	if _, err := org.Organize(second, "", metadata.Metadata{GroupName: "Veena Class", RecordedAt: day.AddDate(0, 0, 1)}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m = Manifest{}
+	json.Unmarshal(read(ManifestName), &m)
+	if len(m.Recordings) != 1 || m.Recordings[0].FileID != first || strings.Contains(string(read(IndexName)), "part2") {
+		t.Errorf("expected only part1 left in the manifest, got %+v", m.Recordings)
+	}
+	if remote.Calls["Update"] == updates {
+		t.Error("expected the manifest to be rewritten")
+	}
+}
+
+func TestOrganizer_BrowseViews(t *testing.T) {
+	remote := drive.NewMockRemote()
+	org := New(remote, "root", nil)
+	org.Views = DefaultViews
+	day := time.Date(2024, 3, 16, 18, 30, 0, 0, time.Local)
+	meta := metadata.Metadata{GroupName: "Veena Class", RecordedAt: day, Ragas: []string{"Todi", "Kalyani"}, Composers: []string{"Tyagaraja"}, Talas: []string{"Misra Chapu"}}
+	f, _ := remote.Create(&gdrive.File{Name: "part1.m4a", Parents: []string{"root"}}, strings.NewReader("audio"))
+	other, _ := remote.Create(&gdrive.File{Name: "part2.m4a", Parents: []string{"root"}}, strings.NewReader("more"))
+	for _, id := range []string{f.Id, other.Id} {
+		if _, err := org.Organize(id, "", meta); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	shortcuts := func(folder string) []string {
+		id, _, _ := New(remote, "root", nil).Folder(folder)
+		var names []string
+		for _, s := range remote.Children(id) {
+			if s.MimeType != drive.ShortcutMimeType || s.ShortcutDetails == nil || s.AppProperties[drive.ShortcutOf] != s.ShortcutDetails.TargetId {
+				t.Errorf("expected a shortcut in %s, got %+v", folder, s)
+			}
+			names = append(names, s.Name)
+		}
+		return names
+	}
+	want := []string{"2024-03-16 Veena Class - part1.m4a", "2024-03-16 Veena Class - part2.m4a"}
+	for _, folder := range []string{"By Raga/Todi", "By Raga/Kalyani", "By Composer/Tyagaraja", "By Tala/Misra Chapu"} {
+		if got := shortcuts(folder); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s holds %v, want %v", folder, got, want)
+		}
+	}
+
+	// Browsing again changes nothing.
+	calls := remote.Calls["Create"] + remote.Calls["Update"]
+	if err := org.Browse(f.Id, meta); err != nil || remote.Calls["Create"]+remote.Calls["Update"] != calls {
+		t.Errorf("expected no changes, got %v", err)
+	}
+
+	// New metadata moves the shortcut out of stale folders, removing those
+	// left empty, and a renamed recording gets a renamed shortcut.
+	remote.Update(f.Id, &gdrive.File{Name: "varnam.m4a"}, "", "", nil, nil)
+	meta.Ragas, meta.Composers = []string{"Kalyani"}, []string{"Pallavi Gopala Iyer"}
+	if err := org.Browse(f.Id, meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := shortcuts("By Raga/Kalyani"); !reflect.DeepEqual(got, []string{"2024-03-16 Veena Class - varnam.m4a", want[1]}) {
+		t.Errorf("expected the shortcut renamed in place, got %v", got)
+	}
+	if got := shortcuts("By Raga/Todi"); !reflect.DeepEqual(got, want[1:]) {
+		t.Errorf("expected only part2 left under Todi, got %v", got)
+	}
+	if got := shortcuts("By Composer/Pallavi Gopala Iyer"); len(got) != 1 {
+		t.Errorf("expected a shortcut for the new composer, got %v", got)
+	}
+	org.Browse(other.Id, meta)
+	if got, _ := remote.Find(org.folders["By Raga"], "Todi", drive.FolderMimeType); len(got) != 0 {
+		t.Errorf("expected the empty Todi folder to be removed, got %v", got)
+	}
+
+	// A trashed recording leaves every view.
+	remote.Update(f.Id, &gdrive.File{Trashed: true}, "", "", nil, nil)
+	if err := org.Browse(f.Id, meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := remote.Shortcuts(f.Id); len(got) != 0 {
+		t.Errorf("expected no shortcuts to a trashed recording, got %v", got)
+	}
+
+	if views, err := ParseViews(" Raga, tala "); err != nil || !reflect.DeepEqual(views, []string{"raga", "tala"}) {
+		t.Errorf("got %v, %v", views, err)
+	}
+	if views, err := ParseViews("none"); err != nil || views == nil || len(views) != 0 {
+		t.Errorf("expected no views, got %v, %v", views, err)
+	}
+	if _, err := ParseViews("raga,song"); err == nil {
+		t.Error("expected an unknown view to be rejected")
+	}
+}
+
+func TestFolderName_UsesRecordingDate(t *testing.T) {
+	meta := metadata.Metadata{GroupName: "Veena Class", RecordedAt: time.Date(2024, 3, 5, 18, 30, 0, 0, time.Local)}
+	name, err := FolderName(meta)
+	if err != nil || name != "2024-03-05 - Veena Class" {
+		t.Errorf("got %q, %v", name, err)
+	}
+	if _, err := FolderName(metadata.Metadata{GroupName: "Veena Class"}); err == nil {
+		t.Error("expected an error without a recording date")
+	}
+}
+
+func TestLayout_Folder(t *testing.T) {
+	terms := func(d time.Time) string {
+		if d.Month() >= time.June {
+			return "Monsoon Term"
+		}
+		return "Spring Term"
+	}
+	layout, err := ParseLayout(`{{.Year}}/{{term .RecordedAt}}/{{.Group}}/{{.Date}} {{.SessionType}} {{slug .Teacher}} {{first .Ragas}}`, terms)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	meta := metadata.Metadata{
+		GroupName:   "Veena: Class/Saturday",
+		Teacher:     "లక్ష్మి రామన్",
+		SessionType: "virtual",
+		Ragas:       []string{"Kalyani", "Todi"},
+		RecordedAt:  time.Date(2024, 7, 6, 18, 30, 0, 0, time.Local),
+	}
+	got, err := layout.Folder(meta)
+	if want := "2024/Monsoon Term/Veena- Class-Saturday/2024-07-06 virtual lakshmi-raman Kalyani"; err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+
+	// Missing values leave folders out rather than making empty names.
+	got, err = layout.Folder(metadata.Metadata{GroupName: "Veena Class"})
+	if err != nil || got != "Veena Class" {
+		t.Errorf("got %q, %v", got, err)
+	}
+
+	for _, bad := range []string{"{{.Year", "{{.Nope}}", "{{nope .Group}}"} {
+		if _, err := ParseLayout(bad, nil); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestLayout_Name(t *testing.T) {
+	layout, err := ParseLayout(`{{.Date}}_{{.Group}}_{{join .Songs "-"}}_{{first .Ragas}}.{{.Ext}}`, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	meta := metadata.Metadata{GroupName: "Veena Class", RecordedAt: time.Date(2024, 1, 5, 18, 0, 0, 0, time.Local),
+		SongsTaught: []string{"Ninnukori", "Sami Ninne"}, Ragas: []string{"Mohanam"}}
+	cases := []struct {
+		meta     metadata.Metadata
+		original string
+		want     string
+	}{
+		{meta, "AUD-20240105-WA0003.opus.mp4", "2024-01-05_Veena Class_Ninnukori-Sami Ninne_Mohanam.mp4"},
+		// Empty values leave no doubled separators behind.
+		{metadata.Metadata{GroupName: "Veena Class", Ragas: []string{"Todi"}}, "class.M4A", "Veena Class_Todi.M4A"},
+		// Names in Indian scripts are kept, unsafe characters are not.
+		{metadata.Metadata{GroupName: "வீணை: வகுப்பு", Ragas: []string{"கல்யாணி"}}, "a.m4a", "வீணை- வகுப்பு_கல்யாணி.m4a"},
+		{metadata.Metadata{}, "AUD-20240105-WA0003.opus", "AUD-20240105-WA0003.opus"},
+	}
+	for _, c := range cases {
+		if got, err := layout.Name(c.meta, c.original); err != nil || got != c.want {
+			t.Errorf("Name(%q) = %q, %v, want %q", c.original, got, err, c.want)
+		}
+	}
+
+	long := meta
+	long.SongsTaught = []string{strings.Repeat("ஸ்ரீ", 60)}
+	if got, _ := layout.Name(long, "a.opus"); len(got) > maxNameBytes+len(".opus") || !strings.HasSuffix(got, ".opus") || !utf8.ValidString(got) {
+		t.Errorf("expected a shortened name that keeps its extension, got %q", got)
+	}
+	// The extension is added when the template leaves it out.
+	bare, _ := ParseLayout("{{.Original}} {{.Date}}", nil)
+	if got, _ := bare.Name(meta, "AUD-1.opus"); got != "AUD-1 2024-01-05.opus" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestOrganizer_Renames(t *testing.T) {
+	remote := drive.NewMockRemote()
+	layouts, _ := NewLayouts(nil, "{{.Group}}", "{{.Date}}_{{first .Ragas}}.{{.Ext}}")
+	org := New(remote, "root", layouts)
+	meta := metadata.Metadata{GroupName: "Veena Class", RecordedAt: time.Date(2024, 1, 5, 18, 0, 0, 0, time.Local), Ragas: []string{"Todi"}}
+	var ids []string
+	for _, name := range []string{"AUD-20240105-WA0003.opus", "AUD-20240105-WA0004.opus"} {
+		f, _ := remote.Create(&gdrive.File{Name: name, Parents: []string{"root"}}, strings.NewReader(name))
+		if _, err := org.Organize(f.Id, "", meta); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids = append(ids, f.Id)
+	}
+	for i, want := range []string{"2024-01-05_Todi.opus", "2024-01-05_Todi_2.opus"} {
+		f, _ := remote.Get(ids[i])
+		if f.Name != want || f.AppProperties[drive.OriginalName] != fmt.Sprintf("AUD-20240105-WA000%d.opus", i+3) {
+			t.Errorf("expected %s, keeping the original name, got %s %v", want, f.Name, f.AppProperties)
+		}
+	}
+
+	// Organizing again keeps the names and the suffix; new metadata renames
+	// from the original name.
+	if _, err := org.Organize(ids[1], "", meta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f, _ := remote.Get(ids[1]); f.Name != "2024-01-05_Todi_2.opus" {
+		t.Errorf("expected the name to be stable, got %s", f.Name)
+	}
+	meta.Ragas = []string{"Kalyani"}
+	org.Organize(ids[1], "", meta)
+	if f, _ := remote.Get(ids[1]); f.Name != "2024-01-05_Kalyani.opus" || f.AppProperties[drive.OriginalName] != "AUD-20240105-WA0004.opus" {
+		t.Errorf("got %s %v", f.Name, f.AppProperties)
+	}
+
+	// A move that is undone gives the file its old name back.
+	failing := &failingRemote{MockRemote: remote}
+	f, _ := remote.Create(&gdrive.File{Name: "AUD-20240105-WA0005.opus", Parents: []string{"root"}}, strings.NewReader("x"))
+	org = New(failing, "root", layouts)
+	failing.failCreate = ManifestName
+	if _, err := org.Organize(f.Id, "Elsewhere", meta); err == nil {
+		t.Fatal("expected the manifest to fail")
+	}
+	if got, _ := remote.Get(f.Id); got.Name != "AUD-20240105-WA0005.opus" || got.AppProperties[drive.OriginalName] != "" || got.Parents[0] != "root" {
+		t.Errorf("expected the file restored, got %s %v in %v", got.Name, got.AppProperties, got.Parents)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	cases := map[string]string{
+		"  Class:  2024?  ": "Class- 2024-",
+		"...hidden.":        "hidden",
+		"con":               "_con",
+		"NUL.txt":           "_NUL.txt",
+		"tab\there":         "tab here",
+		"கல்யாணி <ragam>":   "கல்யாணி -ragam-",
+	}
+	for in, want := range cases {
+		if got := Sanitize(in); got != want {
+			t.Errorf("Sanitize(%q) = %q, want %q", in, got, want)
+		}
+	}
+	long := Sanitize(strings.Repeat("ல", 100))
+	if len(long) > maxNameBytes || !utf8.ValidString(long) {
+		t.Errorf("expected a valid name of at most %d bytes, got %d", maxNameBytes, len(long))
+	}
+}
+
+func TestLayouts_PerGroup(t *testing.T) {
+	groups := &config.Groups{
+		Terms:  []config.Term{{Name: "Term 1", Start: "2024-01-01", End: "2024-04-30"}},
+		Groups: []config.Group{{Name: "Vocal", Layout: "Vocal/{{.Term}}/{{.Date}}"}, {Name: "Veena"}},
+	}
+	layouts, err := NewLayouts(groups, DefaultLayout, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	day := time.Date(2024, 3, 5, 18, 0, 0, 0, time.Local)
+	if got, _ := layouts.Folder(metadata.Metadata{GroupName: "vocal", RecordedAt: day}); got != "Vocal/Term 1/2024-03-05" {
+		t.Errorf("unexpected group layout folder %q", got)
+	}
+	if got, _ := layouts.Folder(metadata.Metadata{GroupName: "Veena", RecordedAt: day}); got != "2024-03-05 - Veena" {
+		t.Errorf("unexpected default layout folder %q", got)
+	}
+	if _, err := NewLayouts(&config.Groups{Layout: "{{.Nope}}"}, "", ""); err == nil {
+		t.Error("expected an invalid layout to be rejected")
+	}
+	none, _ := NewLayouts(nil, "", "")
+	if got, err := none.Folder(metadata.Metadata{GroupName: "Veena"}); got != "" || err != nil {
+		t.Errorf("expected no folder without a layout, got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,166 @@
+package organizer
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/metadata"
+)
+
+// View is a browse tree below the upload folder, such as "By Raga", with a
+// folder for each value, such as "By Raga/Todi", holding shortcuts to the
+// recordings with that value. Shortcuts take no storage; the recording
+// itself stays in its session folder.
+type View struct {
+	Folder string
+	Values func(m metadata.Metadata) []string
+}
+
+// Views are the browse trees the organizer can keep, by the names used to
+// choose them.
+var Views = map[string]View{
+	"raga":     {"By Raga", func(m metadata.Metadata) []string { return m.Ragas }},
+	"composer": {"By Composer", func(m metadata.Metadata) []string { return m.Composers }},
+	"tala":     {"By Tala", func(m metadata.Metadata) []string { return m.Talas }},
+}
+
+// DefaultViews are the views kept unless others are chosen.
+var DefaultViews = []string{"raga", "composer", "tala"}
+
+// ParseViews reads a comma-separated list of view names, such as
+// "raga,tala". An empty string yields DefaultViews and "none" no views.
+func ParseViews(s string) ([]string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return DefaultViews, nil
+	case "none":
+		return []string{}, nil
+	}
+	var views []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := Views[name]; !ok {
+			return nil, fmt.Errorf("unknown view %q (use %s, or none)", name, strings.Join(DefaultViews, ", "))
+		}
+		views = append(views, name)
+	}
+	return views, nil
+}
+
+// Browse makes the browse views show the recording fileID under its
+// metadata meta: shortcuts are made in the folders of its values, and
+// shortcuts left from earlier metadata are removed, or renamed when only
+// the recording's name changed. View folders left empty are removed. A
+// recording that is in the trash is taken out of every view.
+func (o *Organizer) Browse(fileID string, meta metadata.Metadata) error {
+	f, err := o.Remote.Get(fileID)
+	if err != nil {
+		return fmt.Errorf("unable to find file %s: %v", fileID, err)
+	}
+	name := shortcutName(f.Name, meta)
+	want := map[string]bool{}
+	var folders []string
+	for _, view := range o.Views {
+		for _, value := range Views[view].Values(meta) {
+			if f.Trashed || Sanitize(value) == "" {
+				continue
+			}
+			id, _, err := o.Folder(path.Join(Views[view].Folder, Sanitize(value)))
+			if err != nil {
+				return err
+			}
+			if !want[id] {
+				want[id] = true
+				folders = append(folders, id)
+			}
+		}
+	}
+
+	shortcuts, err := o.Remote.Shortcuts(fileID)
+	if err != nil {
+		return fmt.Errorf("error searching for shortcuts to %s: %v", f.Name, err)
+	}
+	have := map[string]bool{}
+	var emptied []string
+	for _, s := range shortcuts {
+		parent := first(s.Parents)
+		if want[parent] && !have[parent] {
+			have[parent] = true
+			if s.Name != name {
+				if _, err := o.Remote.Update(s.Id, &gdrive.File{Name: name}, "", "", nil, nil); err != nil {
+					return fmt.Errorf("unable to rename shortcut to %s: %v", f.Name, err)
+				}
+			}
+			continue
+		}
+		if _, err := o.Remote.Update(s.Id, &gdrive.File{Trashed: true}, "", "", nil, nil); err != nil {
+			return fmt.Errorf("unable to remove shortcut to %s: %v", f.Name, err)
+		}
+		emptied = append(emptied, parent)
+	}
+	for _, id := range folders {
+		if have[id] {
+			continue
+		}
+		shortcut := &gdrive.File{
+			Name:            name,
+			MimeType:        drive.ShortcutMimeType,
+			Parents:         []string{id},
+			ShortcutDetails: &gdrive.FileShortcutDetails{TargetId: fileID},
+			AppProperties:   map[string]string{drive.ShortcutOf: fileID},
+		}
+		if _, err := o.Remote.Create(shortcut, nil); err != nil {
+			return fmt.Errorf("unable to make shortcut to %s: %v", f.Name, err)
+		}
+	}
+	for _, id := range emptied {
+		if err := o.removeIfEmpty(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIfEmpty trashes a view folder, such as "By Raga/Todi", that no
+// longer holds anything.
+func (o *Organizer) removeIfEmpty(folderID string) error {
+	if folderID == "" {
+		return nil
+	}
+	children, err := o.Remote.List(folderID)
+	if err != nil {
+		return fmt.Errorf("unable to list folder %s: %v", folderID, err)
+	}
+	if len(children) > 0 {
+		return nil
+	}
+	if _, err := o.Remote.Update(folderID, &gdrive.File{Trashed: true}, "", "", nil, nil); err != nil {
+		return fmt.Errorf("unable to remove empty folder %s: %v", folderID, err)
+	}
+	for p, id := range o.folders {
+		if id == folderID {
+			delete(o.folders, p)
+		}
+	}
+	return nil
+}
+
+// shortcutName names a shortcut so it can be told apart from the other
+// recordings in a view: "2024-03-05 Veena Class - part1.m4a".
+func shortcutName(name string, meta metadata.Metadata) string {
+	var prefix []string
+	if !meta.RecordedAt.IsZero() {
+		prefix = append(prefix, meta.RecordedAt.Format("2006-01-02"))
+	}
+	if meta.GroupName != "" {
+		prefix = append(prefix, meta.GroupName)
+	}
+	if len(prefix) == 0 {
+		return Sanitize(name)
+	}
+	return Sanitize(strings.Join(prefix, " ") + " - " + name)
+}
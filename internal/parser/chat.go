@@ -0,0 +1,308 @@
+package parser
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"musicloud/internal/metadata"
+)
+
+// Message is a single entry of a chat export.
+type Message struct {
+	ID         int64 // message ID, for sources that have one (Telegram)
+	ReplyTo    int64 // ID of the message this one replies to, if any
+	Time       time.Time
+	Sender     string // empty for system notifications
+	SenderID   string // stable sender ID, for sources that have one (Telegram "user123")
+	Text       string
+	Attachment string // file name of the attached media, if any
+	Omitted    string // kind of media the export left out ("audio", "video", "media", ...), if any
+	Line       int    // 1-based line number where the message starts
+}
+
+// IsSystem reports whether the message is a group notification rather than a
+// message sent by a member.
+func (m Message) IsSystem() bool {
+	return m.Sender == ""
+}
+
+// Fingerprint returns a stable identifier for the message content.
+func (m Message) Fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00%s", m.Time.Format("2006-01-02 15:04:05"), m.Sender, m.Text, m.Attachment, m.Omitted)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// Key identifies the message in processing state, for an attachment
+// uploaded before the rest of its chat is committed.
+func (m Message) Key() string {
+	return "message:" + m.Fingerprint()
+}
+
+// Chat sources, as recorded in metadata.
+const (
+	SourceWhatsApp = metadata.SourceWhatsApp
+	SourceTelegram = metadata.SourceTelegram
+)
+
+// Chat is a parsed chat export.
+type Chat struct {
+	Name     string
+	Path     string
+	Source   string // SourceWhatsApp or SourceTelegram
+	Messages []Message
+	// Title is the group title at export time; Titles lists every title the
+	// chat has had, oldest first, as seen in the file name and the group
+	// notifications.
+	Title  string
+	Titles []string
+	// DateOrder is the date format the export's timestamps were read with.
+	DateOrder DateOrder
+}
+
+// ID identifies the chat across repeated exports: the chat name plus the
+// fingerprint of its first message.
+func (c *Chat) ID() string {
+	if len(c.Messages) == 0 {
+		return c.Name
+	}
+	return c.Name + "#" + c.Messages[0].Fingerprint()
+}
+
+func (c *Chat) isTitle(name string) bool {
+	for _, t := range c.Titles {
+		if name == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Caption returns the caption of the message at index i: its own text, else
+// the text of a reply to it, else text the same sender posted right after it.
+func Caption(messages []Message, i int) string {
+	m := messages[i]
+	if text := strings.TrimSpace(m.Text); text != "" {
+		return text
+	}
+	if m.ID != 0 {
+		for _, r := range messages[i+1:] {
+			if r.ReplyTo == m.ID && strings.TrimSpace(r.Text) != "" && r.Attachment == "" {
+				return strings.TrimSpace(r.Text)
+			}
+		}
+	}
+	return followingCaption(messages, i)
+}
+
+// Attachments returns the messages that carry a media attachment.
+func Attachments(messages []Message) []Message {
+	var out []Message
+	for _, m := range messages {
+		if m.Attachment != "" {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+var (
+	attachedIOS = regexp.MustCompile(`^<attached: (.+)>$`)
+	attachedAnd = regexp.MustCompile(`^(.+\.[A-Za-z0-9]{2,5}) \(file attached\)$`)
+)
+
+// ParseChat parses a WhatsApp chat export into its messages, detecting the
+// date format and using local time. Lines that do not start a new message
+// are appended to the previous message's text.
+func ParseChat(filePath string) (*Chat, error) {
+	return ParseChatWith(filePath, Options{})
+}
+
+// ParseChatWith parses a WhatsApp chat export using the given options.
+func ParseChatWith(filePath string, opts Options) (*Chat, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The date format can only be told apart by looking at every timestamp,
+	// so the whole export is read before any message is built.
+	type rawLine struct {
+		text  string
+		stamp stamp
+		body  string
+		start bool
+	}
+	var lines []rawLine
+	var stamps []stamp
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		l := rawLine{text: normalizeLine(scanner.Text())}
+		l.stamp, l.body, l.start = matchLine(l.text)
+		if l.start {
+			l.stamp.line = len(lines) + 1
+			stamps = append(stamps, l.stamp)
+		}
+		lines = append(lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	order := opts.DateOrder
+	if order == "" {
+		order, err = detectDateOrder(stamps, opts.location())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+	}
+
+	chat := &Chat{Name: chatNameFromFile(filePath), Path: filePath, Source: SourceWhatsApp, DateOrder: order}
+	var titles []string
+	for n, l := range lines {
+		if !l.start {
+			if k := len(chat.Messages); k > 0 {
+				appendText(&chat.Messages[k-1], l.text)
+			}
+			continue
+		}
+		ts, err := l.stamp.time(order, opts.location())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filePath, n+1, err)
+		}
+		msg := Message{Time: ts, Line: n + 1}
+		body := l.body
+		if from, to, ok := titleChange(body); ok {
+			titles = append(titles, from, to)
+		} else if i := strings.Index(body, ": "); i > 0 {
+			msg.Sender, body = body[:i], body[i+2:]
+			if from, to, ok := titleChange(body); ok {
+				// iOS exports show group notifications as sent by the group itself
+				titles = append(titles, msg.Sender, from, to)
+				msg.Sender = ""
+			}
+		}
+		appendText(&msg, body)
+		chat.Messages = append(chat.Messages, msg)
+	}
+
+	chat.Titles = uniqueTitles(append(titles, chat.Name))
+	if n := len(chat.Titles); n > 0 {
+		chat.Title = chat.Titles[n-1]
+	}
+	if chat.Name == "" {
+		chat.Name = chat.Title
+	}
+	for i := range chat.Messages {
+		if chat.isTitle(chat.Messages[i].Sender) {
+			chat.Messages[i].Sender = ""
+		}
+	}
+	return chat, nil
+}
+
+var titleEvents = []*regexp.Regexp{
+	regexp.MustCompile(`^.+? created group "(?P<to>.+)"$`),
+	regexp.MustCompile(`^.+? changed the subject (?:from "(?P<from>.+)" )?to "(?P<to>.+)"$`),
+	regexp.MustCompile(`^.+? changed (?:the )?group name (?:from "(?P<from>.+)" )?to "(?P<to>.+)"$`),
+}
+
+// titleChange recognises the group notifications that create a group or
+// change its title.
+func titleChange(text string) (from, to string, ok bool) {
+	text = strings.NewReplacer("“", `"`, "”", `"`).Replace(strings.TrimSpace(text))
+	for _, re := range titleEvents {
+		match := re.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			switch name {
+			case "from":
+				from = match[i]
+			case "to":
+				to = match[i]
+			}
+		}
+		return from, to, true
+	}
+	return "", "", false
+}
+
+// uniqueTitles drops empty and repeated titles, keeping the last occurrence so
+// the most recent title ends up last.
+func uniqueTitles(titles []string) []string {
+	var out []string
+	for i, t := range titles {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		later := false
+		for _, u := range titles[i+1:] {
+			if strings.TrimSpace(u) == t {
+				later = true
+				break
+			}
+		}
+		if !later {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// appendText adds a line of text to the message, recognising attachment
+// markers on the way.
+func appendText(m *Message, line string) {
+	if m.Attachment == "" {
+		trimmed := strings.TrimSpace(line)
+		if match := attachedIOS.FindStringSubmatch(trimmed); match != nil {
+			m.Attachment = match[1]
+			return
+		}
+		if match := attachedAnd.FindStringSubmatch(trimmed); match != nil && m.Text == "" {
+			m.Attachment = match[1]
+			return
+		}
+	}
+	if m.Omitted == "" && m.Text == "" {
+		if kind, ok := omittedMedia(line); ok {
+			m.Omitted = kind
+			return
+		}
+	}
+	if m.Text == "" {
+		m.Text = line
+		return
+	}
+	m.Text += "\n" + line
+}
+
+// normalizeLine strips the direction marks and odd spaces phones put into exports.
+func normalizeLine(line string) string {
+	line = strings.NewReplacer("\u200e", "", "\u200f", "", "\ufeff", "", "\u202f", " ", "\u00a0", " ").Replace(line)
+	return strings.TrimRight(line, "\r")
+}
+
+// chatNameFromFile derives the chat name from an export file name such as
+// "WhatsApp Chat with Veena Class.txt". iOS exports are always named
+// "_chat.txt" and carry no name.
+func chatNameFromFile(filePath string) string {
+	name := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	if name == "_chat" {
+		return ""
+	}
+	name = strings.TrimPrefix(name, "WhatsApp Chat with ")
+	name = strings.TrimPrefix(name, "WhatsApp Chat - ")
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,254 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DateOrder is the order of the day, month and year fields in export timestamps.
+type DateOrder string
+
+const (
+	DayMonthYear DateOrder = "dmy" // India, UK: 05/01/24
+	MonthDayYear DateOrder = "mdy" // US: 1/5/24
+	YearMonthDay DateOrder = "ymd" // ISO: 2024-01-05
+)
+
+// ParseDateOrder parses a date order given as "dmy", "dd/mm/yy", "mm/dd/yyyy", "yyyy-mm-dd" and the like.
+func ParseDateOrder(s string) (DateOrder, error) {
+	key := strings.Map(func(r rune) rune {
+		switch r {
+		case 'd', 'D':
+			return 'd'
+		case 'm', 'M':
+			return 'm'
+		case 'y', 'Y':
+			return 'y'
+		}
+		return -1
+	}, s)
+	squeezed := ""
+	for _, r := range key {
+		if !strings.HasSuffix(squeezed, string(r)) {
+			squeezed += string(r)
+		}
+	}
+	switch DateOrder(squeezed) {
+	case DayMonthYear, MonthDayYear, YearMonthDay:
+		return DateOrder(squeezed), nil
+	}
+	return "", fmt.Errorf("unknown date format %q (use dd/mm/yy, mm/dd/yy or yyyy-mm-dd)", s)
+}
+
+// ErrAmbiguousDates is returned when more than one date order fits every
+// timestamp in an export and none was given explicitly.
+var ErrAmbiguousDates = errors.New("date format is ambiguous")
+
+// Options control how chat exports are read.
+type Options struct {
+	// DateOrder overrides date format detection.
+	DateOrder DateOrder
+	// Location is the time zone timestamps are interpreted in; nil means local time.
+	Location *time.Location
+}
+
+func (o Options) location() *time.Location {
+	if o.Location == nil {
+		return time.Local
+	}
+	return o.Location
+}
+
+// Localized AM/PM markers seen in exports from Indian, US and UK phones.
+var (
+	amMarkers = []string{"am", "a.m.", "a. m.", "a.m", "पूर्वाह्न", "म.पू.", "পূর্বাহ্ণ", "முற்பகல்", "ಪೂರ್ವಾಹ್ನ"}
+	pmMarkers = []string{"pm", "p.m.", "p. m.", "p.m", "अपराह्न", "म.उ.", "অপরাহ্ণ", "பிற்பகல்", "ಅಪರಾಹ್ನ"}
+)
+
+var messageLine = regexp.MustCompile(`^\[?(\p{Nd}{1,4})[./-](\p{Nd}{1,2})[./-](\p{Nd}{1,4}),? (\p{Nd}{1,2})[:.](\p{Nd}{2})(?:[:.](\p{Nd}{2}))?(?: ?(?i:(` + markerPattern() + `)))?\]?(?: -|:)? (.*)$`)
+
+func markerPattern() string {
+	all := append(append([]string{}, amMarkers...), pmMarkers...)
+	sort.Slice(all, func(i, j int) bool { return len(all[i]) > len(all[j]) })
+	for i, m := range all {
+		all[i] = regexp.QuoteMeta(m)
+	}
+	return strings.Join(all, "|")
+}
+
+// stamp is the raw timestamp of a message line, with digits already
+// converted to ASCII.
+type stamp struct {
+	fields [3]string
+	hour   int
+	minute int
+	second int
+	pm     int // 0 for 24-hour clocks, 1 for AM, 2 for PM
+	line   int
+}
+
+// matchLine splits a message line into its timestamp and body.
+func matchLine(line string) (stamp, string, bool) {
+	m := messageLine.FindStringSubmatch(line)
+	if m == nil {
+		return stamp{}, "", false
+	}
+	var s stamp
+	for i := 0; i < 3; i++ {
+		s.fields[i] = asciiDigits(m[i+1])
+	}
+	s.hour, _ = strconv.Atoi(asciiDigits(m[4]))
+	s.minute, _ = strconv.Atoi(asciiDigits(m[5]))
+	if m[6] != "" {
+		s.second, _ = strconv.Atoi(asciiDigits(m[6]))
+	}
+	if m[7] != "" {
+		s.pm = 1
+		for _, pm := range pmMarkers {
+			if strings.EqualFold(m[7], pm) {
+				s.pm = 2
+			}
+		}
+	}
+	return s, m[8], true
+}
+
+// time converts the stamp using the given date order.
+func (s stamp) time(order DateOrder, loc *time.Location) (time.Time, error) {
+	var d, mo, y string
+	switch order {
+	case DayMonthYear:
+		d, mo, y = s.fields[0], s.fields[1], s.fields[2]
+	case MonthDayYear:
+		mo, d, y = s.fields[0], s.fields[1], s.fields[2]
+	case YearMonthDay:
+		y, mo, d = s.fields[0], s.fields[1], s.fields[2]
+	}
+	day, _ := strconv.Atoi(d)
+	month, _ := strconv.Atoi(mo)
+	year, _ := strconv.Atoi(y)
+	if len(y) <= 2 {
+		year += 2000
+	} else if len(y) != 4 {
+		return time.Time{}, fmt.Errorf("invalid year %q", y)
+	}
+
+	hour := s.hour
+	switch s.pm {
+	case 1, 2:
+		if hour < 1 || hour > 12 {
+			return time.Time{}, fmt.Errorf("invalid 12-hour clock value %d", hour)
+		}
+		hour %= 12
+		if s.pm == 2 {
+			hour += 12
+		}
+	}
+	if month < 1 || month > 12 || day < 1 || hour > 23 || s.minute > 59 || s.second > 59 {
+		return time.Time{}, fmt.Errorf("invalid %s timestamp %s", order, strings.Join(s.fields[:], "/"))
+	}
+	t := time.Date(year, time.Month(month), day, hour, s.minute, s.second, 0, loc)
+	if t.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid %s date %s", order, strings.Join(s.fields[:], "/"))
+	}
+	return t, nil
+}
+
+// maxClockSkew is how far back in time a message may appear relative to the
+// one before it, which happens when the phone changes time zone.
+const maxClockSkew = 48 * time.Hour
+
+// detectDateOrder works out the date order from every timestamp in an
+// export. A candidate order is ruled out if any date is impossible under it or
+// if it puts messages out of chronological order.
+func detectDateOrder(stamps []stamp, loc *time.Location) (DateOrder, error) {
+	if len(stamps) == 0 {
+		return DayMonthYear, nil
+	}
+	candidates := []DateOrder{DayMonthYear, MonthDayYear}
+	if len(stamps[0].fields[0]) == 4 {
+		candidates = []DateOrder{YearMonthDay}
+	}
+
+	var fits []DateOrder
+	var lastErr error
+	for _, order := range candidates {
+		if err := checkOrder(stamps, order, loc); err != nil {
+			lastErr = err
+			continue
+		}
+		fits = append(fits, order)
+	}
+	switch len(fits) {
+	case 0:
+		return "", fmt.Errorf("timestamps fit no known date format: %v", lastErr)
+	case 1:
+		return fits[0], nil
+	}
+	return "", fmt.Errorf("%w: every timestamp fits both dd/mm and mm/dd; set the date format explicitly", ErrAmbiguousDates)
+}
+
+func checkOrder(stamps []stamp, order DateOrder, loc *time.Location) error {
+	var prev time.Time
+	for _, s := range stamps {
+		t, err := s.time(order, loc)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", s.line, err)
+		}
+		if !prev.IsZero() && prev.Sub(t) > maxClockSkew {
+			return fmt.Errorf("line %d: %s order puts messages out of sequence", s.line, order)
+		}
+		prev = t
+	}
+	return nil
+}
+
+// digitZeros lists the zero of every decimal digit block phones use in exports.
+var digitZeros = []rune{
+	'0',
+	'٠', // Arabic-Indic
+	'۰', // Extended Arabic-Indic
+	'०', // Devanagari
+	'০', // Bengali
+	'੦', // Gurmukhi
+	'૦', // Gujarati
+	'୦', // Oriya
+	'௦', // Tamil
+	'౦', // Telugu
+	'೦', // Kannada
+	'൦', // Malayalam
+	'０', // fullwidth
+}
+
+// asciiDigits converts decimal digits from any of the supported scripts to ASCII.
+func asciiDigits(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		for _, zero := range digitZeros {
+			if r >= zero && r <= zero+9 {
+				r = '0' + (r - zero)
+				break
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
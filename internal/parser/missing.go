@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MissingMedia is a recording that was shared in a chat but is not part of
+// the export, either because the chat was exported without media or because
+// the exporting phone never downloaded it.
+type MissingMedia struct {
+	Chat    string
+	Sender  string
+	Time    time.Time
+	Kind    string // "audio", "video", or "media" when the export does not say
+	Caption string
+	File    string // attachment name, when the export names the missing file
+	Line    int
+}
+
+var omittedLine = regexp.MustCompile(`^<?(?i:(media|audio|video|image|sticker|gif|document|contact card)) omitted>?$`)
+
+// omittedMedia recognises the placeholders exports contain instead of media:
+// "<Media omitted>" on Android, "audio omitted" and the like on iOS.
+func omittedMedia(line string) (string, bool) {
+	m := omittedLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", false
+	}
+	return strings.ToLower(m[1]), true
+}
+
+// RecordingKeywords are words in a caption that make an untyped omitted media
+// placeholder look like a class recording.
+var RecordingKeywords = []string{
+	"class", "lesson", "recording", "practice", "song", "raga", "ragam", "tala", "talam",
+	"varnam", "kriti", "krithi", "keerthanam", "kirtana", "geetham", "swara", "alapana", "audio", "video",
+}
+
+// captionWindow is how soon after an uncaptioned placeholder a text message
+// from the same sender is taken as its caption.
+const captionWindow = 5 * time.Minute
+
+// MissingRecordings lists the omitted media in messages that look like class
+// recordings. present reports whether an attachment file exists next to the
+// export; attachments it rejects are listed too. present may be nil.
+func MissingRecordings(chatName string, messages []Message, present func(name string) bool) []MissingMedia {
+	var out []MissingMedia
+	for i, m := range messages {
+		kind := m.Omitted
+		if kind == "" && m.Attachment != "" && present != nil && !present(m.Attachment) {
+			kind = MediaKind(m.Attachment)
+		}
+		if kind == "" {
+			continue
+		}
+		caption := Caption(messages, i)
+		switch kind {
+		case "audio", "video":
+		case "media":
+			if !mentionsRecording(caption) {
+				continue
+			}
+		default:
+			continue
+		}
+		out = append(out, MissingMedia{
+			Chat:    chatName,
+			Sender:  m.Sender,
+			Time:    m.Time,
+			Kind:    kind,
+			Caption: caption,
+			File:    m.Attachment,
+			Line:    m.Line,
+		})
+	}
+	return out
+}
+
+// followingCaption returns the text the same sender posted right after the
+// message at index i, which is how captions of omitted media usually survive.
+func followingCaption(messages []Message, i int) string {
+	m := messages[i]
+	for _, next := range messages[i+1:] {
+		if next.Time.Sub(m.Time) > captionWindow {
+			break
+		}
+		if next.Sender != m.Sender || next.ReplyTo != 0 {
+			continue
+		}
+		if next.Attachment != "" || next.Omitted != "" {
+			break
+		}
+		return strings.TrimSpace(next.Text)
+	}
+	return ""
+}
+
+func mentionsRecording(caption string) bool {
+	caption = strings.ToLower(caption)
+	for _, k := range RecordingKeywords {
+		if strings.Contains(caption, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// MediaKind classifies an attachment file name as "audio", "video" or "other".
+func MediaKind(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".opus", ".mp3", ".m4a", ".aac", ".ogg", ".wav", ".flac", ".amr":
+		return "audio"
+	case ".mp4", ".mov", ".avi", ".mkv", ".3gp", ".webm":
+		return "video"
+	}
+	return "other"
+}
+
+// WriteMissingReport writes a list of missing recordings for the group admin,
+// grouped by chat, so they can be collected from the phones that have them.
+func WriteMissingReport(w io.Writer, missing []MissingMedia) error {
+	if _, err := fmt.Fprintln(w, "# Recordings shared but not exported"); err != nil {
+		return err
+	}
+	chat := ""
+	for _, m := range missing {
+		if m.Chat != chat || chat == "" {
+			chat = m.Chat
+			fmt.Fprintf(w, "\n## %s\n\n", chat)
+		}
+		line := fmt.Sprintf("- %s, %s, sent by %s", m.Time.Format("2006-01-02 15:04"), m.Kind, m.Sender)
+		if m.File != "" {
+			line += " (" + m.File + ")"
+		}
+		if m.Caption != "" {
+			line += ": " + strings.ReplaceAll(m.Caption, "\n", " ")
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
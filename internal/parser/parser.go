@@ -4,22 +4,13 @@ import (
 	"bufio"
 	"os"
 	"strings"
-)
 
-// MusicFile represents a music-related file with its metadata.
-type MusicFile struct {
-	Title     string
-	Composer  string
-	Raga      string
-	Tala      string
-	GroupName string
-	Teacher   string
-	SessionType string
-}
+	"musicloud/internal/metadata"
+)
 
 // ParseWhatsAppExport parses the exported WhatsApp text file to identify music-related files.
-func ParseWhatsAppExport(filePath string) ([]MusicFile, error) {
-	var musicFiles []MusicFile
+func ParseWhatsAppExport(filePath string) ([]metadata.Metadata, error) {
+	var musicFiles []metadata.Metadata
 
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -31,8 +22,9 @@ func ParseWhatsAppExport(filePath string) ([]MusicFile, error) {
 	for scanner.Scan() {
 		line := scanner.Text()
 		if isMusicRelated(line) {
-			musicFile := extractMusicFileInfo(line)
-			musicFiles = append(musicFiles, musicFile)
+			if musicFile, ok := extractMusicFileInfo(line); ok {
+				musicFiles = append(musicFiles, musicFile)
+			}
 		}
 	}
 
@@ -49,17 +41,35 @@ func isMusicRelated(line string) bool {
 	return strings.Contains(line, "song") || strings.Contains(line, "ragas") || strings.Contains(line, "talas")
 }
 
-// extractMusicFileInfo extracts music file information from a line.
-func extractMusicFileInfo(line string) MusicFile {
-	// Implement logic to parse the line and extract relevant information
+// extractMusicFileInfo extracts music file information from a line of the
+// form "title,composer,raga,tala,group,teacher,session type".
+func extractMusicFileInfo(line string) (metadata.Metadata, bool) {
 	parts := strings.Split(line, ",")
-	return MusicFile{
-		Title:     parts[0],
-		Composer:  parts[1],
-		Raga:      parts[2],
-		Tala:      parts[3],
-		GroupName: parts[4],
-		Teacher:   parts[5],
-		SessionType: parts[6],
+	if len(parts) < 7 {
+		return metadata.Metadata{}, false
+	}
+	m := metadata.Metadata{
+		GroupName:   strings.TrimSpace(parts[4]),
+		Teacher:     strings.TrimSpace(parts[5]),
+		SongsTaught: nonEmpty(parts[0]),
+		Composers:   nonEmpty(parts[1]),
+		Ragas:       nonEmpty(parts[2]),
+		Talas:       nonEmpty(parts[3]),
+		Source:      metadata.SourceWhatsApp,
+	}
+	m.SessionType, _ = metadata.NormalizeSessionType(parts[6])
+	return m, true
+}
+
+func nonEmpty(v string) []string {
+	if v = strings.TrimSpace(v); v != "" {
+		return []string{v}
 	}
-}
\ No newline at end of file
+	return nil
+}
+
+// MessageMetadata is what a chat message says about the recording attached
+// to it: the chat's group, where it came from and when it was sent.
+func MessageMetadata(chat *Chat, m Message) metadata.Metadata {
+	return metadata.Metadata{GroupName: chat.Title, Source: chat.Source, RecordedAt: m.Time}
+}
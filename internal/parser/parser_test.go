@@ -1,7 +1,12 @@
 package parser
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseWhatsAppExport_FileNotFound(t *testing.T) {
@@ -11,4 +16,341 @@ func TestParseWhatsAppExport_FileNotFound(t *testing.T) {
 	}
 }
 
-// Add more tests for isMusicRelated and extractMusicFileInfo if exported
+func TestParseWhatsAppExport_Metadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.txt")
+	os.WriteFile(path, []byte("Ninnukori song,Poochi Srinivasa Iyengar,Mohanam,Adi,Veena Class,Lakshmi,online\nsong without fields\n"), 0644)
+	files, err := ParseWhatsAppExport(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one entry, got %+v", files)
+	}
+	m := files[0]
+	if m.SongsTaught[0] != "Ninnukori song" || m.Ragas[0] != "Mohanam" || m.SessionType != "virtual" || m.Source != SourceWhatsApp {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+}
+
+const sampleChat = `15/01/2024, 18:00 - Messages and calls are end-to-end encrypted.
+15/01/2024, 18:30 - Lakshmi: AUD-20240105-WA0003.opus (file attached)
+Vatapi Ganapatim
+15/01/2024, 18:45 - Ravi: Thank you!
+`
+
+func writeChat(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "WhatsApp Chat with Veena Class.txt")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseChat(t *testing.T) {
+	chat, err := ParseChat(writeChat(t, t.TempDir(), sampleChat))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chat.Name != "Veena Class" {
+		t.Errorf("expected chat name Veena Class, got %q", chat.Name)
+	}
+	if len(chat.Messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(chat.Messages))
+	}
+	att := chat.Messages[1]
+	if att.Sender != "Lakshmi" || att.Attachment != "AUD-20240105-WA0003.opus" || att.Text != "Vatapi Ganapatim" {
+		t.Errorf("unexpected attachment message: %+v", att)
+	}
+	if !chat.Messages[0].IsSystem() {
+		t.Errorf("expected first message to be a system message")
+	}
+	if att.Time.Day() != 15 || att.Time.Month() != 1 || att.Time.Hour() != 18 {
+		t.Errorf("unexpected timestamp %v", att.Time)
+	}
+}
+
+func TestStateDelta_Incremental(t *testing.T) {
+	dir := t.TempDir()
+	state, err := LoadState(filepath.Join(dir, "state", "chats.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	chat, delta, err := ParseChatSince(writeChat(t, dir, sampleChat), state, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(delta.New) != 3 {
+		t.Fatalf("expected every message to be new on the first run, got %d", len(delta.New))
+	}
+	state.Commit(chat)
+	if err := state.Save(); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+
+	state, err = LoadState(filepath.Join(dir, "state", "chats.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next := sampleChat + "22/01/2024, 18:30 - Lakshmi: AUD-20240112-WA0001.opus (file attached)\n"
+	_, delta, err = ParseChatSince(writeChat(t, dir, next), state, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(delta.New) != 1 || delta.New[0].Attachment != "AUD-20240112-WA0001.opus" {
+		t.Errorf("expected only the new attachment, got %+v", delta.New)
+	}
+	if len(delta.Changes) != 0 {
+		t.Errorf("expected no changes, got %v", delta.Changes)
+	}
+}
+
+func TestStateDelta_RetroactiveChanges(t *testing.T) {
+	dir := t.TempDir()
+	state, _ := LoadState(filepath.Join(dir, "chats.json"))
+	chat, _ := ParseChat(writeChat(t, dir, sampleChat))
+	state.Commit(chat)
+
+	edited := strings.Replace(sampleChat, "Thank you!", "This message was deleted", 1)
+	_, delta, err := ParseChatSince(writeChat(t, dir, edited), state, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(delta.New) != 0 {
+		t.Errorf("expected no new messages, got %d", len(delta.New))
+	}
+	if len(delta.Changes) != 1 || delta.Changes[0].Kind != ChangeDeleted {
+		t.Errorf("expected one deletion, got %v", delta.Changes)
+	}
+}
+
+func TestStateDelta_DeletedLastMessage(t *testing.T) {
+	dir := t.TempDir()
+	state, _ := LoadState(filepath.Join(dir, "chats.json"))
+	chat, _ := ParseChat(writeChat(t, dir, sampleChat))
+	state.Commit(chat)
+
+	// The last processed message is gone and another follows.
+	next := strings.Replace(sampleChat, "15/01/2024, 18:45 - Ravi: Thank you!\n", "", 1) +
+		"22/01/2024, 18:30 - Lakshmi: AUD-20240112-WA0001.opus (file attached)\n"
+	_, delta, err := ParseChatSince(writeChat(t, dir, next), state, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(delta.New) != 1 || delta.New[0].Attachment != "AUD-20240112-WA0001.opus" {
+		t.Errorf("expected the new attachment, got %+v", delta.New)
+	}
+	if len(delta.Changes) != 1 || delta.Changes[0].Kind != ChangeDeleted {
+		t.Errorf("expected one deletion, got %v", delta.Changes)
+	}
+
+	// Of two checkpoints with the chat's name, the one updated last is used.
+	other := &Checkpoint{ChatName: chat.Name, UpdatedAt: time.Now().Add(-time.Hour)}
+	for _, key := range []string{"a", "z"} {
+		state.Chats[key] = other
+	}
+	state.Chats[chat.ID()].UpdatedAt = time.Now()
+	edited := strings.Replace(sampleChat, "Messages and calls", "Messages", 1)
+	renamed, _ := ParseChat(writeChat(t, dir, edited))
+	if key, _ := state.lookup(renamed); key != chat.ID() {
+		t.Errorf("expected the latest checkpoint, got %q", key)
+	}
+}
+
+func TestStateDelta_RepeatedMessages(t *testing.T) {
+	dir := t.TempDir()
+	state, _ := LoadState(filepath.Join(dir, "chats.json"))
+	media := "22/01/2024, 18:30 - Lakshmi: <Media omitted>\n"
+	chat, _ := ParseChat(writeChat(t, dir, sampleChat+media+media))
+	state.Commit(chat)
+
+	// Identical messages share a fingerprint; only the third one is new.
+	_, delta, err := ParseChatSince(writeChat(t, dir, sampleChat+media+media+media), state, Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(delta.New) != 1 || delta.New[0].Line != chat.Messages[len(chat.Messages)-1].Line+1 {
+		t.Errorf("expected one new message, got %+v", delta.New)
+	}
+	if len(delta.Changes) != 0 {
+		t.Errorf("expected no changes, got %v", delta.Changes)
+	}
+}
+
+func TestParseChat_GroupTitles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "WhatsApp Chat with Veena Class – Saturday Batch.txt")
+	os.WriteFile(path, []byte(`01/06/2023, 10:00 - Lakshmi created group "Veena Class"
+13/06/2023, 10:01 - Lakshmi changed the subject from "Veena Class" to "Veena Class: Beginners"
+02/09/2023, 09:00 - Lakshmi changed the subject from "Veena Class: Beginners" to "Veena Class – Saturday Batch"
+15/01/2024, 18:30 - Lakshmi: Practice this week
+`), 0644)
+
+	chat, err := ParseChat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"Veena Class", "Veena Class: Beginners", "Veena Class – Saturday Batch"}
+	if strings.Join(chat.Titles, "|") != strings.Join(want, "|") {
+		t.Errorf("expected titles %q, got %q", want, chat.Titles)
+	}
+	if chat.Title != "Veena Class – Saturday Batch" {
+		t.Errorf("unexpected title %q", chat.Title)
+	}
+	if !chat.Messages[1].IsSystem() {
+		t.Errorf("expected subject change to be a system message, got sender %q", chat.Messages[1].Sender)
+	}
+}
+
+func TestParseChat_IOSTitleFromHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "_chat.txt")
+	os.WriteFile(path, []byte("[15/01/2024, 18:00:00] Veena Class: ‎Lakshmi created group “Veena Class”\n"+
+		"[15/01/2024, 18:30:12] Lakshmi: ‎<attached: 00000012-AUDIO-2024-01-05-18-30-12.opus>\n"), 0644)
+
+	chat, err := ParseChat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chat.Name != "Veena Class" || chat.Title != "Veena Class" {
+		t.Errorf("expected name and title from header, got %q / %q", chat.Name, chat.Title)
+	}
+	if !chat.Messages[0].IsSystem() {
+		t.Errorf("expected group notification to be a system message")
+	}
+	if chat.Messages[1].Attachment != "00000012-AUDIO-2024-01-05-18-30-12.opus" {
+		t.Errorf("unexpected attachment %q", chat.Messages[1].Attachment)
+	}
+}
+
+func TestParseChat_DateFormatDetection(t *testing.T) {
+	ist := time.FixedZone("IST", 5*3600+1800)
+	cases := []struct {
+		name string
+		body string
+		opts Options
+		want time.Time
+	}{
+		{"dmy 24h", "05/01/24, 18:30 - A: hi\n13/01/24, 09:00 - A: bye\n", Options{Location: ist}, time.Date(2024, 1, 5, 18, 30, 0, 0, ist)},
+		{"mdy 12h", "1/5/24, 6:30 PM - A: hi\n1/13/24, 9:00 AM - A: bye\n", Options{Location: ist}, time.Date(2024, 1, 5, 18, 30, 0, 0, ist)},
+		{"iso", "[2024-01-05 18:30:12] A: hi\n", Options{Location: ist}, time.Date(2024, 1, 5, 18, 30, 12, 0, ist)},
+		{"localized marker", "05/01/24, 6:30 अपराह्न - A: hi\n13/01/24, 9:00 पूर्वाह्न - A: bye\n", Options{Location: ist}, time.Date(2024, 1, 5, 18, 30, 0, 0, ist)},
+		{"dotted marker", "05/01/24, 6:30 p.m. - A: hi\n13/01/24, 9:00 a.m. - A: bye\n", Options{Location: ist}, time.Date(2024, 1, 5, 18, 30, 0, 0, ist)},
+		{"devanagari digits", "०५/०१/२४, १८:३० - A: hi\n१३/०१/२४, ०९:०० - A: bye\n", Options{Location: ist}, time.Date(2024, 1, 5, 18, 30, 0, 0, ist)},
+		{"override", "05/01/24, 18:30 - A: hi\n", Options{DateOrder: MonthDayYear, Location: ist}, time.Date(2024, 5, 1, 18, 30, 0, 0, ist)},
+		{"chronology", "05/01/24, 18:30 - A: hi\n06/01/24, 18:30 - A: hi\n01/02/24, 18:30 - A: hi\n", Options{Location: time.UTC}, time.Date(2024, 1, 5, 18, 30, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		path := filepath.Join(t.TempDir(), "chat.txt")
+		os.WriteFile(path, []byte(c.body), 0644)
+		chat, err := ParseChatWith(path, c.opts)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if got := chat.Messages[0].Time; !got.Equal(c.want) {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestParseChat_AmbiguousDates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.txt")
+	os.WriteFile(path, []byte("05/01/24, 18:30 - A: hi\n06/02/24, 18:30 - A: hi\n"), 0644)
+	_, err := ParseChat(path)
+	if !errors.Is(err, ErrAmbiguousDates) {
+		t.Errorf("expected ErrAmbiguousDates, got %v", err)
+	}
+}
+
+func TestParseDateOrder(t *testing.T) {
+	for in, want := range map[string]DateOrder{"dd/mm/yy": DayMonthYear, "MM/DD/YYYY": MonthDayYear, "yyyy-mm-dd": YearMonthDay, "dmy": DayMonthYear} {
+		if got, err := ParseDateOrder(in); err != nil || got != want {
+			t.Errorf("ParseDateOrder(%q) = %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseDateOrder("yy.dd.mm"); err == nil {
+		t.Error("expected error for unsupported order")
+	}
+}
+
+func TestMissingRecordings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.txt")
+	os.WriteFile(path, []byte(`15/01/2024, 18:30 - Lakshmi: <Media omitted>
+15/01/2024, 18:31 - Lakshmi: Today's class - Vatapi Ganapatim
+15/01/2024, 18:40 - Ravi: <Media omitted>
+15/01/2024, 18:41 - Ravi: Nice photo!
+[15/01/2024, 19:00:00] Meena: audio omitted
+15/01/2024, 19:10 - Lakshmi: AUD-20240115-WA0004.opus (file attached)
+Varnam practice
+15/01/2024, 19:20 - Lakshmi: IMG-20240115-WA0005.jpg (file attached)
+`), 0644)
+	chat, err := ParseChat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	missing := MissingRecordings(chat.Title, chat.Messages, func(string) bool { return false })
+	if len(missing) != 3 {
+		t.Fatalf("expected 3 missing recordings, got %+v", missing)
+	}
+	if missing[0].Sender != "Lakshmi" || missing[0].Kind != "media" || missing[0].Caption != "Today's class - Vatapi Ganapatim" {
+		t.Errorf("unexpected first entry: %+v", missing[0])
+	}
+	if missing[1].Sender != "Meena" || missing[1].Kind != "audio" {
+		t.Errorf("unexpected second entry: %+v", missing[1])
+	}
+	if missing[2].File != "AUD-20240115-WA0004.opus" || missing[2].Caption != "Varnam practice" {
+		t.Errorf("unexpected third entry: %+v", missing[2])
+	}
+
+	var buf strings.Builder
+	if err := WriteMissingReport(&buf, missing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "2024-01-15 18:30, media, sent by Lakshmi: Today's class - Vatapi Ganapatim") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
+
+const telegramSample = `{
+ "name": "Veena Class",
+ "type": "private_supergroup",
+ "id": 1234,
+ "messages": [
+  {"id": 1, "type": "service", "date": "2024-01-15T18:00:00", "date_unixtime": "1705321800", "actor": "Lakshmi", "actor_id": "user42", "action": "create_group", "title": "Veena Beginners", "text": ""},
+  {"id": 2, "type": "service", "date": "2024-01-15T18:01:00", "date_unixtime": "1705321860", "actor": "Lakshmi", "actor_id": "user42", "action": "edit_group_title", "title": "Veena Class", "text": ""},
+  {"id": 3, "type": "message", "date": "2024-01-15T18:30:12", "date_unixtime": "1705323612", "from": "Lakshmi", "from_id": "user42", "file": "voice_messages/audio_1@15-01-2024_18-30-12.ogg", "media_type": "voice_message", "mime_type": "audio/ogg", "text": ""},
+  {"id": 4, "type": "message", "date": "2024-01-15T18:31:00", "date_unixtime": "1705323660", "from": "Ravi", "from_id": "user7", "text": "Thanks!"},
+  {"id": 5, "type": "message", "date": "2024-01-15T18:32:00", "date_unixtime": "1705323720", "from": "Lakshmi", "from_id": "user42", "reply_to_message_id": 3, "text": ["Vatapi ", {"type": "bold", "text": "Ganapatim"}]},
+  {"id": 6, "type": "message", "date": "2024-01-15T19:00:00", "date_unixtime": "1705325400", "from": "Meena", "from_id": "user9", "file": "(File not included. Change data exporting settings to download.)", "media_type": "video_file", "mime_type": "video/mp4", "text": "Practice"}
+ ]
+}`
+
+func TestParseTelegramExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	os.WriteFile(path, []byte(telegramSample), 0644)
+
+	chat, err := ParseTelegramExport(path, Options{Location: time.UTC})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if chat.Source != SourceTelegram || chat.Title != "Veena Class" || len(chat.Titles) != 2 {
+		t.Errorf("unexpected chat: source %q title %q titles %q", chat.Source, chat.Title, chat.Titles)
+	}
+	if len(chat.Messages) != 6 || !chat.Messages[0].IsSystem() {
+		t.Fatalf("unexpected messages: %+v", chat.Messages)
+	}
+	voice := chat.Messages[2]
+	if voice.Attachment != "voice_messages/audio_1@15-01-2024_18-30-12.ogg" || voice.SenderID != "user42" {
+		t.Errorf("unexpected voice message: %+v", voice)
+	}
+	if !voice.Time.Equal(time.Date(2024, 1, 15, 13, 0, 12, 0, time.UTC)) {
+		t.Errorf("unexpected time %v", voice.Time)
+	}
+	if got := Caption(chat.Messages, 2); got != "Vatapi Ganapatim" {
+		t.Errorf("expected caption from reply, got %q", got)
+	}
+	missing := MissingRecordings(chat.Title, chat.Messages, nil)
+	if len(missing) != 1 || missing[0].Sender != "Meena" || missing[0].Kind != "video" || missing[0].Caption != "Practice" {
+		t.Errorf("unexpected missing recordings: %+v", missing)
+	}
+}
@@ -0,0 +1,286 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Checkpoint records how far a chat has been processed.
+type Checkpoint struct {
+	ChatName     string    `json:"chat_name"`
+	Fingerprints []string  `json:"fingerprints"` // every processed message, in export order
+	LastMessage  time.Time `json:"last_message"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// ChangeKind describes how an already processed message differs in a later export.
+type ChangeKind string
+
+const (
+	ChangeEdited   ChangeKind = "edited"
+	ChangeDeleted  ChangeKind = "deleted"
+	ChangeInserted ChangeKind = "inserted" // appeared between messages that were already processed
+)
+
+// Change is a retroactive edit or deletion detected in a re-exported chat.
+type Change struct {
+	Kind        ChangeKind
+	Fingerprint string   // fingerprint of the processed message, empty for insertions
+	Message     *Message // the message as it appears now, nil for deletions
+}
+
+func (c Change) String() string {
+	if c.Message == nil {
+		return fmt.Sprintf("%s message %s", c.Kind, c.Fingerprint)
+	}
+	return fmt.Sprintf("%s message at line %d (%s, %s)", c.Kind, c.Message.Line, c.Message.Sender, c.Message.Time.Format("2006-01-02 15:04"))
+}
+
+// Delta is the part of a chat export that has not been processed yet.
+type Delta struct {
+	New     []Message // messages appended since the previous run
+	Changes []Change  // retroactive edits and deletions; these are reported, never reprocessed
+}
+
+// State holds the checkpoints of every chat processed so far, keyed by Chat.ID,
+// and the other sources (such as Zoom meetings) that were uploaded whole.
+type State struct {
+	path      string
+	Chats     map[string]*Checkpoint `json:"chats"`
+	Processed map[string]time.Time   `json:"processed,omitempty"`
+}
+
+// LoadState reads the processing state from path. A missing file yields an
+// empty state that will be created on Save.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, Chats: map[string]*Checkpoint{}}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read chat state: %v", err)
+	}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("unable to parse chat state %s: %v", path, err)
+	}
+	if s.Chats == nil {
+		s.Chats = map[string]*Checkpoint{}
+	}
+	return s, nil
+}
+
+// Save writes the state back to the file it was loaded from.
+func (s *State) Save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Delta compares chat with its checkpoint and returns the messages added since
+// the previous run together with any retroactive changes. The new messages
+// are those after the last processed message still in the export, so a
+// processed message that was deleted does not hide the ones that follow it.
+func (s *State) Delta(chat *Chat) Delta {
+	_, cp := s.lookup(chat)
+	if cp == nil {
+		return Delta{New: chat.Messages}
+	}
+
+	// The anchor is the last processed message the export still holds.
+	// Identical messages (two "<Media omitted>" in the same minute) share a
+	// fingerprint, so the anchor is the same occurrence of it in both.
+	done := cp.Fingerprints
+	lastDone, lastMsg := -1, -1
+	for k := len(done) - 1; k >= 0 && lastDone < 0; k-- {
+		if m := nthMessage(chat.Messages, done[k], occurrence(done, k)); m >= 0 {
+			lastDone, lastMsg = k, m
+		}
+	}
+
+	var d Delta
+	done, old := done[:lastDone+1], chat.Messages[:lastMsg+1]
+	i, j := 0, 0
+	for i < len(done) && j < len(old) {
+		msg := &old[j]
+		fp := msg.Fingerprint()
+		if fp == done[i] {
+			i++
+			j++
+			continue
+		}
+		if k := indexFrom(done, fp, i+1); k >= 0 {
+			for _, gone := range done[i:k] {
+				d.Changes = append(d.Changes, Change{Kind: ChangeDeleted, Fingerprint: gone})
+			}
+			i = k
+			continue
+		}
+		if k := indexOfMessage(old, done[i], j+1); k >= 0 {
+			for n := j; n < k; n++ {
+				d.Changes = append(d.Changes, Change{Kind: ChangeInserted, Message: &old[n]})
+			}
+			j = k
+			continue
+		}
+		kind := ChangeEdited
+		if isDeletionNotice(msg.Text) {
+			kind = ChangeDeleted
+		}
+		d.Changes = append(d.Changes, Change{Kind: kind, Fingerprint: done[i], Message: msg})
+		i++
+		j++
+	}
+	for _, gone := range done[i:] {
+		d.Changes = append(d.Changes, Change{Kind: ChangeDeleted, Fingerprint: gone})
+	}
+
+	// Processed messages after the anchor are gone. WhatsApp may leave a
+	// notice in the place of each; those notices are not new messages.
+	d.New = chat.Messages[lastMsg+1:]
+	for _, gone := range cp.Fingerprints[lastDone+1:] {
+		c := Change{Kind: ChangeDeleted, Fingerprint: gone}
+		if len(d.New) > 0 && isDeletionNotice(d.New[0].Text) {
+			c.Message = &d.New[0]
+			d.New = d.New[1:]
+		}
+		d.Changes = append(d.Changes, c)
+	}
+	return d
+}
+
+// Commit marks every message of chat as processed. The messages marked
+// done one by one are then covered by the checkpoint and unmarked.
+func (s *State) Commit(chat *Chat) {
+	if len(chat.Messages) == 0 {
+		return
+	}
+	for _, m := range chat.Messages {
+		delete(s.Processed, m.Key())
+	}
+	if key, _ := s.lookup(chat); key != "" {
+		delete(s.Chats, key)
+	}
+	fps := make([]string, len(chat.Messages))
+	for i, m := range chat.Messages {
+		fps[i] = m.Fingerprint()
+	}
+	s.Chats[chat.ID()] = &Checkpoint{
+		ChatName:     chat.Name,
+		Fingerprints: fps,
+		LastMessage:  chat.Messages[len(chat.Messages)-1].Time,
+		UpdatedAt:    time.Now(),
+	}
+}
+
+// Done reports whether the source identified by key was processed before.
+func (s *State) Done(key string) bool {
+	_, ok := s.Processed[key]
+	return ok
+}
+
+// MarkDone records that the source identified by key was processed.
+func (s *State) MarkDone(key string) {
+	if s.Processed == nil {
+		s.Processed = map[string]time.Time{}
+	}
+	s.Processed[key] = time.Now()
+}
+
+// lookup finds the checkpoint for chat. When the first message itself was
+// edited or deleted the chat ID changes, so it falls back to the chat name;
+// of several chats with that name, the one updated last is taken.
+func (s *State) lookup(chat *Chat) (string, *Checkpoint) {
+	if cp, ok := s.Chats[chat.ID()]; ok {
+		return chat.ID(), cp
+	}
+	keys := make([]string, 0, len(s.Chats))
+	for key := range s.Chats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	found := ""
+	for _, key := range keys {
+		if cp := s.Chats[key]; cp.ChatName == chat.Name && (found == "" || cp.UpdatedAt.After(s.Chats[found].UpdatedAt)) {
+			found = key
+		}
+	}
+	if found == "" {
+		return "", nil
+	}
+	return found, s.Chats[found]
+}
+
+// ParseChatSince parses the export at filePath and returns only what was added
+// since the chat was last committed to state.
+func ParseChatSince(filePath string, state *State, opts Options) (*Chat, Delta, error) {
+	chat, err := ParseChatWith(filePath, opts)
+	if err != nil {
+		return nil, Delta{}, err
+	}
+	return chat, state.Delta(chat), nil
+}
+
+func indexFrom(list []string, value string, from int) int {
+	for k := from; k < len(list); k++ {
+		if list[k] == value {
+			return k
+		}
+	}
+	return -1
+}
+
+// occurrence counts the entries of list before k that equal list[k].
+func occurrence(list []string, k int) int {
+	n := 0
+	for _, v := range list[:k] {
+		if v == list[k] {
+			n++
+		}
+	}
+	return n
+}
+
+// nthMessage returns the index of the nth (from 0) message with the given
+// fingerprint, or -1 when there are fewer.
+func nthMessage(messages []Message, fingerprint string, n int) int {
+	for k := range messages {
+		if messages[k].Fingerprint() == fingerprint {
+			if n == 0 {
+				return k
+			}
+			n--
+		}
+	}
+	return -1
+}
+
+func indexOfMessage(messages []Message, fingerprint string, from int) int {
+	for k := from; k < len(messages); k++ {
+		if messages[k].Fingerprint() == fingerprint {
+			return k
+		}
+	}
+	return -1
+}
+
+// isDeletionNotice reports whether text is the placeholder WhatsApp leaves
+// behind for a deleted message.
+func isDeletionNotice(text string) bool {
+	text = strings.TrimSpace(text)
+	return text == "This message was deleted" || text == "You deleted this message"
+}
@@ -0,0 +1,154 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramExport is the part of a Telegram Desktop result.json we use.
+type telegramExport struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	ID       int64             `json:"id"`
+	Messages []telegramMessage `json:"messages"`
+}
+
+type telegramMessage struct {
+	ID           int64           `json:"id"`
+	Type         string          `json:"type"` // "message" or "service"
+	Date         string          `json:"date"`
+	DateUnix     string          `json:"date_unixtime"`
+	From         string          `json:"from"`
+	FromID       string          `json:"from_id"`
+	Actor        string          `json:"actor"`
+	Action       string          `json:"action"`
+	Title        string          `json:"title"`
+	Text         json.RawMessage `json:"text"`
+	File         string          `json:"file"`
+	MediaType    string          `json:"media_type"`
+	MimeType     string          `json:"mime_type"`
+	Photo        string          `json:"photo"`
+	ReplyTo      int64           `json:"reply_to_message_id"`
+	DurationSecs int             `json:"duration_seconds"`
+}
+
+// telegramNotIncluded marks files Telegram Desktop left out of the export.
+const telegramNotIncluded = "(File not included."
+
+// ParseTelegramExport reads a Telegram Desktop JSON export (result.json) into
+// the same chat model as WhatsApp exports. Attachment paths are relative to
+// the directory holding result.json.
+func ParseTelegramExport(filePath string, opts Options) (*Chat, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var export telegramExport
+	if err := json.Unmarshal(b, &export); err != nil {
+		return nil, fmt.Errorf("unable to parse Telegram export %s: %v", filePath, err)
+	}
+
+	chat := &Chat{Name: export.Name, Path: filePath, Source: SourceTelegram}
+	var titles []string
+	for i, tm := range export.Messages {
+		ts, err := tm.time(opts.location())
+		if err != nil {
+			return nil, fmt.Errorf("%s: message %d: %v", filePath, tm.ID, err)
+		}
+		msg := Message{ID: tm.ID, ReplyTo: tm.ReplyTo, Time: ts, Text: telegramText(tm.Text), Line: i + 1}
+		if tm.Type == "service" {
+			if tm.Title != "" && (tm.Action == "create_group" || tm.Action == "create_channel" || tm.Action == "edit_group_title" || tm.Action == "migrate_from_group") {
+				titles = append(titles, tm.Title)
+			}
+			if msg.Text == "" {
+				msg.Text = strings.TrimSpace(tm.Actor + " " + strings.ReplaceAll(tm.Action, "_", " "))
+			}
+			chat.Messages = append(chat.Messages, msg)
+			continue
+		}
+		msg.Sender, msg.SenderID = tm.From, tm.FromID
+		file := tm.File
+		if file == "" {
+			file = tm.Photo
+		}
+		if strings.HasPrefix(file, telegramNotIncluded) {
+			msg.Omitted = tm.mediaKind()
+		} else if file != "" {
+			msg.Attachment = file
+		}
+		chat.Messages = append(chat.Messages, msg)
+	}
+
+	chat.Titles = uniqueTitles(append(titles, chat.Name))
+	if n := len(chat.Titles); n > 0 {
+		chat.Title = chat.Titles[n-1]
+	}
+	return chat, nil
+}
+
+func (tm telegramMessage) time(loc *time.Location) (time.Time, error) {
+	if tm.DateUnix != "" {
+		sec, err := strconv.ParseInt(tm.DateUnix, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date_unixtime %q", tm.DateUnix)
+		}
+		return time.Unix(sec, 0).In(loc), nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05", tm.Date, loc)
+}
+
+// telegramText flattens Telegram's text field, which is either a string or a
+// list of strings and formatted entities.
+func telegramText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, p := range parts {
+		var str string
+		if err := json.Unmarshal(p, &str); err == nil {
+			b.WriteString(str)
+			continue
+		}
+		var entity struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(p, &entity); err == nil {
+			b.WriteString(entity.Text)
+		}
+	}
+	return b.String()
+}
+
+// mediaKind maps Telegram's media_type to the kinds used for omitted media.
+func (tm telegramMessage) mediaKind() string {
+	switch tm.MediaType {
+	case "voice_message", "audio_file":
+		return "audio"
+	case "video_file", "video_message":
+		return "video"
+	case "sticker", "animation":
+		return tm.MediaType
+	}
+	switch {
+	case tm.File == "" && tm.Photo != "":
+		return "image"
+	case strings.HasPrefix(tm.MimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(tm.MimeType, "video/"):
+		return "video"
+	}
+	return "media"
+}
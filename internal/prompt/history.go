@@ -0,0 +1,113 @@
+package prompt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"musicloud/internal/metadata"
+)
+
+// History remembers the values entered in earlier sessions, for
+// autocompletion and pre-filling.
+type History struct {
+	path string
+	// Values counts how often each value was used, per field.
+	Values map[string]map[string]int `json:"values"`
+	// Last is the most recent entry per group.
+	Last      map[string]metadata.Metadata `json:"last"`
+	UpdatedAt time.Time                    `json:"updated_at"`
+	// Version is the metadata schema version of Last.
+	Version int `json:"version"`
+}
+
+// LoadHistory reads the history from path; a missing file yields an empty history.
+func LoadHistory(path string) (*History, error) {
+	h := &History{path: path}
+	b, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read metadata history: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, h); err != nil {
+			return nil, fmt.Errorf("unable to parse metadata history %s: %v", path, err)
+		}
+	}
+	if h.Values == nil {
+		h.Values = map[string]map[string]int{}
+	}
+	if h.Last == nil {
+		h.Last = map[string]metadata.Metadata{}
+	}
+	for group, m := range h.Last {
+		m, err := metadata.Migrate(m, h.Version)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read metadata history %s: %v", path, err)
+		}
+		h.Last[group] = m
+	}
+	h.Version = metadata.SchemaVersion
+	return h, nil
+}
+
+// Save writes the history back to the file it was loaded from.
+func (h *History) Save() error {
+	if h.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	h.UpdatedAt = time.Now()
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.path, b, 0644)
+}
+
+// Record adds a confirmed entry to the history.
+func (h *History) Record(m metadata.Metadata) {
+	add := func(field string, values ...string) {
+		for _, v := range values {
+			if v == "" {
+				continue
+			}
+			if h.Values[field] == nil {
+				h.Values[field] = map[string]int{}
+			}
+			h.Values[field][v]++
+		}
+	}
+	add("group", m.GroupName)
+	add("teacher", m.Teacher)
+	add("songs", m.SongsTaught...)
+	add("ragas", m.Ragas...)
+	add("talas", m.Talas...)
+	add("composers", m.Composers...)
+	h.Last[m.GroupName] = m
+}
+
+// Complete returns the known values of field that start with prefix,
+// ignoring case, most used first.
+func (h *History) Complete(field, prefix string) []string {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	var out []string
+	for v := range h.Values[field] {
+		if strings.HasPrefix(strings.ToLower(v), prefix) {
+			out = append(out, v)
+		}
+	}
+	counts := h.Values[field]
+	sort.Slice(out, func(i, j int) bool {
+		if counts[out[i]] != counts[out[j]] {
+			return counts[out[i]] > counts[out[j]]
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
@@ -0,0 +1,298 @@
+// Package prompt asks a person to confirm or complete the metadata of each
+// new recording before it is uploaded.
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"musicloud/internal/metadata"
+)
+
+// Answers with a special meaning at any question.
+const (
+	samePrevious = "="    // reuse this field from the previous recording
+	sameAll      = "=="   // reuse all remaining fields from the previous recording
+	clearValue   = "-"    // leave the field empty
+	skipFile     = "skip" // do not upload this recording
+)
+
+// ErrNoInput is returned when the input ends before a recording is
+// confirmed: with nobody answering, nothing is uploaded unconfirmed.
+var ErrNoInput = errors.New("input ended before the recording was confirmed")
+
+// Session is one interactive run over a batch of recordings.
+type Session struct {
+	in      *bufio.Reader
+	out     io.Writer
+	History *History
+	// Preview starts playing the beginning of a recording and returns a
+	// function that stops it. Nil disables previews.
+	Preview func(path string) (stop func())
+
+	previous *metadata.Metadata
+}
+
+// NewSession reads answers from in and writes questions to out. history may
+// be nil.
+func NewSession(in io.Reader, out io.Writer, history *History) *Session {
+	if history == nil {
+		history, _ = LoadHistory("")
+	}
+	return &Session{in: bufio.NewReader(in), out: out, History: history}
+}
+
+// field is one question of the prompt.
+type field struct {
+	name     string // history key
+	label    string
+	list     bool
+	complete bool // expand unique prefixes of known values
+	single   *string
+	values   *[]string
+}
+
+// Ask shows a recording and asks for each metadata field, pre-filled with
+// meta. ok is false when the person chose to skip the recording.
+func (s *Session) Ask(path, caption string, meta metadata.Metadata) (metadata.Metadata, bool, error) {
+	fmt.Fprintf(s.out, "\n== %s ==\n", filepath.Base(path))
+	if caption != "" {
+		fmt.Fprintf(s.out, "Caption: %s\n", strings.ReplaceAll(caption, "\n", " "))
+	}
+	fmt.Fprintln(s.out, "Enter keeps [value], '=' reuses the previous recording's value, '==' all of them, '-' clears, 'skip' leaves the file out, 'prefix?' lists known values.")
+	if s.Preview != nil {
+		if stop := s.Preview(path); stop != nil {
+			defer stop()
+		}
+	}
+
+	m := s.prefill(meta)
+	fields := []field{
+		{name: "group", label: "Group", single: &m.GroupName},
+		{name: "teacher", label: "Teacher", single: &m.Teacher},
+		{name: "session_type", label: "Session type", single: &m.SessionType},
+		{name: "songs", label: "Songs", list: true, values: &m.SongsTaught},
+		{name: "ragas", label: "Ragas", list: true, complete: true, values: &m.Ragas},
+		{name: "talas", label: "Talas", list: true, complete: true, values: &m.Talas},
+		{name: "composers", label: "Composers", list: true, complete: true, values: &m.Composers},
+	}
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		answer, err := s.question(f)
+		if err != nil {
+			return metadata.Metadata{}, false, err
+		}
+		switch answer {
+		case "":
+			continue
+		case skipFile:
+			return metadata.Metadata{}, false, nil
+		case sameAll:
+			if s.previous == nil {
+				fmt.Fprintln(s.out, "  no previous recording")
+				i--
+				continue
+			}
+			for _, rest := range fields[i:] {
+				copyField(rest, *s.previous)
+			}
+			i = len(fields)
+			continue
+		case samePrevious:
+			if s.previous == nil {
+				fmt.Fprintln(s.out, "  no previous recording")
+				i--
+				continue
+			}
+			copyField(f, *s.previous)
+			continue
+		case clearValue:
+			if f.list {
+				*f.values = nil
+			} else {
+				*f.single = ""
+			}
+			continue
+		}
+		if !s.set(f, answer) {
+			i--
+		}
+	}
+
+	s.previous = &m
+	s.History.Record(m)
+	if err := s.History.Save(); err != nil {
+		fmt.Fprintf(s.out, "  unable to save history: %v\n", err)
+	}
+	return m, true, nil
+}
+
+// prefill completes meta with the values last used for the same group that
+// rarely change between recordings.
+func (s *Session) prefill(meta metadata.Metadata) metadata.Metadata {
+	last, ok := s.History.Last[meta.GroupName]
+	if !ok || meta.GroupName == "" {
+		return meta
+	}
+	if meta.Teacher == "" {
+		meta.Teacher = last.Teacher
+	}
+	if meta.SessionType == "" {
+		meta.SessionType = last.SessionType
+	}
+	return meta
+}
+
+// question asks for one field until the answer is not a listing request.
+func (s *Session) question(f field) (string, error) {
+	for {
+		current := ""
+		if f.list {
+			current = strings.Join(*f.values, ", ")
+		} else {
+			current = *f.single
+		}
+		fmt.Fprintf(s.out, "%s [%s]: ", f.label, current)
+		line, err := s.in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if err == io.EOF && line == "" {
+			fmt.Fprintln(s.out)
+			return "", ErrNoInput
+		}
+		answer := strings.TrimSpace(line)
+		if strings.HasSuffix(answer, "?") {
+			s.list(f.name, strings.TrimSuffix(answer, "?"))
+			continue
+		}
+		return answer, nil
+	}
+}
+
+// list prints the known values of a field that start with prefix.
+func (s *Session) list(name, prefix string) {
+	matches := s.History.Complete(name, prefix)
+	if len(matches) == 0 {
+		fmt.Fprintln(s.out, "  no known values")
+		return
+	}
+	for i, v := range matches {
+		if i == 10 {
+			fmt.Fprintf(s.out, "  ... %d more\n", len(matches)-i)
+			break
+		}
+		fmt.Fprintf(s.out, "  %s\n", v)
+	}
+}
+
+// set stores an answer in its field; it returns false when the answer is
+// invalid or ambiguous and the question must be asked again.
+func (s *Session) set(f field, answer string) bool {
+	if !f.list {
+		if f.name == "session_type" {
+			st, err := metadata.NormalizeSessionType(answer)
+			if err != nil {
+				fmt.Fprintf(s.out, "  %v\n", err)
+				return false
+			}
+			answer = st
+		}
+		*f.single = s.known(f.name, answer)
+		return true
+	}
+
+	var values []string
+	for _, v := range strings.Split(answer, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		if !f.complete {
+			values = append(values, s.known(f.name, v))
+			continue
+		}
+		full, ok := s.expand(f.name, v)
+		if !ok {
+			return false
+		}
+		values = append(values, full)
+	}
+	*f.values = values
+	return true
+}
+
+// known returns the spelling already in the history for a value that only
+// differs in case, so entries stay consistent.
+func (s *Session) known(name, v string) string {
+	for _, c := range s.History.Complete(name, v) {
+		if strings.EqualFold(c, v) {
+			return c
+		}
+	}
+	return v
+}
+
+// expand completes a prefix to the known value it uniquely starts. A value
+// ending in '!' is taken as typed.
+func (s *Session) expand(name, v string) (string, bool) {
+	if strings.HasSuffix(v, "!") {
+		return strings.TrimSpace(strings.TrimSuffix(v, "!")), true
+	}
+	matches := s.History.Complete(name, v)
+	for _, c := range matches {
+		if strings.EqualFold(c, v) {
+			return c, true
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return v, true
+	case 1:
+		fmt.Fprintf(s.out, "  %s -> %s\n", v, matches[0])
+		return matches[0], true
+	}
+	fmt.Fprintf(s.out, "  %q matches %s; type more, or end it with '!' to keep it as typed\n", v, strings.Join(matches, ", "))
+	return "", false
+}
+
+func copyField(f field, from metadata.Metadata) {
+	src := map[string]interface{}{
+		"group":        from.GroupName,
+		"teacher":      from.Teacher,
+		"session_type": from.SessionType,
+		"songs":        from.SongsTaught,
+		"ragas":        from.Ragas,
+		"talas":        from.Talas,
+		"composers":    from.Composers,
+	}[f.name]
+	if f.list {
+		*f.values = append([]string(nil), src.([]string)...)
+	} else {
+		*f.single = src.(string)
+	}
+}
+
+// FFplayPreview returns a preview that plays the first seconds of a recording
+// with ffplay, or nil when ffplay is not installed.
+func FFplayPreview(seconds int) func(path string) func() {
+	bin, err := exec.LookPath("ffplay")
+	if err != nil {
+		return nil
+	}
+	return func(path string) func() {
+		cmd := exec.Command(bin, "-nodisp", "-autoexit", "-loglevel", "quiet", "-t", strconv.Itoa(seconds), path)
+		if err := cmd.Start(); err != nil {
+			return nil
+		}
+		return func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}
+}
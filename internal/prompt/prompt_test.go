@@ -0,0 +1,100 @@
+package prompt
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"musicloud/internal/metadata"
+)
+
+func TestAsk_PrefillAndComplete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.json")
+	h, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+	h.Record(metadata.Metadata{GroupName: "Thursday Batch", Teacher: "Lakshmi", SessionType: "virtual", Ragas: []string{"Hamsadhwani", "Hindolam"}, Composers: []string{"Tyagaraja"}})
+
+	// group, teacher, session type kept; songs typed; ragas completed from
+	// a prefix; talas kept; composers completed.
+	input := "\n\n\nVatapi Ganapatim\nhams\n\ntyag\n"
+	var out strings.Builder
+	s := NewSession(strings.NewReader(input), &out, h)
+	got, ok, err := s.Ask("class.m4a", "Vatapi today", metadata.Metadata{GroupName: "Thursday Batch"})
+	if err != nil || !ok {
+		t.Fatalf("Ask: ok=%v err=%v", ok, err)
+	}
+	want := metadata.Metadata{
+		GroupName:   "Thursday Batch",
+		Teacher:     "Lakshmi",
+		SessionType: "virtual",
+		SongsTaught: []string{"Vatapi Ganapatim"},
+		Ragas:       []string{"Hamsadhwani"},
+		Composers:   []string{"Tyagaraja"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ask = %+v, want %+v", got, want)
+	}
+	if !strings.Contains(out.String(), "Caption: Vatapi today") {
+		t.Errorf("caption not shown:\n%s", out.String())
+	}
+
+	reloaded, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+	if reloaded.Values["songs"]["Vatapi Ganapatim"] != 1 || reloaded.Values["ragas"]["Hamsadhwani"] != 2 {
+		t.Errorf("history not saved: %+v", reloaded.Values)
+	}
+}
+
+func TestAsk_AmbiguousAndSamePrevious(t *testing.T) {
+	h, _ := LoadHistory("")
+	h.Record(metadata.Metadata{Ragas: []string{"Kalyani", "Kambhoji"}})
+
+	// "ka" is ambiguous and asked again; "Kal" completes.
+	first := "Sunday\n\ninperson\nSong One\nka\nKal\nAdi\n\n"
+	// second recording reuses the group, clears the teacher and copies the rest.
+	second := "=\n-\n==\n"
+	var out strings.Builder
+	s := NewSession(strings.NewReader(first+second), &out, h)
+
+	m1, ok, err := s.Ask("one.m4a", "", metadata.Metadata{Teacher: "Ravi"})
+	if err != nil || !ok {
+		t.Fatalf("Ask: ok=%v err=%v", ok, err)
+	}
+	want := metadata.Metadata{GroupName: "Sunday", Teacher: "Ravi", SessionType: "in-person", SongsTaught: []string{"Song One"}, Ragas: []string{"Kalyani"}, Talas: []string{"Adi"}}
+	if !reflect.DeepEqual(m1, want) {
+		t.Errorf("first Ask = %+v, want %+v", m1, want)
+	}
+	if !strings.Contains(out.String(), `"ka" matches`) {
+		t.Errorf("ambiguous prefix not reported:\n%s", out.String())
+	}
+
+	m2, ok, err := s.Ask("two.m4a", "", metadata.Metadata{GroupName: "Other", Teacher: "Ravi"})
+	if err != nil || !ok {
+		t.Fatalf("Ask: ok=%v err=%v", ok, err)
+	}
+	want.Teacher = ""
+	if !reflect.DeepEqual(m2, want) {
+		t.Errorf("second Ask = %+v, want %+v", m2, want)
+	}
+}
+
+func TestAsk_Skip(t *testing.T) {
+	s := NewSession(strings.NewReader("skip\n"), &strings.Builder{}, nil)
+	_, ok, err := s.Ask("one.m4a", "", metadata.Metadata{})
+	if err != nil || ok {
+		t.Errorf("Ask = ok %v err %v, want skipped", ok, err)
+	}
+}
+
+func TestAsk_InputEnds(t *testing.T) {
+	// Input that ends part way is not taken as accepting the rest.
+	s := NewSession(strings.NewReader("Sunday\n"), &strings.Builder{}, nil)
+	if _, ok, err := s.Ask("one.m4a", "", metadata.Metadata{Teacher: "Ravi"}); err != ErrNoInput || ok {
+		t.Errorf("Ask = ok %v err %v, want ErrNoInput", ok, err)
+	}
+}
@@ -0,0 +1,128 @@
+// Package recdate works out when a recording was made, trying its sources
+// in a configurable order: the chat message it was shared with, a WhatsApp
+// or Zoom file name, the media container and the file's modification time.
+package recdate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"musicloud/internal/metadata"
+	"musicloud/internal/zoom"
+)
+
+// DefaultChain is the order the date sources are tried in by default.
+var DefaultChain = []string{metadata.DateFromChat, metadata.DateFromFileName, metadata.DateFromContainer, metadata.DateFromModTime}
+
+// ParseChain reads a comma-separated list of date sources, such as
+// "chat,filename,mtime". An empty string yields DefaultChain.
+func ParseChain(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultChain, nil
+	}
+	var chain []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, source := range DefaultChain {
+			known = known || name == source
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown date source %q (use %s)", name, strings.Join(DefaultChain, ", "))
+		}
+		chain = append(chain, name)
+	}
+	return chain, nil
+}
+
+// Resolver finds recording dates.
+type Resolver struct {
+	// Chain lists the sources to try, in order; nil means DefaultChain.
+	Chain []string
+	// Location is the time zone of dates in file names; nil means local time.
+	Location *time.Location
+	// Probe reads the creation time from a media container; nil skips that
+	// source.
+	Probe func(path string) (time.Time, error)
+}
+
+// Date returns when the recording at path was made and the source that
+// said so. message is the time of the chat message the file was shared
+// with, zero if there was none. The zero time is returned when no source
+// knows.
+func (r *Resolver) Date(path string, message time.Time) (time.Time, string) {
+	chain := r.Chain
+	if chain == nil {
+		chain = DefaultChain
+	}
+	loc := r.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	for _, source := range chain {
+		var t time.Time
+		switch source {
+		case metadata.DateFromChat:
+			t = message
+		case metadata.DateFromFileName:
+			t, _ = FromName(path, loc)
+		case metadata.DateFromContainer:
+			if r.Probe != nil {
+				if probed, err := r.Probe(path); err == nil {
+					t = probed.In(loc)
+				}
+			}
+		case metadata.DateFromModTime:
+			if info, err := os.Stat(path); err == nil {
+				t = info.ModTime().In(loc)
+			}
+		}
+		if !t.IsZero() {
+			return t, source
+		}
+	}
+	return time.Time{}, ""
+}
+
+var (
+	// "AUD-20240305-WA0001.opus", as saved by WhatsApp on Android; no time of day.
+	whatsAppAndroid = regexp.MustCompile(`^(?:AUD|PTT|VID|IMG|DOC)-(\d{8})-WA\d+`)
+	// "00000012-AUDIO-2024-03-05-18-30-12.opus", from an iOS chat export.
+	whatsAppIOS = regexp.MustCompile(`^\d+-(?:AUDIO|VIDEO|PHOTO)-(\d{4}-\d{2}-\d{2}-\d{2}-\d{2}-\d{2})`)
+	// "WhatsApp Audio 2024-03-05 at 18.30.12.opus", saved from WhatsApp Web
+	// or Desktop.
+	whatsAppWeb = regexp.MustCompile(`^WhatsApp (?:Audio|Video|Ptt) (\d{4}-\d{2}-\d{2}) at (\d{1,2}\.\d{2}\.\d{2})`)
+	// "GMT20240305-130012_Recording.m4a", a Zoom cloud recording, in UTC.
+	zoomCloud = regexp.MustCompile(`^GMT(\d{8}-\d{6})`)
+)
+
+// FromName finds the date in the name of a WhatsApp or Zoom file, or of the
+// local Zoom recording folder the file is in.
+func FromName(path string, loc *time.Location) (time.Time, bool) {
+	name := filepath.Base(path)
+	parse := func(layout, value string, loc *time.Location) (time.Time, bool) {
+		t, err := time.ParseInLocation(layout, value, loc)
+		return t, err == nil
+	}
+	if m := whatsAppAndroid.FindStringSubmatch(name); m != nil {
+		return parse("20060102", m[1], loc)
+	}
+	if m := whatsAppIOS.FindStringSubmatch(name); m != nil {
+		return parse("2006-01-02-15-04-05", m[1], loc)
+	}
+	if m := whatsAppWeb.FindStringSubmatch(name); m != nil {
+		return parse("2006-01-02 15.04.05", m[1]+" "+m[2], loc)
+	}
+	if m := zoomCloud.FindStringSubmatch(name); m != nil {
+		t, ok := parse("20060102-150405", m[1], time.UTC)
+		return t.In(loc), ok
+	}
+	if meeting, ok := zoom.ParseFolderName(filepath.Base(filepath.Dir(path)), loc); ok {
+		return meeting.Start, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,75 @@
+package recdate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"musicloud/internal/metadata"
+)
+
+func TestFromName(t *testing.T) {
+	kolkata := time.FixedZone("IST", 5*3600+1800)
+	cases := map[string]string{
+		"AUD-20240305-WA0001.opus":                                   "2024-03-05 00:00",
+		"00000012-AUDIO-2024-03-05-18-30-12.opus":                    "2024-03-05 18:30",
+		"WhatsApp Audio 2024-03-05 at 18.30.12.ogg":                  "2024-03-05 18:30",
+		"GMT20240305-130012_Recording.m4a":                           "2024-03-05 18:30",
+		"2024-03-05 18.30.12 Veena Class 81234567890/audio_only.m4a": "2024-03-05 18:30",
+	}
+	for name, want := range cases {
+		got, ok := FromName(filepath.FromSlash(name), kolkata)
+		if !ok || got.Format("2006-01-02 15:04") != want || got.Location() != kolkata {
+			t.Errorf("FromName(%q) = %v, %v, want %s", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{"lesson.mp3", "AUD-20241305-WA0001.opus", "class 2024-03-05.m4a"} {
+		if got, ok := FromName(name, kolkata); ok {
+			t.Errorf("FromName(%q) = %v, want no date", name, got)
+		}
+	}
+}
+
+func TestResolver_Chain(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "AUD-20240305-WA0001.opus")
+	os.WriteFile(path, []byte("dummy"), 0644)
+	mtime := time.Date(2024, 5, 1, 8, 0, 0, 0, time.Local)
+	os.Chtimes(path, mtime, mtime)
+	message := time.Date(2024, 3, 6, 7, 0, 0, 0, time.Local)
+	probed := time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC)
+	r := &Resolver{Probe: func(string) (time.Time, error) { return probed, nil }}
+
+	if got, source := r.Date(path, message); !got.Equal(message) || source != metadata.DateFromChat {
+		t.Errorf("got %v from %q, want the message time", got, source)
+	}
+	if got, source := r.Date(path, time.Time{}); got.Day() != 5 || source != metadata.DateFromFileName {
+		t.Errorf("got %v from %q, want the file name date", got, source)
+	}
+	r.Chain = []string{metadata.DateFromContainer, metadata.DateFromModTime}
+	if got, source := r.Date(path, message); !got.Equal(probed) || source != metadata.DateFromContainer {
+		t.Errorf("got %v from %q, want the creation time", got, source)
+	}
+	r.Probe = nil
+	if got, source := r.Date(path, message); !got.Equal(mtime) || source != metadata.DateFromModTime {
+		t.Errorf("got %v from %q, want the modification time", got, source)
+	}
+	if got, source := r.Date(filepath.Join(dir, "missing.m4a"), time.Time{}); !got.IsZero() || source != "" {
+		t.Errorf("got %v from %q, want no date", got, source)
+	}
+}
+
+func TestParseChain(t *testing.T) {
+	chain, err := ParseChain(" Filename, mtime ")
+	if err != nil || !reflect.DeepEqual(chain, []string{"filename", "mtime"}) {
+		t.Errorf("unexpected chain %v, %v", chain, err)
+	}
+	if chain, err := ParseChain(""); err != nil || !reflect.DeepEqual(chain, DefaultChain) {
+		t.Errorf("expected the default chain, got %v, %v", chain, err)
+	}
+	if _, err := ParseChain("chat,exif"); err == nil {
+		t.Error("expected an unknown source to be rejected")
+	}
+}
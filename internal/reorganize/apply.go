@@ -0,0 +1,238 @@
+package reorganize
+
+import (
+	"fmt"
+	"strings"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/library"
+	"musicloud/internal/organizer"
+)
+
+// Apply makes the steps not made yet, in order, saving the plan and the
+// library after each, so a run that stops can be started again and goes on
+// where it stopped. o makes the folders and keeps the session manifests and
+// browse views in step with the moves.
+func (p *Plan) Apply(o *organizer.Organizer, lib *library.Library) error {
+	p.Warnings = nil
+	for _, s := range p.Steps {
+		if s.State != Pending && s.State != Undone {
+			continue
+		}
+		if err := p.apply(s, o, lib); err != nil {
+			err = fmt.Errorf("%s: %v", strings.ReplaceAll(s.String(), "\n", " "), err)
+			if saveErr := p.Save(); saveErr != nil {
+				err = fmt.Errorf("%v; unable to save the plan: %v", err, saveErr)
+			}
+			return err
+		}
+		if err := p.Save(); err != nil {
+			return fmt.Errorf("unable to save the plan: %v", err)
+		}
+	}
+	return nil
+}
+
+func (p *Plan) apply(s *Step, o *organizer.Organizer, lib *library.Library) error {
+	switch s.Kind {
+	case MakeFolder:
+		id, created, err := o.Folder(s.Folder)
+		if err != nil {
+			return err
+		}
+		s.FolderID, s.Created, s.State = id, contains(created, id), Done
+		return nil
+
+	case RemoveFolder:
+		empty, err := onlyOwnFiles(o.Remote, s.FolderID)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			s.State = Skipped
+			return nil
+		}
+		if _, err := o.Remote.Update(s.FolderID, &gdrive.File{Trashed: true}, "", "", nil, nil); err != nil {
+			return fmt.Errorf("unable to remove folder: %v", err)
+		}
+		s.State = Done
+		return nil
+	}
+
+	rec, err := lib.Find(s.Recording)
+	if err != nil {
+		return err
+	}
+	f, err := o.Remote.Get(s.FileID)
+	if err != nil {
+		return fmt.Errorf("unable to find file: %v", err)
+	}
+	toID, _, err := o.Folder(s.To)
+	if err != nil {
+		return err
+	}
+	// A run that stopped between the move and saving the plan left it
+	// made already, and the name the move kept is the step's.
+	if contains(f.Parents, toID) && f.Name == s.ToName {
+		if s.ToName != s.FromName && f.AppProperties[drive.OriginalName] == s.FromName {
+			s.SetOriginal = true
+		}
+	} else {
+		if !contains(f.Parents, s.FromID) || f.Name != s.FromName {
+			return fmt.Errorf("the file was moved or renamed since the plan was made")
+		}
+		update := &gdrive.File{}
+		if s.ToName != s.FromName {
+			update.Name = s.ToName
+			if f.AppProperties[drive.OriginalName] == "" {
+				update.AppProperties = map[string]string{drive.OriginalName: s.FromName}
+				s.SetOriginal = true
+			}
+		}
+		add, remove := toID, s.FromID
+		if toID == s.FromID {
+			add, remove = "", ""
+		}
+		if _, err := o.Remote.Update(s.FileID, update, add, remove, nil, nil); err != nil {
+			return fmt.Errorf("unable to move: %v", err)
+		}
+	}
+	s.ToID, s.State = toID, Done
+	rec.Folder, rec.FolderID, rec.Name = s.To, toID, s.ToName
+	if s.SetOriginal {
+		rec.Original = s.FromName
+	}
+	if err := lib.Save(); err != nil {
+		return err
+	}
+	p.follow(o, rec, s.FromID)
+	return nil
+}
+
+// Undo reverts the steps made, last first, saving the plan and the library
+// after each.
+func (p *Plan) Undo(o *organizer.Organizer, lib *library.Library) error {
+	p.Warnings = nil
+	for i := len(p.Steps) - 1; i >= 0; i-- {
+		s := p.Steps[i]
+		if s.State != Done && s.State != Skipped {
+			continue
+		}
+		if err := p.undo(s, o, lib); err != nil {
+			err = fmt.Errorf("%s: %v", strings.ReplaceAll(s.String(), "\n", " "), err)
+			if saveErr := p.Save(); saveErr != nil {
+				err = fmt.Errorf("%v; unable to save the plan: %v", err, saveErr)
+			}
+			return err
+		}
+		if err := p.Save(); err != nil {
+			return fmt.Errorf("unable to save the plan: %v", err)
+		}
+	}
+	return nil
+}
+
+func (p *Plan) undo(s *Step, o *organizer.Organizer, lib *library.Library) error {
+	switch s.Kind {
+	case MakeFolder:
+		// A folder the step found rather than made, or that has gained
+		// files since, is kept.
+		if !s.Created {
+			s.State = Undone
+			return nil
+		}
+		if empty, err := onlyOwnFiles(o.Remote, s.FolderID); err != nil {
+			return err
+		} else if empty {
+			if _, err := o.Remote.Update(s.FolderID, &gdrive.File{Trashed: true}, "", "", nil, nil); err != nil {
+				return fmt.Errorf("unable to remove folder: %v", err)
+			}
+		}
+		s.State = Undone
+		return nil
+
+	case RemoveFolder:
+		if s.State == Done {
+			restore := &gdrive.File{Trashed: false, ForceSendFields: []string{"Trashed"}}
+			if _, err := o.Remote.Update(s.FolderID, restore, "", "", nil, nil); err != nil {
+				return fmt.Errorf("unable to restore folder: %v", err)
+			}
+		}
+		s.State = Undone
+		return nil
+	}
+
+	rec, err := lib.Find(s.Recording)
+	if err != nil {
+		return err
+	}
+	f, err := o.Remote.Get(s.FileID)
+	if err != nil {
+		return fmt.Errorf("unable to find file: %v", err)
+	}
+	if !contains(f.Parents, s.FromID) || f.Name != s.FromName {
+		if !contains(f.Parents, s.ToID) || f.Name != s.ToName {
+			return fmt.Errorf("the file was moved or renamed since the plan was applied")
+		}
+		update := &gdrive.File{}
+		var clear []string
+		if s.ToName != s.FromName {
+			update.Name = s.FromName
+			if s.SetOriginal {
+				clear = []string{drive.OriginalName}
+			}
+		}
+		add, remove := s.FromID, s.ToID
+		if s.ToID == s.FromID {
+			add, remove = "", ""
+		}
+		if _, err := o.Remote.Update(s.FileID, update, add, remove, clear, nil); err != nil {
+			return fmt.Errorf("unable to move back: %v", err)
+		}
+	}
+	s.State = Undone
+	rec.Folder, rec.FolderID, rec.Name = s.From, s.FromID, s.FromName
+	if s.SetOriginal {
+		rec.Original = ""
+	}
+	if err := lib.Save(); err != nil {
+		return err
+	}
+	p.follow(o, rec, s.ToID)
+	return nil
+}
+
+// follow updates the session manifests of the folder a recording is in now
+// and the one it left, and its browse views. Failures are warnings: the
+// recording itself is where the step put it.
+func (p *Plan) follow(o *organizer.Organizer, rec *library.Recording, left string) {
+	warn := func(err error) {
+		if err != nil {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("%s: %v", rec.Name, err))
+		}
+	}
+	warn(o.Record(rec.FolderID, organizer.Entry{FileID: rec.DriveID, SHA256: rec.SHA256, Metadata: rec.Metadata}))
+	if left != rec.FolderID {
+		warn(o.Refresh(left))
+	}
+	if o.Views != nil {
+		warn(o.Browse(rec.DriveID, rec.Metadata))
+	}
+}
+
+// onlyOwnFiles reports whether a folder holds nothing but the session
+// manifest and index musicloud writes.
+func onlyOwnFiles(remote drive.Remote, folderID string) (bool, error) {
+	files, err := remote.List(folderID)
+	if err != nil {
+		return false, fmt.Errorf("unable to list folder: %v", err)
+	}
+	for _, f := range files {
+		if f.MimeType == drive.FolderMimeType || f.Name != organizer.ManifestName && f.Name != organizer.IndexName {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,454 @@
+// Package reorganize moves recordings uploaded under earlier folder layouts
+// and name templates to where the current ones put them. A plan is worked
+// out and saved first, so it can be reviewed. Applying it takes one step at
+// a time and records each, so an interrupted run resumes where it stopped
+// and a finished one can be undone. Files are only moved and renamed, never
+// uploaded again.
+package reorganize
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/library"
+	"musicloud/internal/organizer"
+)
+
+// Kinds of steps.
+const (
+	MakeFolder   = "mkdir"
+	Move         = "move" // moves and renames a file
+	RemoveFolder = "rmdir"
+)
+
+// States of steps.
+const (
+	Pending = ""
+	Done    = "done"
+	Skipped = "skipped" // a folder to remove that was no longer empty
+	Undone  = "undone"
+)
+
+// Step is one change of a plan.
+type Step struct {
+	Kind string `json:"kind"`
+	// Folder is the folder made or removed, below the upload folder.
+	Folder   string `json:"folder,omitempty"`
+	FolderID string `json:"folder_id,omitempty"`
+	// Created records that applying the step made the folder, rather than
+	// finding one made since the plan was; only then does undoing it remove
+	// the folder.
+	Created bool `json:"created,omitempty"`
+	// Recording is the library ID of the recording moved.
+	Recording string `json:"recording,omitempty"`
+	FileID    string `json:"file_id,omitempty"`
+	From      string `json:"from,omitempty"` // folder below the upload folder
+	FromID    string `json:"from_id,omitempty"`
+	FromName  string `json:"from_name,omitempty"`
+	To        string `json:"to,omitempty"`
+	ToID      string `json:"to_id,omitempty"` // known once applied
+	ToName    string `json:"to_name,omitempty"`
+	// SetOriginal records that the move first kept the file's original
+	// name, which undoing it removes again.
+	SetOriginal bool   `json:"set_original,omitempty"`
+	State       string `json:"state,omitempty"`
+}
+
+func (s *Step) String() string {
+	var line string
+	switch s.Kind {
+	case MakeFolder:
+		line = "+ " + s.Folder + "/"
+	case RemoveFolder:
+		line = "- " + s.Folder + "/"
+	default:
+		line = "- " + path.Join(s.From, s.FromName) + "\n+ " + path.Join(s.To, s.ToName)
+	}
+	if s.State != Pending {
+		line += "  (" + s.State + ")"
+	}
+	return line
+}
+
+// Plan is a reorganization, saved as JSON between the runs that make,
+// apply and undo it.
+type Plan struct {
+	path    string
+	Root    string    `json:"root"` // ID of the upload folder
+	Created time.Time `json:"created"`
+	Steps   []*Step   `json:"steps"`
+	// Missing lists the recordings whose files are gone from Drive; they
+	// are left alone.
+	Missing []string `json:"missing,omitempty"`
+	// Warnings lists what could not be kept in step with a step, such as a
+	// session manifest, from the last Apply or Undo.
+	Warnings []string `json:"-"`
+}
+
+// Load reads the plan saved at path. A missing file yields an empty plan
+// that will be created on Save.
+func Load(path string) (*Plan, error) {
+	p := &Plan{path: path}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read plan: %v", err)
+	}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("unable to parse plan %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// Save writes the plan to the file it was loaded from. A plan that was not
+// loaded, such as one just made by a Planner, is not saved.
+func (p *Plan) Save() error {
+	if p.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write a new file and rename it, so an interrupted save keeps the old one.
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// Started reports whether some step is applied and not undone.
+func (p *Plan) Started() bool {
+	for _, s := range p.Steps {
+		if s.State == Done || s.State == Skipped {
+			return true
+		}
+	}
+	return false
+}
+
+// Finished reports whether every step is applied.
+func (p *Plan) Finished() bool {
+	for _, s := range p.Steps {
+		if s.State == Pending || s.State == Undone {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary counts the changes of the plan.
+func (p *Plan) Summary() string {
+	var moves, renames, made, removed int
+	for _, s := range p.Steps {
+		switch s.Kind {
+		case MakeFolder:
+			made++
+		case RemoveFolder:
+			removed++
+		default:
+			if s.From != s.To {
+				moves++
+			}
+			if s.FromName != s.ToName {
+				renames++
+			}
+		}
+	}
+	return fmt.Sprintf("%d to move, %d to rename, %d new folders, %d empty folders to remove", moves, renames, made, removed)
+}
+
+// String shows the plan as a diff: "+" for what is made, "-" for what goes
+// away, a file's old place above its new one.
+func (p *Plan) String() string {
+	var b strings.Builder
+	for _, s := range p.Steps {
+		b.WriteString(s.String() + "\n")
+	}
+	for _, id := range p.Missing {
+		fmt.Fprintf(&b, "! %s: not found in Drive, left alone\n", id)
+	}
+	return b.String()
+}
+
+// Planner works out plans.
+type Planner struct {
+	Remote  drive.Remote
+	Root    string
+	Layouts *organizer.Layouts
+	// Routes are the folders recordings are routed to by their sender's
+	// role. A recording in one stays below it, and route folders are never
+	// removed.
+	Routes []string
+
+	folders  map[string]string         // folder IDs by path
+	children map[string][]*gdrive.File // folder contents by ID
+}
+
+// Plan works out where the current layouts put each recording and the
+// steps that take it there.
+func (p *Planner) Plan(recordings []*library.Recording) (*Plan, error) {
+	p.folders, p.children = map[string]string{"": p.Root}, map[string][]*gdrive.File{}
+	plan := &Plan{Root: p.Root, Created: time.Now()}
+
+	recs := append([]*library.Recording(nil), recordings...)
+	sort.SliceStable(recs, func(i, j int) bool {
+		a, b := recs[i].Metadata.RecordedAt, recs[j].Metadata.RecordedAt
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return recs[i].ID < recs[j].ID
+	})
+
+	// Where each recording belongs, and which stay where they are: they
+	// keep their names, collision suffix and all.
+	type placement struct {
+		rec          *library.Recording
+		file         *gdrive.File
+		fromID       string
+		folder, name string
+	}
+	var moving []*placement
+	leaving := map[string]bool{}
+	taken := map[string]map[string]bool{} // names in use by folder path
+	for _, r := range recs {
+		if r.DriveID == "" {
+			continue
+		}
+		f, err := p.Remote.Get(r.DriveID)
+		if err != nil || f.Trashed {
+			plan.Missing = append(plan.Missing, r.ID)
+			continue
+		}
+		layout, err := p.Layouts.Folder(r.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("recording %s: %v", r.ID, err)
+		}
+		original := r.Original
+		if original == "" {
+			original = f.AppProperties[drive.OriginalName]
+		}
+		if original == "" {
+			original = f.Name
+		}
+		name, err := p.Layouts.Name(r.Metadata, original)
+		if err != nil {
+			return nil, fmt.Errorf("recording %s: %v", r.ID, err)
+		}
+		if name == "" {
+			name = f.Name
+		}
+		pl := &placement{rec: r, file: f, fromID: r.FolderID, folder: path.Join(p.route(r.Folder), layout), name: name}
+		if !contains(f.Parents, pl.fromID) && len(f.Parents) > 0 {
+			pl.fromID = f.Parents[0]
+		}
+		if pl.folder == r.Folder && (f.Name == name || stripSuffix(f.Name) == name) {
+			continue
+		}
+		moving = append(moving, pl)
+		leaving[f.Id] = true
+	}
+	names := func(folder string) (map[string]bool, error) {
+		if taken[folder] == nil {
+			taken[folder] = map[string]bool{}
+			if id, ok, err := p.lookup(folder); err != nil {
+				return nil, err
+			} else if ok {
+				files, err := p.list(id)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range files {
+					if !leaving[f.Id] {
+						taken[folder][f.Name] = true
+					}
+				}
+			}
+		}
+		return taken[folder], nil
+	}
+
+	var moves []*Step
+	made := map[string]bool{}
+	var mkdirs []string
+	incoming := map[string]bool{}
+	for _, pl := range moving {
+		used, err := names(pl.folder)
+		if err != nil {
+			return nil, err
+		}
+		name := unique(used, pl.name)
+		used[name] = true
+		for dir := pl.folder; dir != "" && dir != "."; dir = path.Dir(dir) {
+			incoming[dir] = true
+			if _, ok, err := p.lookup(dir); err != nil {
+				return nil, err
+			} else if !ok && !made[dir] {
+				made[dir] = true
+				mkdirs = append(mkdirs, dir)
+			}
+		}
+		if pl.folder == pl.rec.Folder && name == pl.file.Name {
+			continue
+		}
+		moves = append(moves, &Step{Kind: Move, Recording: pl.rec.ID, FileID: pl.file.Id,
+			From: pl.rec.Folder, FromID: pl.fromID, FromName: pl.file.Name, To: pl.folder, ToName: name})
+	}
+	sort.Strings(mkdirs)
+	for _, dir := range mkdirs {
+		plan.Steps = append(plan.Steps, &Step{Kind: MakeFolder, Folder: dir})
+	}
+	plan.Steps = append(plan.Steps, moves...)
+
+	// Folders the moves leave empty go, innermost first, and then their
+	// parents if that empties them too.
+	moved := map[string]bool{}
+	var sources []string
+	for _, s := range moves {
+		if s.From != s.To {
+			moved[s.FileID] = true
+			sources = append(sources, s.From)
+		}
+	}
+	sort.SliceStable(sources, func(i, j int) bool {
+		return strings.Count(sources[i], "/") > strings.Count(sources[j], "/")
+	})
+	removed := map[string]bool{}
+	var check func(dir string) error
+	check = func(dir string) error {
+		if dir == "" || dir == "." || removed[dir] || incoming[dir] || p.isRoute(dir) {
+			return nil
+		}
+		id, ok, err := p.lookup(dir)
+		if err != nil || !ok {
+			return err
+		}
+		files, err := p.list(id)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			own := f.Name == organizer.ManifestName || f.Name == organizer.IndexName
+			if moved[f.Id] || own && f.MimeType != drive.FolderMimeType || f.MimeType == drive.FolderMimeType && removed[path.Join(dir, f.Name)] {
+				continue
+			}
+			return nil
+		}
+		removed[dir] = true
+		plan.Steps = append(plan.Steps, &Step{Kind: RemoveFolder, Folder: dir, FolderID: id})
+		return check(path.Dir(dir))
+	}
+	for _, dir := range sources {
+		if err := check(dir); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// route returns the route folder a recording's folder is below, or "".
+func (p *Planner) route(folder string) string {
+	route := ""
+	for _, r := range p.Routes {
+		if (folder == r || strings.HasPrefix(folder, r+"/")) && len(r) > len(route) {
+			route = r
+		}
+	}
+	return route
+}
+
+func (p *Planner) isRoute(folder string) bool {
+	for _, r := range p.Routes {
+		if folder == r {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup finds the folder at a path below Root without making it. Of
+// folders with the same name the oldest is used, as the organizer does.
+func (p *Planner) lookup(folder string) (string, bool, error) {
+	if id, ok := p.folders[folder]; ok {
+		return id, id != "", nil
+	}
+	parent, ok, err := p.lookup(dirOf(folder))
+	if err != nil || !ok {
+		return "", false, err
+	}
+	found, err := p.Remote.Find(parent, path.Base(folder), drive.FolderMimeType)
+	if err != nil {
+		return "", false, fmt.Errorf("error searching for folder %q: %v", folder, err)
+	}
+	p.folders[folder] = ""
+	if len(found) > 0 {
+		p.folders[folder] = found[0].Id
+	}
+	return p.folders[folder], p.folders[folder] != "", nil
+}
+
+func (p *Planner) list(id string) ([]*gdrive.File, error) {
+	if files, ok := p.children[id]; ok {
+		return files, nil
+	}
+	files, err := p.Remote.List(id)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list folder %s: %v", id, err)
+	}
+	p.children[id] = files
+	return files, nil
+}
+
+// dirOf is path.Dir with "" for the upload folder.
+func dirOf(folder string) string {
+	if dir := path.Dir(folder); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// unique returns name, or name with "_2", "_3" and so on before its
+// extension, whichever is not used, as drive.UniqueName does.
+func unique(used map[string]bool, name string) string {
+	ext := path.Ext(name)
+	candidate := name
+	for n := 2; used[candidate]; n++ {
+		candidate = fmt.Sprintf("%s_%d%s", strings.TrimSuffix(name, ext), n, ext)
+	}
+	return candidate
+}
+
+// stripSuffix removes a collision suffix: "Todi_2.m4a" is "Todi.m4a".
+func stripSuffix(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	i := strings.LastIndexByte(base, '_')
+	if i < 0 || i == len(base)-1 || strings.Trim(base[i+1:], "0123456789") != "" {
+		return name
+	}
+	return base[:i] + ext
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
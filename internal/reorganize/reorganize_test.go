@@ -0,0 +1,252 @@
+package reorganize
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gdrive "google.golang.org/api/drive/v3"
+
+	"musicloud/internal/drive"
+	"musicloud/internal/library"
+	"musicloud/internal/metadata"
+	"musicloud/internal/organizer"
+)
+
+// failingRemote fails moving files between folders once failAfter moves
+// have gone through; -1 never fails. With lostReply, the failing move is
+// made before the error is returned, as when the connection drops.
+type failingRemote struct {
+	*drive.MockRemote
+	failAfter int
+	lostReply bool
+}
+
+func (f *failingRemote) Update(id string, file *gdrive.File, add, remove string, clear []string, media io.Reader) (*gdrive.File, error) {
+	if add != "" {
+		if f.failAfter == 0 {
+			if f.lostReply {
+				f.MockRemote.Update(id, file, add, remove, clear, media)
+			}
+			return nil, errors.New("backend error")
+		}
+		f.failAfter--
+	}
+	return f.MockRemote.Update(id, file, add, remove, clear, media)
+}
+
+// setup uploads three recordings under the layout "{{.Date}} {{.Group}}",
+// one of them into the route folder "Teachers", and a fourth recording
+// whose file has since been deleted.
+func setup(t *testing.T) (*drive.MockRemote, *library.Library, map[string]string) {
+	remote := drive.NewMockRemote()
+	mkdir := func(name, parent string) string {
+		f, _ := remote.Create(&gdrive.File{Name: name, MimeType: drive.FolderMimeType, Parents: []string{parent}}, nil)
+		return f.Id
+	}
+	ids := map[string]string{}
+	ids["old"] = mkdir("2024-03-05 Veena Class", "root")
+	ids["Teachers"] = mkdir("Teachers", "root")
+	ids["teachers/old"] = mkdir("2024-03-06 Veena Class", ids["Teachers"])
+	remote.Create(&gdrive.File{Name: organizer.ManifestName, Parents: []string{ids["old"]}}, strings.NewReader("{}"))
+
+	lib, _ := library.Load(filepath.Join(t.TempDir(), "library.json"))
+	add := func(id, name, folder, folderID string, day int) {
+		f, _ := remote.Create(&gdrive.File{Name: name, Parents: []string{folderID}}, strings.NewReader(name))
+		ids[id] = f.Id
+		lib.Add(library.Recording{ID: id, DriveID: f.Id, Name: name, Folder: folder, FolderID: folderID, Metadata: metadata.Metadata{
+			GroupName: "Veena Class", Ragas: []string{"Todi"}, RecordedAt: time.Date(2024, 3, day, 18, 0, 0, 0, time.Local)}})
+	}
+	add("aaa", "AUD-1.opus", "2024-03-05 Veena Class", ids["old"], 5)
+	add("bbb", "AUD-2.opus", "2024-03-05 Veena Class", ids["old"], 5)
+	add("ccc", "AUD-3.opus", "Teachers/2024-03-06 Veena Class", ids["teachers/old"], 6)
+	lib.Add(library.Recording{ID: "ddd", DriveID: "gone", Name: "AUD-4.opus", FolderID: "root"})
+	return remote, lib, ids
+}
+
+func newLayouts(t *testing.T) *organizer.Layouts {
+	layouts, err := organizer.NewLayouts(nil, "{{.Year}}/{{.Group}}", "{{.Date}}_{{first .Ragas}}.{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layouts
+}
+
+func TestPlan(t *testing.T) {
+	remote, lib, _ := setup(t)
+	planner := &Planner{Remote: remote, Root: "root", Layouts: newLayouts(t), Routes: []string{"Teachers"}}
+	plan, err := planner.Plan(lib.Recordings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `+ 2024/
++ 2024/Veena Class/
++ Teachers/2024/
++ Teachers/2024/Veena Class/
+- 2024-03-05 Veena Class/AUD-1.opus
++ 2024/Veena Class/2024-03-05_Todi.opus
+- 2024-03-05 Veena Class/AUD-2.opus
++ 2024/Veena Class/2024-03-05_Todi_2.opus
+- Teachers/2024-03-06 Veena Class/AUD-3.opus
++ Teachers/2024/Veena Class/2024-03-06_Todi.opus
+- Teachers/2024-03-06 Veena Class/
+- 2024-03-05 Veena Class/
+! ddd: not found in Drive, left alone
+`
+	if got := plan.String(); got != want {
+		t.Errorf("unexpected plan:\n%s\nwant:\n%s", got, want)
+	}
+	if got := plan.Summary(); got != "3 to move, 3 to rename, 4 new folders, 2 empty folders to remove" {
+		t.Errorf("unexpected summary: %s", got)
+	}
+	if remote.Calls["Create"] != 7 || remote.Calls["Update"] != 0 {
+		t.Errorf("expected planning to change nothing, got %v", remote.Calls)
+	}
+
+	// Once applied, there is nothing left to do.
+	if err := plan.Apply(organizer.New(remote, "root", newLayouts(t)), lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, err := planner.Plan(lib.Recordings)
+	if err != nil || len(again.Steps) != 0 {
+		t.Errorf("expected an empty plan, got %v:\n%s", err, again)
+	}
+}
+
+func TestApply_ResumesAndUndoes(t *testing.T) {
+	mock, lib, ids := setup(t)
+	remote := &failingRemote{MockRemote: mock, failAfter: 1}
+	planner := &Planner{Remote: remote, Root: "root", Layouts: newLayouts(t), Routes: []string{"Teachers"}}
+	planPath := filepath.Join(t.TempDir(), "reorganize.json")
+	plan, _ := Load(planPath)
+	next, err := planner.Plan(lib.Recordings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan.Root, plan.Steps, plan.Missing = next.Root, next.Steps, next.Missing
+	plan.Save()
+
+	if err := plan.Apply(organizer.New(remote, "root", newLayouts(t)), lib); err == nil || !strings.Contains(err.Error(), "AUD-2.opus") {
+		t.Fatalf("expected the second move to fail, got %v", err)
+	}
+	plan, _ = Load(planPath)
+	if !plan.Started() || plan.Finished() {
+		t.Fatalf("expected a partly applied plan:\n%s", plan)
+	}
+
+	// Running again resumes from the saved plan.
+	remote.failAfter = -1
+	if err := plan.Apply(organizer.New(remote, "root", newLayouts(t)), lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !plan.Finished() {
+		t.Fatalf("expected every step applied:\n%s", plan)
+	}
+	for id, want := range map[string]string{"aaa": "2024-03-05_Todi.opus", "bbb": "2024-03-05_Todi_2.opus", "ccc": "2024-03-06_Todi.opus"} {
+		f, _ := mock.Get(ids[id])
+		r, _ := lib.Find(id)
+		if f.Name != want || r.Name != want || !contains(f.Parents, r.FolderID) || f.AppProperties[drive.OriginalName] == "" {
+			t.Errorf("%s: expected %s, got %s %v in %v, library %+v", id, want, f.Name, f.AppProperties, f.Parents, r)
+		}
+		if mock.Revisions[ids[id]] != 1 {
+			t.Errorf("%s: expected no new upload, got %d revisions", id, mock.Revisions[ids[id]])
+		}
+	}
+	if r, _ := lib.Find("ccc"); r.Folder != "Teachers/2024/Veena Class" {
+		t.Errorf("expected the recording to stay below its route, got %s", r.Folder)
+	}
+	for _, id := range []string{ids["old"], ids["teachers/old"]} {
+		if f, _ := mock.Get(id); !f.Trashed {
+			t.Errorf("expected %s removed", f.Name)
+		}
+	}
+	if f, _ := mock.Get(ids["Teachers"]); f.Trashed {
+		t.Error("expected the route folder kept")
+	}
+
+	if err := plan.Undo(organizer.New(remote, "root", newLayouts(t)), lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for id, want := range map[string]string{"aaa": "AUD-1.opus", "bbb": "AUD-2.opus", "ccc": "AUD-3.opus"} {
+		f, _ := mock.Get(ids[id])
+		r, _ := lib.Find(id)
+		if f.Name != want || r.Name != want || r.Original != "" || f.AppProperties[drive.OriginalName] != "" || !contains(f.Parents, r.FolderID) {
+			t.Errorf("%s: expected %s restored, got %s %v in %v, library %+v", id, want, f.Name, f.AppProperties, f.Parents, r)
+		}
+	}
+	for _, id := range []string{ids["old"], ids["teachers/old"]} {
+		if f, _ := mock.Get(id); f.Trashed {
+			t.Errorf("expected %s restored", f.Name)
+		}
+	}
+	if found, _ := mock.Find("root", "2024", drive.FolderMimeType); len(found) != 0 {
+		t.Errorf("expected the new folders removed, got %v", found)
+	}
+	if plan.Started() {
+		t.Errorf("expected every step undone:\n%s", plan)
+	}
+}
+
+func TestUndo_KeepsFoldersMadeByOthers(t *testing.T) {
+	remote, lib, _ := setup(t)
+	planner := &Planner{Remote: remote, Root: "root", Layouts: newLayouts(t), Routes: []string{"Teachers"}}
+	plan, err := planner.Plan(lib.Recordings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// An upload makes one of the planned folders before the plan is applied.
+	theirs, _ := remote.Create(&gdrive.File{Name: "2024", MimeType: drive.FolderMimeType, Parents: []string{"root"}}, nil)
+
+	o := organizer.New(remote, "root", newLayouts(t))
+	if err := plan.Apply(o, lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := plan.Undo(organizer.New(remote, "root", newLayouts(t)), lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f, _ := remote.Get(theirs.Id); f.Trashed {
+		t.Error("expected the folder the plan did not make to be kept")
+	}
+	if found, _ := remote.Find(theirs.Id, "Veena Class", drive.FolderMimeType); len(found) != 0 {
+		t.Errorf("expected the folder the plan made to be removed, got %v", found)
+	}
+}
+
+func TestApply_ResumesAMoveMadeBeforeTheStop(t *testing.T) {
+	mock, lib, ids := setup(t)
+	remote := &failingRemote{MockRemote: mock, failAfter: 0, lostReply: true}
+	planner := &Planner{Remote: remote, Root: "root", Layouts: newLayouts(t), Routes: []string{"Teachers"}}
+	planPath := filepath.Join(t.TempDir(), "reorganize.json")
+	plan, _ := Load(planPath)
+	next, err := planner.Plan(lib.Recordings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plan.Root, plan.Created, plan.Steps, plan.Missing = next.Root, next.Created, next.Steps, next.Missing
+	plan.Save()
+	saved, _ := os.ReadFile(planPath)
+	if err := plan.Apply(organizer.New(remote, "root", newLayouts(t)), lib); err == nil {
+		t.Fatal("expected the first move to fail")
+	}
+
+	// The run stopped after the move but before the plan was saved.
+	os.WriteFile(planPath, saved, 0644)
+	plan, _ = Load(planPath)
+	remote.failAfter = -1
+	if err := plan.Apply(organizer.New(remote, "root", newLayouts(t)), lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r, _ := lib.Find("aaa"); r.Original != "AUD-1.opus" {
+		t.Errorf("expected the original name recorded, got %+v", r)
+	}
+	if err := plan.Undo(organizer.New(remote, "root", newLayouts(t)), lib); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f, _ := mock.Get(ids["aaa"]); f.Name != "AUD-1.opus" || f.AppProperties[drive.OriginalName] != "" {
+		t.Errorf("expected the file restored, got %s %v", f.Name, f.AppProperties)
+	}
+}
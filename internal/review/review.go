@@ -0,0 +1,90 @@
+package review
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Entry is a single finding that needs a person to look at it.
+type Entry struct {
+	Kind    string // what kind of problem, e.g. "unknown sender"
+	Subject string // the value in question
+	Detail  string
+	Source  string // where it was found, e.g. a chat name or file path
+}
+
+// Report collects findings during a run. Identical entries are kept once.
+type Report struct {
+	Entries []Entry
+	seen    map[Entry]bool
+}
+
+// Add records a finding.
+func (r *Report) Add(kind, subject, detail, source string) {
+	if r == nil {
+		return
+	}
+	e := Entry{Kind: kind, Subject: subject, Detail: detail, Source: source}
+	if r.seen == nil {
+		r.seen = map[Entry]bool{}
+	}
+	if r.seen[e] {
+		return
+	}
+	r.seen[e] = true
+	r.Entries = append(r.Entries, e)
+}
+
+// Empty reports whether nothing was recorded.
+func (r *Report) Empty() bool {
+	return r == nil || len(r.Entries) == 0
+}
+
+// Write prints the report as Markdown, grouped by kind.
+func (r *Report) Write(w io.Writer) error {
+	byKind := map[string][]Entry{}
+	var kinds []string
+	for _, e := range r.Entries {
+		if _, ok := byKind[e.Kind]; !ok {
+			kinds = append(kinds, e.Kind)
+		}
+		byKind[e.Kind] = append(byKind[e.Kind], e)
+	}
+	sort.Strings(kinds)
+
+	if _, err := fmt.Fprintln(w, "# Musicloud review report"); err != nil {
+		return err
+	}
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "\n## %s (%d)\n\n", kind, len(byKind[kind]))
+		for _, e := range byKind[kind] {
+			line := "- " + e.Subject
+			if e.Detail != "" {
+				line += ": " + e.Detail
+			}
+			if e.Source != "" {
+				line += " (" + e.Source + ")"
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Save writes the report to path.
+func (r *Report) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.Write(f)
+}
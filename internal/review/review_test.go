@@ -0,0 +1,32 @@
+package review
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReport_DeduplicatesAndGroups(t *testing.T) {
+	r := &Report{}
+	r.Add("Unknown sender", "Stranger", "sent a.opus", "Veena Class")
+	r.Add("Unknown sender", "Stranger", "sent a.opus", "Veena Class")
+	r.Add("Missing media", "b.opus", "", "Veena Class")
+	if len(r.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(r.Entries))
+	}
+	var buf bytes.Buffer
+	if err := r.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "## Unknown sender (1)") || !strings.Contains(buf.String(), "- Stranger: sent a.opus (Veena Class)") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
+
+func TestReport_NilIsEmpty(t *testing.T) {
+	var r *Report
+	r.Add("x", "y", "", "")
+	if !r.Empty() {
+		t.Error("expected nil report to stay empty")
+	}
+}
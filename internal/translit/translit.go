@@ -0,0 +1,293 @@
+// Package translit turns names written in Indian scripts into Latin letters,
+// so that "శంకరాభరణం", "Śaṅkarābharaṇam" and "Sankarabharanam" can be
+// recognised as the same name.
+package translit
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Scripts handled, by the start of their Unicode block. The Brahmic blocks
+// share one layout, so a single table serves all of them.
+const (
+	devanagari = 0x0900
+	tamil      = 0x0B80
+	telugu     = 0x0C00
+	kannada    = 0x0C80
+	malayalam  = 0x0D00
+	blockSize  = 0x80
+)
+
+var blocks = []rune{devanagari, tamil, telugu, kannada, malayalam}
+
+// vowels are the independent vowels, by offset in the block.
+var vowels = map[rune]string{
+	0x05: "a", 0x06: "ā", 0x07: "i", 0x08: "ī", 0x09: "u", 0x0A: "ū", 0x0B: "ṛ", 0x0C: "ḷ",
+	0x0E: "e", 0x0F: "ē", 0x10: "ai", 0x12: "o", 0x13: "ō", 0x14: "au", 0x60: "ṝ", 0x61: "ḹ",
+}
+
+// vowelSigns are the dependent vowel signs that follow a consonant.
+var vowelSigns = map[rune]string{
+	0x3E: "ā", 0x3F: "i", 0x40: "ī", 0x41: "u", 0x42: "ū", 0x43: "ṛ", 0x44: "ṝ",
+	0x46: "e", 0x47: "ē", 0x48: "ai", 0x4A: "o", 0x4B: "ō", 0x4C: "au", 0x57: "au",
+	0x62: "ḷ", 0x63: "ḹ",
+}
+
+// consonants are the consonants, by offset in the block.
+var consonants = map[rune]string{
+	0x15: "k", 0x16: "kh", 0x17: "g", 0x18: "gh", 0x19: "ṅ",
+	0x1A: "c", 0x1B: "ch", 0x1C: "j", 0x1D: "jh", 0x1E: "ñ",
+	0x1F: "ṭ", 0x20: "ṭh", 0x21: "ḍ", 0x22: "ḍh", 0x23: "ṇ",
+	0x24: "t", 0x25: "th", 0x26: "d", 0x27: "dh", 0x28: "n", 0x29: "ṉ",
+	0x2A: "p", 0x2B: "ph", 0x2C: "b", 0x2D: "bh", 0x2E: "m",
+	0x2F: "y", 0x30: "r", 0x31: "ṟ", 0x32: "l", 0x33: "ḷ", 0x34: "ḻ", 0x35: "v",
+	0x36: "ś", 0x37: "ṣ", 0x38: "s", 0x39: "h",
+}
+
+// chillus are the Malayalam letters for a consonant without a vowel.
+var chillus = map[rune]string{
+	0x0D54: "m", 0x0D55: "y", 0x0D56: "ḻ",
+	0x0D7A: "ṇ", 0x0D7B: "n", 0x0D7C: "r", 0x0D7D: "l", 0x0D7E: "ḷ", 0x0D7F: "k",
+}
+
+const (
+	candrabindu = 0x01
+	anusvara    = 0x02
+	visarga     = 0x03
+	nukta       = 0x3C
+	avagraha    = 0x3D
+	virama      = 0x4D
+	om          = 0x50
+)
+
+// nasalBefore gives the nasal an anusvara stands for before a consonant of
+// each class, so "शंकर" reads "śaṅkara" like the name is usually spelled.
+var nasalBefore = map[string]string{
+	"k": "ṅ", "kh": "ṅ", "g": "ṅ", "gh": "ṅ",
+	"c": "ñ", "ch": "ñ", "j": "ñ", "jh": "ñ",
+	"ṭ": "ṇ", "ṭh": "ṇ", "ḍ": "ṇ", "ḍh": "ṇ",
+	"t": "n", "th": "n", "d": "n", "dh": "n",
+	"p": "m", "ph": "m", "b": "m", "bh": "m",
+}
+
+// block returns the script block of r and its offset in it.
+func block(r rune) (rune, rune, bool) {
+	for _, b := range blocks {
+		if r >= b && r < b+blockSize {
+			return b, r - b, true
+		}
+	}
+	return 0, 0, false
+}
+
+// HasIndic reports whether s contains letters of a supported Indian script.
+func HasIndic(s string) bool {
+	for _, r := range s {
+		if _, _, ok := block(r); ok {
+			return true
+		}
+		if _, ok := chillus[r]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Latin transliterates Devanagari, Tamil, Telugu, Kannada and Malayalam text
+// to IAST, leaving other text as it is. Long e and o, which Devanagari does
+// not distinguish, are written ē and ō only for the Dravidian scripts. Tamil
+// letters stand for several sounds each; they are written with their
+// unvoiced value, except that a word-initial ச is written "s".
+func Latin(s string) string {
+	if !HasIndic(s) {
+		return s
+	}
+	rs := []rune(s)
+	var b strings.Builder
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if c, ok := chillus[r]; ok {
+			b.WriteString(c)
+			continue
+		}
+		base, off, ok := block(r)
+		if !ok {
+			b.WriteRune(r)
+			continue
+		}
+		dravidian := base != devanagari
+		switch {
+		case consonants[off] != "":
+			c := consonants[off]
+			if base == tamil && off == 0x1A && (i == 0 || !unicode.IsLetter(rs[i-1]) && !isMark(rs[i-1])) {
+				c = "s"
+			}
+			b.WriteString(c)
+			j := i + 1
+			if j < len(rs) && rs[j] == base+nukta {
+				j++
+			}
+			vowel := "a"
+			if j < len(rs) && rs[j] >= base && rs[j] < base+blockSize {
+				next := rs[j] - base
+				if next == virama {
+					vowel = ""
+					i = j
+				} else if v, ok := vowelSigns[next]; ok {
+					vowel = sign(v, dravidian)
+					i = j
+					// Tamil and Malayalam write some vowels with two signs: ொ is ெ + ா.
+					if j+1 < len(rs) && rs[j+1] >= base && rs[j+1] < base+blockSize {
+						if v2, ok := vowelSigns[rs[j+1]-base]; ok {
+							vowel = joinSigns(vowel, v2, dravidian)
+							i = j + 1
+						}
+					}
+				} else {
+					i = j - 1
+				}
+			} else {
+				i = j - 1
+			}
+			b.WriteString(vowel)
+		case vowels[off] != "":
+			b.WriteString(sign(vowels[off], dravidian))
+		case off == anusvara || off == candrabindu:
+			nasal := "ṃ"
+			if i+1 < len(rs) {
+				if nb, noff, ok := block(rs[i+1]); ok && nb == base {
+					if n, ok := nasalBefore[consonants[noff]]; ok {
+						nasal = n
+					}
+				}
+			}
+			if nasal == "ṃ" && dravidian {
+				nasal = "m"
+			}
+			b.WriteString(nasal)
+		case off == visarga:
+			b.WriteString("ḥ")
+		case off == om:
+			b.WriteString("oṃ")
+		case off >= 0x66 && off <= 0x6F:
+			b.WriteRune('0' + off - 0x66)
+		case off == 0x64 || off == 0x65:
+			b.WriteString(".")
+		case off == nukta || off == avagraha || off == virama:
+		default:
+			// other signs carry no sound of their own
+		}
+	}
+	return b.String()
+}
+
+// sign adjusts a vowel for the script: Devanagari has no short e and o, so
+// its e and o are written plain.
+func sign(v string, dravidian bool) string {
+	if !dravidian {
+		switch v {
+		case "ē":
+			return "e"
+		case "ō":
+			return "o"
+		}
+	}
+	return v
+}
+
+// joinSigns combines the two-part vowel signs of Tamil and Malayalam.
+func joinSigns(first, second string, dravidian bool) string {
+	switch first + "+" + second {
+	case "e+ā":
+		return "o"
+	case "ē+ā":
+		return "ō"
+	case "e+au":
+		return "au"
+	}
+	return first + sign(second, dravidian)
+}
+
+func isMark(r rune) bool {
+	return unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)
+}
+
+// plain maps IAST letters to their ASCII base letters.
+var plain = strings.NewReplacer(
+	"ā", "a", "ī", "i", "ū", "u", "ṛ", "r", "ṝ", "r", "ḷ", "l", "ḹ", "l", "ē", "e", "ō", "o",
+	"ṅ", "n", "ñ", "n", "ṭ", "t", "ḍ", "d", "ṇ", "n", "ṉ", "n", "ṟ", "r", "ḻ", "zh",
+	"ś", "sh", "ṣ", "sh", "ṃ", "m", "ḥ", "h", "m̐", "m",
+	"Ā", "A", "Ī", "I", "Ū", "U", "Ṛ", "R", "Ē", "E", "Ō", "O",
+	"Ṅ", "N", "Ñ", "N", "Ṭ", "T", "Ḍ", "D", "Ṇ", "N", "Ś", "Sh", "Ṣ", "Sh", "Ṃ", "M", "Ḥ", "H",
+)
+
+// ASCII transliterates s like Latin and drops the diacritics, giving the
+// everyday spelling: "శంకరాభరణం" and "Śaṅkarābharaṇam" both become
+// "shankarabharanam" / "Shankarabharanam". It suits folder and file names.
+func ASCII(s string) string {
+	return plain.Replace(Latin(s))
+}
+
+// keyFolds are the spelling differences between romanizations of the same
+// word, applied in order: long vowels, diphthongs, w/v, and the voiced and
+// unvoiced consonants Tamil script writes with one letter (k/g, c/j/s, t/d,
+// p/b), so "தோடி" (tōṭi) matches "Todi".
+var keyFolds = strings.NewReplacer(
+	"aa", "a", "ee", "i", "ii", "i", "oo", "u", "uu", "u",
+	"au", "o", "ow", "o", "ou", "o",
+	"w", "v",
+	"g", "k", "c", "s", "j", "s", "d", "t", "b", "p",
+)
+
+// Key reduces a name to the canonical form used to compare and deduplicate
+// names: transliterated, lower case, letters and digits only, aspirate h
+// after consonants dropped ("Bhairavi", "Bairavi"), "sh" as "s", voiced
+// consonants as unvoiced, long vowels and doubled consonants other than nn
+// shortened ("Keeravani", "Kiravani"; but "Kannada" is not "Kanada"), and
+// "iy" after a consonant as "y"
+// ("Thiyagaraja", "Tyagaraja"). Tamil writes an h between vowels with க and
+// adds ர் to names as a mark of respect, so such an h is read as k
+// ("மோகனம்", "Mohanam") and a word's final "ar" after a consonant as "a"
+// ("தியாகராஜர்", "Tyagaraja").
+func Key(s string) string {
+	var b []rune
+	word := 0 // letters of the current word
+	endWord := func() {
+		if n := len(b); word > 3 && b[n-1] == 'r' && b[n-2] == 'a' && !isVowel(b[n-3]) {
+			b = b[:n-1]
+		}
+		word = 0
+	}
+	for _, r := range strings.ToLower(ASCII(s)) {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			endWord()
+			continue
+		}
+		n := len(b)
+		if r == 'h' && n > 0 && !isVowel(b[n-1]) {
+			continue
+		}
+		if r == 'y' && n > 1 && b[n-1] == 'i' && !isVowel(b[n-2]) {
+			b, word = b[:n-1], word-1
+		}
+		if isVowel(r) && word > 1 && b[n-1] == 'h' && isVowel(b[n-2]) {
+			b[n-1] = 'k'
+		}
+		b = append(b, r)
+		word++
+	}
+	endWord()
+	var out []rune
+	for _, r := range keyFolds.Replace(string(b)) {
+		if n := len(out); n > 0 && out[n-1] == r && !isVowel(r) && r != 'n' {
+			continue
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func isVowel(r rune) bool {
+	return strings.ContainsRune("aeiou", r)
+}
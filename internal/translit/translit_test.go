@@ -0,0 +1,56 @@
+package translit
+
+import "testing"
+
+func TestLatin(t *testing.T) {
+	cases := []struct{ in, latin, ascii string }{
+		{"शंकराभरणम्", "śaṅkarābharaṇam", "shankarabharanam"},
+		{"శంకరాభరణం", "śaṅkarābharaṇam", "shankarabharanam"},
+		{"ಶಂಕರಾಭರಣ", "śaṅkarābharaṇa", "shankarabharana"},
+		{"ശങ്കരാഭരണം", "śaṅkarābharaṇam", "shankarabharanam"},
+		{"சங்கராபரணம்", "saṅkarāparaṇam", "sankaraparanam"},
+		{"हंसध्वनि", "haṃsadhvani", "hamsadhvani"},
+		{"మోహనం", "mōhanam", "mohanam"},
+		{"தோடி", "tōṭi", "toti"},
+		{"ವಾತಾಪಿ ಗಣಪತಿಂ", "vātāpi gaṇapatim", "vatapi ganapatim"},
+		{"കല്യാണി", "kalyāṇi", "kalyani"},
+		{"Vatapi Ganapatim", "Vatapi Ganapatim", "Vatapi Ganapatim"},
+		{"Śaṅkarābharaṇam", "Śaṅkarābharaṇam", "Shankarabharanam"},
+	}
+	for _, c := range cases {
+		if got := Latin(c.in); got != c.latin {
+			t.Errorf("Latin(%q) = %q, want %q", c.in, got, c.latin)
+		}
+		if got := ASCII(c.in); got != c.ascii {
+			t.Errorf("ASCII(%q) = %q, want %q", c.in, got, c.ascii)
+		}
+	}
+	if HasIndic("Kalyani") || !HasIndic("Raga कल्याणी") {
+		t.Errorf("HasIndic misclassified a string")
+	}
+}
+
+func TestKey(t *testing.T) {
+	same := [][]string{
+		{"Sankarabharanam", "Shankarabharanam", "शंकराभरणम्", "శంకరాభరణం", "ശങ്കരാഭരണം", "Śaṅkarābharaṇam"},
+		{"Kalyani", "Kalyaani", "कल्याणी", "ಕಲ್ಯಾಣಿ"},
+		{"Tyagaraja", "Thyagaraja", "Thiyagarajar", "త్యాగరాజ", "தியாகராஜர்"},
+		{"Todi", "Thodi", "தோடி", "తోడి"},
+		{"Hamsadhwani", "Hamsadhvani", "ஹம்சத்வனி", "हंसध्वनि"},
+		{"Mohanam", "மோகனம்", "మోహనం"},
+		{"Muthuswami Dikshitar", "முத்துஸ்வாமி தீக்ஷிதர்"},
+		{"Darbar", "தர்பார்"},
+	}
+	for _, names := range same {
+		for _, n := range names[1:] {
+			if Key(n) != Key(names[0]) {
+				t.Errorf("Key(%q) = %q, want %q as for %q", n, Key(n), Key(names[0]), names[0])
+			}
+		}
+	}
+	for _, pair := range [][2]string{{"Kalyani", "Kamas"}, {"Todi", "Tilang"}, {"Hari", "Kari"}} {
+		if Key(pair[0]) == Key(pair[1]) {
+			t.Errorf("%s and %s share a key", pair[0], pair[1])
+		}
+	}
+}
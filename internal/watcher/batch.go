@@ -0,0 +1,426 @@
+package watcher
+
+import (
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"musicloud/config"
+	"musicloud/internal/catalog"
+	"musicloud/internal/ffmpeg"
+	"musicloud/internal/metadata"
+	"musicloud/internal/organizer"
+	"musicloud/internal/parser"
+	"musicloud/internal/recdate"
+	"musicloud/internal/review"
+	"musicloud/internal/zoom"
+)
+
+// ScanAndProcess scans the directory for media files and processes them using the provided uploader.
+func ScanAndProcess(dir string, uploader UploaderFunc) {
+	(&Batch{Dir: dir, Uploader: uploader}).Run()
+}
+
+// Item is a media file queued for upload together with what is known about it.
+type Item struct {
+	Path     string
+	Metadata metadata.Metadata
+	Sender   string      // chat sender the file came from, if any
+	Caption  string      // caption the file was shared with, if any
+	Role     config.Role // role of the sender, empty if unknown
+	Folder   string      // Drive folder under the upload folder, empty for the upload folder itself
+	// Original is the file the recording was found as, when Path is a
+	// converted or tagged copy made for the upload.
+	Original string
+}
+
+// ItemUploaderFunc uploads a processed item.
+type ItemUploaderFunc func(item Item) error
+
+// Batch is a single scan-and-upload run over a folder.
+type Batch struct {
+	Dir      string
+	Uploader UploaderFunc
+	// Upload, when set, is used instead of Uploader and receives the item's
+	// metadata and routing.
+	Upload ItemUploaderFunc
+	// State, when set, makes WhatsApp chat exports in Dir incremental: only
+	// attachments of messages added since the previous run are uploaded.
+	State *parser.State
+	// ChatOptions control how chat exports are read.
+	ChatOptions parser.Options
+	// ZoomPolicy chooses which track of a local Zoom recording is uploaded.
+	ZoomPolicy zoom.Policy
+	// Groups maps chat senders to people and roles.
+	Groups *config.Groups
+	// Overrides are metadata values given on the command line; they win over
+	// folder defaults, chat-derived values and sidecar files.
+	Overrides metadata.Metadata
+	// Review collects findings that need a person to look at them.
+	Review *review.Report
+	// Missing collects the recordings new chat messages refer to that are
+	// not part of the export.
+	Missing []parser.MissingMedia
+	// Confirm, when set, shows each item to a person who confirms or
+	// completes its metadata. ok false skips the item.
+	Confirm func(item Item) (meta metadata.Metadata, ok bool, err error)
+	// Cover is an image embedded as cover art in uploaded audio files.
+	Cover string
+	// Compositions fills in the raga, tala and composer of known songs.
+	Compositions *metadata.Compositions
+	// Dates finds when recordings without a given date were made. When nil,
+	// the default chain is used with ffprobe and ChatOptions.Location.
+	Dates *recdate.Resolver
+	// Layouts, when set, files each recording in folders named after its
+	// metadata, below the folder its sender's role is routed to.
+	Layouts *organizer.Layouts
+}
+
+// Run scans the folder and uploads every media file that has not been handled
+// by an earlier run of the same chat export.
+func (b *Batch) Run() {
+	files, err := os.ReadDir(b.Dir)
+	if err != nil {
+		log.Fatalf("Failed to read directory: %v", err)
+	}
+
+	skip := map[string]bool{}
+	if b.State != nil {
+		skip = b.processChats(files)
+	}
+	b.processZoom(files)
+
+	for _, entry := range files {
+		if entry.IsDir() || skip[entry.Name()] {
+			continue
+		}
+		filePath := filepath.Join(b.Dir, entry.Name())
+		if isMediaFile(filePath) {
+			log.Printf("Found media file: %s\n", filePath)
+			b.process(Item{Path: filePath, Metadata: metadata.Metadata{Source: metadata.SourceFolder}})
+		}
+	}
+}
+
+// processChats handles the chat exports found in the folder: WhatsApp .txt
+// exports next to their media, and Telegram Desktop exports (result.json)
+// in the folder or one of its subfolders. It uploads the attachments of new
+// messages and returns the names of every top-level file the chats
+// reference, so the plain media scan does not upload them again.
+func (b *Batch) processChats(files []os.DirEntry) map[string]bool {
+	handled := map[string]bool{}
+	for _, entry := range files {
+		path := filepath.Join(b.Dir, entry.Name())
+		var chat *parser.Chat
+		var err error
+		switch {
+		case entry.IsDir():
+			path = filepath.Join(path, "result.json")
+			if _, statErr := os.Stat(path); statErr != nil {
+				continue
+			}
+			chat, err = parser.ParseTelegramExport(path, b.ChatOptions)
+		case entry.Name() == "result.json":
+			chat, err = parser.ParseTelegramExport(path, b.ChatOptions)
+		case filepath.Ext(entry.Name()) == ".txt":
+			chat, err = parser.ParseChatWith(path, b.ChatOptions)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("Skipping chat export %s: %v\n", path, err)
+			continue
+		}
+		b.processChat(chat, handled)
+	}
+	if err := b.State.Save(); err != nil {
+		log.Printf("Error saving chat state: %s\n", err)
+	}
+	return handled
+}
+
+// processChat uploads the attachments of the messages added to chat since
+// the previous run.
+func (b *Batch) processChat(chat *parser.Chat, handled map[string]bool) {
+	if len(chat.Messages) == 0 {
+		return
+	}
+	exportDir := filepath.Dir(chat.Path)
+	for _, m := range parser.Attachments(chat.Messages) {
+		if filepath.Join(exportDir, m.Attachment) == filepath.Join(b.Dir, filepath.Base(m.Attachment)) {
+			handled[filepath.Base(m.Attachment)] = true
+		}
+	}
+	delta := b.State.Delta(chat)
+	for _, c := range delta.Changes {
+		log.Printf("Chat %q: %s (not reprocessed)\n", chat.Name, c)
+	}
+
+	present := func(name string) bool {
+		_, err := os.Stat(filepath.Join(exportDir, name))
+		return err == nil
+	}
+	b.Missing = append(b.Missing, parser.MissingRecordings(chat.Title, delta.New, present)...)
+
+	group := b.Groups.ForChat(chat.Titles...)
+	if group == nil && b.Groups != nil {
+		b.Review.Add("Unknown group", chat.Title, "no group configured for this chat title", chat.Path)
+	}
+	failed := false
+	for i, m := range delta.New {
+		if m.Attachment == "" {
+			continue
+		}
+		mediaPath := filepath.Join(exportDir, m.Attachment)
+		if !isMediaFile(mediaPath) {
+			continue
+		}
+		if !present(m.Attachment) {
+			log.Printf("Attachment %s from chat %q is missing from the export\n", m.Attachment, chat.Name)
+			continue
+		}
+		if b.State.Done(m.Key()) {
+			continue // uploaded by a run that had other uploads fail
+		}
+		log.Printf("Found new attachment in chat %q: %s\n", chat.Name, mediaPath)
+		item := b.chatItem(chat, group, m, mediaPath)
+		item.Caption = parser.Caption(delta.New, i)
+		if err := b.process(item); err != nil {
+			failed = true
+			continue
+		}
+		b.State.MarkDone(m.Key())
+	}
+	if failed {
+		log.Printf("Chat %q had failed uploads; it will be retried on the next run\n", chat.Name)
+		return
+	}
+	b.State.Commit(chat)
+}
+
+// processZoom uploads one recording per local Zoom recording folder found in
+// the folder, as a virtual session.
+func (b *Batch) processZoom(files []os.DirEntry) {
+	loc := b.ChatOptions.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	for _, entry := range files {
+		if !entry.IsDir() {
+			continue
+		}
+		meeting, ok, err := zoom.Open(filepath.Join(b.Dir, entry.Name()), loc)
+		if err != nil {
+			log.Printf("Skipping Zoom recording %s: %v\n", entry.Name(), err)
+			continue
+		}
+		if !ok || (b.State != nil && b.State.Done(meeting.Key())) {
+			continue
+		}
+		recording := meeting.Recording(b.ZoomPolicy)
+		log.Printf("Found Zoom recording %q: %s\n", meeting.Topic, recording)
+
+		item := Item{Path: recording, Metadata: meeting.Metadata(), Caption: meeting.Topic}
+		if group := b.Groups.ForChat(meeting.Topic); group != nil {
+			item.Metadata.GroupName = group.Name
+		}
+		if err := b.process(item); err != nil {
+			continue
+		}
+		if b.State != nil {
+			b.State.MarkDone(meeting.Key())
+		}
+	}
+	if b.State != nil {
+		if err := b.State.Save(); err != nil {
+			log.Printf("Error saving processing state: %s\n", err)
+		}
+	}
+}
+
+// chatItem builds the upload item for a chat attachment, filling in who sent
+// it and where it should go according to the group configuration.
+func (b *Batch) chatItem(chat *parser.Chat, group *config.Group, m parser.Message, mediaPath string) Item {
+	item := Item{Path: mediaPath, Sender: m.Sender, Metadata: parser.MessageMetadata(chat, m)}
+	if group == nil {
+		return item
+	}
+	item.Metadata.GroupName = group.Name
+	person, ok := group.ResolveSender(m.Sender)
+	if !ok && m.SenderID != "" {
+		person, ok = group.ResolveSender(m.SenderID)
+	}
+	if !ok {
+		b.Review.Add("Unknown sender", m.Sender, "sent "+m.Attachment, chat.Name)
+		return item
+	}
+	item.Role = person.Role
+	item.Folder = group.Route(person.Role)
+	if person.Role == config.RoleTeacher {
+		item.Metadata.Teacher = person.Name
+	}
+	return item
+}
+
+// resolveMetadata merges what is known about an item, from least to most
+// specific: tags embedded in the file, musicloud.yaml folder defaults, values
+// derived from the source (chat sender, group title, Zoom folder), the file's
+// sidecar, and command line overrides. A recording date given in any of
+// them other than the source is kept; otherwise it is taken from the date
+// chain, which starts with the chat message time.
+func (b *Batch) resolveMetadata(item Item) (metadata.Metadata, error) {
+	folder, err := metadata.FolderDefaults(item.Path, b.Dir)
+	if err != nil {
+		return metadata.Metadata{}, err
+	}
+	embedded, err := metadata.Embedded(item.Path)
+	if err != nil {
+		// unreadable tags are not a reason to hold the recording back
+		log.Printf("Ignoring embedded tags: %s\n", err)
+	}
+	sidecar, _, err := metadata.Sidecar(item.Path)
+	if err != nil {
+		return metadata.Metadata{}, err
+	}
+	derived := item.Metadata
+	derived.RecordedAt, derived.DateSource = time.Time{}, ""
+	meta := metadata.Merge(embedded, folder, derived, sidecar, b.Overrides)
+	if !meta.RecordedAt.IsZero() {
+		if meta.DateSource == "" {
+			meta.DateSource = metadata.DateFromMetadata
+		}
+		return meta, nil
+	}
+	var message time.Time
+	if item.Metadata.Source == metadata.SourceWhatsApp || item.Metadata.Source == metadata.SourceTelegram {
+		message = item.Metadata.RecordedAt
+	}
+	meta.RecordedAt, meta.DateSource = b.dates().Date(item.Path, message)
+	return meta, nil
+}
+
+func (b *Batch) dates() *recdate.Resolver {
+	if b.Dates == nil {
+		b.Dates = &recdate.Resolver{Location: b.ChatOptions.Location, Probe: ffmpeg.CreationTime}
+	}
+	return b.Dates
+}
+
+// canonicalize completes an item's metadata from the composition catalog and
+// replaces raga and tala names with their catalog names. Conflicts with the
+// composition catalog, unknown names and fuzzy corrections are listed for
+// review.
+func (b *Batch) canonicalize(item *Item) {
+	filled, conflicts := b.Compositions.Fill(item.Metadata)
+	item.Metadata = filled
+	for _, c := range conflicts {
+		b.Review.Add("Composition conflict", c.Song, c.String(), item.Path)
+	}
+
+	ragas, unknown, corrected := catalog.CanonicalRagas(item.Metadata.Ragas)
+	item.Metadata.Ragas = ragas
+	for _, r := range unknown {
+		b.Review.Add("Unknown raga", r, "not in the raga catalog", item.Path)
+	}
+	for _, c := range corrected {
+		log.Printf("Raga corrected in %s: %s\n", item.Path, c)
+		b.Review.Add("Raga name corrected", c, "closest name in the raga catalog", item.Path)
+	}
+	talas, unknown := catalog.CanonicalTalas(item.Metadata.Talas)
+	item.Metadata.Talas = talas
+	for _, t := range unknown {
+		b.Review.Add("Unknown tala", t, "not a suladi or chapu tala", item.Path)
+	}
+}
+
+// process converts a single media file when FFmpeg is available and uploads it.
+func (b *Batch) process(item Item) error {
+	meta, err := b.resolveMetadata(item)
+	if err != nil {
+		log.Printf("Invalid metadata for %s: %s\n", item.Path, err)
+		b.Review.Add("Invalid metadata", item.Path, err.Error(), "")
+		return err
+	}
+	item.Metadata = meta
+	b.canonicalize(&item)
+
+	if b.Confirm != nil {
+		meta, ok, err := b.Confirm(item)
+		if err != nil {
+			log.Printf("Error asking for metadata of %s: %s\n", item.Path, err)
+			return err
+		}
+		if !ok {
+			log.Printf("Skipped: %s\n", item.Path)
+			return nil
+		}
+		switch {
+		case meta.RecordedAt.IsZero():
+			meta.RecordedAt, meta.DateSource = item.Metadata.RecordedAt, item.Metadata.DateSource
+		case !meta.RecordedAt.Equal(item.Metadata.RecordedAt):
+			meta.DateSource = metadata.DateFromMetadata
+		}
+		item.Metadata = meta
+		b.canonicalize(&item)
+	}
+
+	folder, err := b.Layouts.Folder(item.Metadata)
+	if err != nil {
+		log.Printf("Unable to place %s: %s\n", item.Path, err)
+		b.Review.Add("Folder layout failed", item.Path, err.Error(), "")
+		return err
+	}
+	item.Folder = path.Join(item.Folder, folder)
+
+	ffmpegAvailable, _ := ffmpeg.IsFFmpegInstalled()
+	if !ffmpegAvailable {
+		log.Printf("FFmpeg not found in environment. Skipping audio conversion step for this file.")
+	}
+
+	inputFile := item.Path
+	outputFile := inputFile
+	if ffmpegAvailable {
+		tagging := ffmpeg.Tagging{Metadata: item.Metadata, Date: item.Metadata.RecordedAt}
+		if parser.MediaKind(inputFile) == "audio" {
+			tagging.Cover = b.Cover
+		}
+		if ffmpeg.CanCopy(inputFile) {
+			// Tag a copy, keeping the file name; the original stays untouched.
+			tmp, err := os.MkdirTemp("", "musicloud-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tmp)
+			tagged := filepath.Join(tmp, filepath.Base(inputFile))
+			if err := ffmpeg.WriteTags(inputFile, tagged, tagging); err != nil {
+				log.Printf("Error writing tags, uploading the file untagged: %s\n", err)
+			} else {
+				outputFile = tagged
+			}
+		} else {
+			outputFile = ffmpeg.GetOutputFilePath(inputFile)
+			err := ffmpeg.ConvertWithTags(inputFile, outputFile, tagging)
+			if err != nil {
+				log.Printf("Error converting file to MP4: %s\n", err)
+				return err
+			}
+		}
+	}
+	item.Original, item.Path = item.Path, outputFile
+
+	if b.Upload != nil {
+		err = b.Upload(item)
+	} else {
+		err = b.Uploader(outputFile, "")
+	}
+	if err != nil {
+		log.Printf("Error uploading file to Google Drive: %s\n", err)
+		return err
+	}
+
+	// Organizer and metadata can be added here if needed
+	log.Printf("Processed and uploaded: %s\n", outputFile)
+	return nil
+}
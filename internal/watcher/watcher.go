@@ -3,20 +3,24 @@ package watcher
 import (
 	"fmt"
 	"log"
-	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"musicloud/internal/drive"
 	"musicloud/internal/ffmpeg"
 	"musicloud/internal/metadata"
 	"musicloud/internal/organizer"
+	"musicloud/internal/recdate"
 )
 
 type Watcher struct {
 	watcher  *fsnotify.Watcher
 	dir      string
 	metadata metadata.Metadata
+	// Organizer, when set, files new recordings in their session folder
+	// below its upload folder.
+	Organizer *organizer.Organizer
 }
 
 func NewWatcher(dir string) (*Watcher, error) {
@@ -68,7 +72,7 @@ func (w *Watcher) Start() {
 func isMediaFile(filePath string) bool {
 	ext := filepath.Ext(filePath)
 	switch ext {
-	case ".mp3", ".wav", ".m4a", ".aac", ".ogg", ".flac", ".mp4", ".mov", ".avi", ".mkv":
+	case ".mp3", ".opus", ".wav", ".m4a", ".aac", ".ogg", ".flac", ".mp4", ".mov", ".avi", ".mkv":
 		return true
 	default:
 		return false
@@ -98,13 +102,27 @@ func (w *Watcher) handleNewFile(filePath string) {
 		}
 	}
 
-	err := drive.UploadFile(outputFile, "")
+	if w.Organizer == nil {
+		if err := drive.UploadFile(outputFile, "root"); err != nil {
+			log.Printf("Error uploading file to Google Drive: %s\n", err)
+			return
+		}
+		log.Printf("Uploaded without organizing: %s\n", outputFile)
+		return
+	}
+
+	meta := w.metadata
+	meta.RecordedAt, meta.DateSource = (&recdate.Resolver{Probe: ffmpeg.CreationTime}).Date(inputFile, time.Time{})
+	if d, err := ffmpeg.Duration(outputFile); err == nil {
+		meta.Duration = metadata.Duration(d)
+	}
+	fileID, err := drive.UploadFileWithMetadata(outputFile, w.Organizer.Root, &meta)
 	if err != nil {
 		log.Printf("Error uploading file to Google Drive: %s\n", err)
 		return
 	}
 
-	err = organizer.OrganizeFiles(nil, outputFile, organizer.Metadata(w.metadata))
+	_, err = w.Organizer.Organize(fileID, "", meta)
 	if err != nil {
 		log.Printf("Error organizing file: %s\n", err)
 		return
@@ -122,51 +140,5 @@ func (w *Watcher) Close() {
 // This allows for dependency injection in tests.
 type UploaderFunc func(filePath, folderID string) error
 
-// ScanAndProcess scans the directory for media files and processes them using the provided uploader.
-func ScanAndProcess(dir string, uploader UploaderFunc) {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		log.Fatalf("Failed to read directory: %v", err)
-	}
-	for _, entry := range files {
-		if entry.IsDir() {
-			continue
-		}
-		filePath := filepath.Join(dir, entry.Name())
-		if isMediaFile(filePath) {
-			log.Printf("Found media file: %s\n", filePath)
-			processMediaFile(filePath, uploader)
-		}
-	}
-}
-
-// processMediaFile processes a single media file using the provided uploader.
-func processMediaFile(filePath string, uploader UploaderFunc) {
-	ffmpegAvailable, _ := ffmpeg.IsFFmpegInstalled()
-	if !ffmpegAvailable {
-		log.Printf("FFmpeg not found in environment. Skipping audio conversion step for this file.")
-	}
-
-	inputFile := filePath
-	outputFile := inputFile
-	if ffmpegAvailable && filepath.Ext(inputFile) != ".mp4" {
-		outputFile = ffmpeg.GetOutputFilePath(inputFile)
-		err := ffmpeg.ConvertToMP4(inputFile, outputFile)
-		if err != nil {
-			log.Printf("Error converting file to MP4: %s\n", err)
-			return
-		}
-	}
-
-	err := uploader(outputFile, "")
-	if err != nil {
-		log.Printf("Error uploading file to Google Drive: %s\n", err)
-		return
-	}
-
-	// Organizer and metadata can be added here if needed
-	log.Printf("Processed and uploaded: %s\n", outputFile)
-}
-
 // For production use, call ScanAndProcess with drive.UploadFile as the uploader.
-// watcher.ScanAndProcess(dir, drive.UploadFile)
\ No newline at end of file
+// watcher.ScanAndProcess(dir, drive.UploadFile)
@@ -1,8 +1,22 @@
 package watcher
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"musicloud/config"
+	"musicloud/internal/metadata"
+	"musicloud/internal/organizer"
+	"musicloud/internal/parser"
+	"musicloud/internal/recdate"
+	"musicloud/internal/review"
+	"musicloud/internal/zoom"
 )
 
 func TestNewWatcher_InvalidDir(t *testing.T) {
@@ -51,3 +65,310 @@ func TestScanAndProcess_SingleMediaFile(t *testing.T) {
 		t.Errorf("expected %s to be uploaded, got %s", mediaFile, uploaded)
 	}
 }
+
+func TestBatch_ChatExportIsIncremental(t *testing.T) {
+	dir := t.TempDir()
+	chatFile := filepath.Join(dir, "WhatsApp Chat with Veena Class.txt")
+	os.WriteFile(chatFile, []byte("15/01/2024, 18:30 - Lakshmi: VID-20240105-WA0001.mp4 (file attached)\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "VID-20240105-WA0001.mp4"), []byte("dummy video"), 0644)
+
+	state, err := parser.LoadState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var uploaded []string
+	batch := &Batch{Dir: dir, State: state, Uploader: func(filePath, folderID string) error {
+		uploaded = append(uploaded, filePath)
+		return nil
+	}}
+
+	batch.Run()
+	if len(uploaded) != 1 {
+		t.Fatalf("expected 1 upload on the first run, got %v", uploaded)
+	}
+
+	uploaded = nil
+	batch.Run()
+	if len(uploaded) != 0 {
+		t.Errorf("expected nothing to be uploaded again, got %v", uploaded)
+	}
+}
+
+func TestBatch_RetriesOnlyFailedAttachments(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "WhatsApp Chat with Veena Class.txt"), []byte(
+		"15/01/2024, 18:30 - Lakshmi: VID-20240105-WA0001.mp4 (file attached)\n"+
+			"15/01/2024, 19:30 - Lakshmi: VID-20240105-WA0002.mp4 (file attached)\n"), 0644)
+	for _, name := range []string{"VID-20240105-WA0001.mp4", "VID-20240105-WA0002.mp4"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("dummy video"), 0644)
+	}
+	state, _ := parser.LoadState(filepath.Join(dir, "state.json"))
+	var uploaded []string
+	failing := "VID-20240105-WA0002.mp4"
+	batch := &Batch{Dir: dir, State: state, Uploader: func(filePath, folderID string) error {
+		if filepath.Base(filePath) == failing {
+			return errors.New("quota exceeded")
+		}
+		uploaded = append(uploaded, filepath.Base(filePath))
+		return nil
+	}}
+
+	batch.Run()
+	failing = ""
+	batch.Run()
+	batch.Run()
+	if strings.Join(uploaded, ",") != "VID-20240105-WA0001.mp4,VID-20240105-WA0002.mp4" {
+		t.Errorf("expected each attachment uploaded once, got %v", uploaded)
+	}
+	if len(state.Processed) != 0 {
+		t.Errorf("expected the committed chat to need no per-message marks, got %v", state.Processed)
+	}
+}
+
+func TestBatch_SenderMapping(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "WhatsApp Chat with Veena Class.txt"), []byte(
+		"15/01/2024, 18:30 - Lakshmi Ma'am: VID-20240105-WA0001.mp4 (file attached)\n"+
+			"15/01/2024, 19:30 - Ravi: VID-20240105-WA0002.mp4 (file attached)\n"+
+			"15/01/2024, 19:45 - Stranger: VID-20240105-WA0003.mp4 (file attached)\n"), 0644)
+	for _, name := range []string{"VID-20240105-WA0001.mp4", "VID-20240105-WA0002.mp4", "VID-20240105-WA0003.mp4"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("dummy video"), 0644)
+	}
+	groups := &config.Groups{Groups: []config.Group{{
+		Name:   "Veena Class",
+		Routes: map[config.Role]string{config.RoleStudent: "Student Practice"},
+		People: []config.Person{
+			{Name: "Lakshmi Raman", Role: config.RoleTeacher, Senders: []string{"Lakshmi Ma'am"}},
+			{Name: "Ravi", Role: config.RoleStudent},
+		},
+	}}}
+	state, _ := parser.LoadState(filepath.Join(dir, "state.json"))
+	report := &review.Report{}
+	items := map[string]Item{}
+	batch := &Batch{Dir: dir, State: state, Groups: groups, Review: report, Upload: func(item Item) error {
+		items[filepath.Base(item.Path)] = item
+		return nil
+	}}
+	batch.Run()
+
+	if got := items["VID-20240105-WA0001.mp4"]; got.Metadata.Teacher != "Lakshmi Raman" || got.Folder != "" {
+		t.Errorf("unexpected teacher item: %+v", got)
+	}
+	if got := items["VID-20240105-WA0002.mp4"]; got.Metadata.Teacher != "" || got.Folder != "Student Practice" {
+		t.Errorf("unexpected student item: %+v", got)
+	}
+	if len(report.Entries) != 1 || report.Entries[0].Subject != "Stranger" {
+		t.Errorf("expected unknown sender in review report, got %+v", report.Entries)
+	}
+}
+
+func TestBatch_TelegramExport(t *testing.T) {
+	dir := t.TempDir()
+	exportDir := filepath.Join(dir, "ChatExport_2024-01-15")
+	os.MkdirAll(filepath.Join(exportDir, "video_files"), 0755)
+	os.WriteFile(filepath.Join(exportDir, "result.json"), []byte(`{"name": "Veena Class", "messages": [
+  {"id": 1, "type": "message", "date": "2024-01-15T18:30:12", "from": "Lakshmi", "from_id": "user42", "file": "video_files/class.mp4", "media_type": "video_file", "text": "Vatapi Ganapatim"}
+]}`), 0644)
+	os.WriteFile(filepath.Join(exportDir, "video_files", "class.mp4"), []byte("dummy video"), 0644)
+
+	groups := &config.Groups{Groups: []config.Group{{
+		Name:   "Veena Class",
+		People: []config.Person{{Name: "Lakshmi Raman", Role: config.RoleTeacher, Senders: []string{"user42"}}},
+	}}}
+	state, _ := parser.LoadState(filepath.Join(dir, "state.json"))
+	var items []Item
+	batch := &Batch{Dir: dir, State: state, Groups: groups, Upload: func(item Item) error {
+		items = append(items, item)
+		return nil
+	}}
+	batch.Run()
+	batch.Run()
+
+	if len(items) != 1 {
+		t.Fatalf("expected one upload across two runs, got %+v", items)
+	}
+	if items[0].Metadata.Teacher != "Lakshmi Raman" || items[0].Caption != "Vatapi Ganapatim" {
+		t.Errorf("unexpected item: %+v", items[0])
+	}
+}
+
+func TestBatch_ZoomRecording(t *testing.T) {
+	dir := t.TempDir()
+	meetingDir := filepath.Join(dir, "2024-01-05 18.30.12 Carnatic Class 81234567890")
+	os.MkdirAll(meetingDir, 0755)
+	os.WriteFile(filepath.Join(meetingDir, "audio_only.m4a"), []byte("dummy audio"), 0644)
+	os.WriteFile(filepath.Join(meetingDir, "video1234567890.mp4"), []byte("dummy video"), 0644)
+
+	state, _ := parser.LoadState(filepath.Join(dir, "state.json"))
+	var items []Item
+	batch := &Batch{Dir: dir, State: state, ZoomPolicy: zoom.PreferVideo, Upload: func(item Item) error {
+		items = append(items, item)
+		return nil
+	}}
+	batch.Run()
+	batch.Run()
+
+	if len(items) != 1 {
+		t.Fatalf("expected one upload per meeting, got %+v", items)
+	}
+	got := items[0]
+	if filepath.Base(got.Path) != "video1234567890.mp4" || got.Metadata.SessionType != "virtual" || got.Metadata.GroupName != "Carnatic Class" {
+		t.Errorf("unexpected item: %+v", got)
+	}
+	if got.Metadata.RecordedAt.Hour() != 18 || got.Metadata.RecordedAt.Day() != 5 || got.Metadata.Source != metadata.SourceZoom {
+		t.Errorf("unexpected start time %v", got.Metadata.RecordedAt)
+	}
+}
+
+func TestBatch_SidecarMetadata(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "musicloud.yaml"), []byte("group: Veena Class\nsession_type: in-person\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "class.mp4"), []byte("dummy video"), 0644)
+	os.WriteFile(filepath.Join(dir, "class.mp4.yaml"), []byte("songs: [Vatapi Ganapatim]\nteacher: Lakshmi\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.mp4"), []byte("dummy video"), 0644)
+	os.WriteFile(filepath.Join(dir, "broken.mp4.yaml"), []byte("tempo: fast\n"), 0644)
+
+	report := &review.Report{}
+	var items []Item
+	batch := &Batch{Dir: dir, Review: report, Overrides: metadata.Metadata{Teacher: "Lakshmi Raman"}, Upload: func(item Item) error {
+		items = append(items, item)
+		return nil
+	}}
+	batch.Run()
+
+	if len(items) != 1 {
+		t.Fatalf("expected only the file with valid metadata to be uploaded, got %+v", items)
+	}
+	got := items[0].Metadata
+	if got.GroupName != "Veena Class" || got.SessionType != "in-person" || got.Teacher != "Lakshmi Raman" || len(got.SongsTaught) != 1 {
+		t.Errorf("unexpected metadata: %+v", got)
+	}
+	if len(report.Entries) != 1 || !strings.Contains(report.Entries[0].Detail, "broken.mp4.yaml:1") {
+		t.Errorf("expected invalid sidecar in review report, got %+v", report.Entries)
+	}
+}
+
+func TestBatch_EmbeddedTagsRankBelowFolderDefaults(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "musicloud.yaml"), []byte("teacher: Lakshmi Raman\n"), 0644)
+	// An ID3v2.3 tag whose only frame is the recorder's artist.
+	frame := append([]byte("TPE1\x00\x00\x00\x09\x00\x00\x00"), "ZOOM H1n"...)
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(frame))}, frame...)
+	os.WriteFile(filepath.Join(dir, "class.mp3"), append(tag, "dummy audio"...), 0644)
+
+	var items []Item
+	batch := &Batch{Dir: dir, Upload: func(item Item) error {
+		items = append(items, item)
+		return nil
+	}}
+	batch.Run()
+
+	if len(items) != 1 || items[0].Metadata.Teacher != "Lakshmi Raman" {
+		t.Errorf("expected the configured teacher over the embedded artist, got %+v", items)
+	}
+}
+
+func TestBatch_Confirm(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "keep.mp4"), []byte("dummy video"), 0644)
+	os.WriteFile(filepath.Join(dir, "skip.mp4"), []byte("dummy video"), 0644)
+
+	report := &review.Report{}
+	var items []Item
+	batch := &Batch{Dir: dir, Upload: func(item Item) error {
+		items = append(items, item)
+		return nil
+	}, Confirm: func(item Item) (metadata.Metadata, bool, error) {
+		if filepath.Base(item.Path) == "skip.mp4" {
+			return metadata.Metadata{}, false, nil
+		}
+		return metadata.Metadata{Ragas: []string{"mohana", "Shankarabharanam", "Madhyamavathi", "Zzyzx"}}, true, nil
+	}, Review: report}
+	batch.Run()
+
+	if len(items) != 1 || filepath.Base(items[0].Path) != "keep.mp4" {
+		t.Fatalf("expected only the confirmed file, got %+v", items)
+	}
+	want := []string{"Mohanam", "Sankarabharanam", "Madhyamavati", "Zzyzx"}
+	if !reflect.DeepEqual(items[0].Metadata.Ragas, want) {
+		t.Errorf("expected canonical ragas %v, got %v", want, items[0].Metadata.Ragas)
+	}
+	if len(report.Entries) != 1 || report.Entries[0].Subject != "Zzyzx" {
+		t.Errorf("expected the unknown raga in the review report, got %+v", report.Entries)
+	}
+}
+
+func TestBatch_RecordingDate(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "WhatsApp Chat with Veena Class.txt"), []byte("15/01/2024, 18:30 - Lakshmi: VID-20240105-WA0001.mp4 (file attached)\n"), 0644)
+	files := []string{"VID-20240105-WA0001.mp4", "AUD-20240301-WA0007.opus", "phone.m4a", "lesson.mp3", "dated.mp3"}
+	for _, name := range files {
+		os.WriteFile(filepath.Join(dir, name), []byte("dummy"), 0644)
+	}
+	os.WriteFile(filepath.Join(dir, "dated.mp3.yaml"), []byte("recorded_at: 2023-12-24 10:00\n"), 0644)
+	mtime := time.Date(2024, 2, 2, 9, 0, 0, 0, time.Local)
+	os.Chtimes(filepath.Join(dir, "lesson.mp3"), mtime, mtime)
+
+	state, _ := parser.LoadState(filepath.Join(dir, "state.json"))
+	items := map[string]Item{}
+	probe := func(path string) (time.Time, error) {
+		if filepath.Base(path) == "phone.m4a" {
+			return time.Date(2024, 4, 4, 12, 0, 0, 0, time.UTC), nil
+		}
+		return time.Time{}, fmt.Errorf("no creation time")
+	}
+	batch := &Batch{Dir: dir, State: state, Dates: &recdate.Resolver{Location: time.Local, Probe: probe}, Upload: func(item Item) error {
+		items[filepath.Base(item.Path)] = item
+		return nil
+	}}
+	batch.Run()
+
+	want := map[string]struct{ date, source string }{
+		"VID-20240105-WA0001.mp4":  {"2024-01-15", metadata.DateFromChat},
+		"AUD-20240301-WA0007.opus": {"2024-03-01", metadata.DateFromFileName},
+		"phone.m4a":                {"2024-04-04", metadata.DateFromContainer},
+		"lesson.mp3":               {"2024-02-02", metadata.DateFromModTime},
+		"dated.mp3":                {"2023-12-24", metadata.DateFromMetadata},
+	}
+	for name, w := range want {
+		got := items[name].Metadata
+		if got.RecordedAt.Format("2006-01-02") != w.date || got.DateSource != w.source {
+			t.Errorf("%s: got %v from %q, want %s from %q", name, got.RecordedAt, got.DateSource, w.date, w.source)
+		}
+	}
+}
+
+func TestBatch_FolderLayout(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "WhatsApp Chat with Veena Class.txt"), []byte(
+		"15/03/2024, 18:30 - Lakshmi: AUD-20240315-WA0001.opus (file attached)\n"+
+			"16/03/2024, 07:10 - Ravi: AUD-20240316-WA0002.opus (file attached)\n"), 0644)
+	for _, name := range []string{"AUD-20240315-WA0001.opus", "AUD-20240316-WA0002.opus"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("dummy audio"), 0644)
+	}
+	groups := &config.Groups{Groups: []config.Group{{
+		Name:   "Veena Class",
+		Layout: "{{.Year}}/{{.Date}} {{.SessionType}}",
+		Routes: map[config.Role]string{config.RoleStudent: "Student Practice"},
+		People: []config.Person{{Name: "Lakshmi", Role: config.RoleTeacher}, {Name: "Ravi", Role: config.RoleStudent}},
+	}}}
+	layouts, err := organizer.NewLayouts(groups, "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state, _ := parser.LoadState(filepath.Join(dir, "state.json"))
+	folders := map[string]string{}
+	batch := &Batch{Dir: dir, State: state, Groups: groups, Layouts: layouts, Overrides: metadata.Metadata{SessionType: "in-person"}, Upload: func(item Item) error {
+		folders[filepath.Base(item.Path)] = item.Folder
+		return nil
+	}}
+	batch.Run()
+
+	want := map[string]string{
+		"AUD-20240315-WA0001.opus": "2024/2024-03-15 in-person",
+		"AUD-20240316-WA0002.opus": "Student Practice/2024/2024-03-16 in-person",
+	}
+	if !reflect.DeepEqual(folders, want) {
+		t.Errorf("got folders %v, want %v", folders, want)
+	}
+}
@@ -0,0 +1,133 @@
+// Package zoom reads the folders Zoom saves local recordings in, such as
+// "2024-03-05 18.30.12 Veena Class 81234567890": the meeting's start, topic
+// and ID, and which of its files to upload.
+package zoom
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"musicloud/internal/metadata"
+)
+
+// Policy chooses which track of a meeting is uploaded.
+type Policy string
+
+const (
+	PreferAudio Policy = "audio" // audio_only.m4a, falling back to the video
+	PreferVideo Policy = "video" // the video recording, falling back to audio_only.m4a
+)
+
+// ParsePolicy parses a policy name; an empty name means PreferAudio.
+func ParsePolicy(s string) (Policy, error) {
+	switch Policy(strings.ToLower(strings.TrimSpace(s))) {
+	case "", PreferAudio:
+		return PreferAudio, nil
+	case PreferVideo:
+		return PreferVideo, nil
+	}
+	return "", fmt.Errorf("unknown Zoom recording policy %q (use audio or video)", s)
+}
+
+// Meeting is a local Zoom recording folder.
+type Meeting struct {
+	Dir       string
+	Topic     string
+	MeetingID string
+	Start     time.Time
+	Audio     string // path of the audio-only track, if recorded
+	Video     string // path of the video track, if recorded
+}
+
+// Metadata is what the recording folder says about the meeting: a virtual
+// session named after its topic, recorded when the meeting started.
+func (m Meeting) Metadata() metadata.Metadata {
+	return metadata.Metadata{
+		GroupName:   m.Topic,
+		SessionType: "virtual",
+		Source:      metadata.SourceZoom,
+		RecordedAt:  m.Start,
+	}
+}
+
+// folderName matches "2024-01-05 18.30.12 Carnatic Class 81234567890". The
+// meeting ID is missing from folders of older clients and personal rooms.
+var folderName = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}\.\d{2}\.\d{2}) (.+?)(?: (\d{9,11}))?$`)
+
+// ParseFolderName recognises the folder name Zoom gives a local recording.
+func ParseFolderName(name string, loc *time.Location) (Meeting, bool) {
+	m := folderName.FindStringSubmatch(name)
+	if m == nil {
+		return Meeting{}, false
+	}
+	start, err := time.ParseInLocation("2006-01-02 15.04.05", m[1], loc)
+	if err != nil {
+		return Meeting{}, false
+	}
+	return Meeting{Topic: strings.TrimSpace(m[2]), MeetingID: m[3], Start: start}, true
+}
+
+// Open reads a Zoom recording folder and finds its tracks. ok is false when
+// dir is not a Zoom recording folder or holds no recording.
+func Open(dir string, loc *time.Location) (Meeting, bool, error) {
+	meeting, ok := ParseFolderName(filepath.Base(dir), loc)
+	if !ok {
+		return Meeting{}, false, nil
+	}
+	meeting.Dir = dir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Meeting{}, false, err
+	}
+	var videos, audios []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := strings.ToLower(e.Name())
+		path := filepath.Join(dir, e.Name())
+		switch {
+		case name == "audio_only.m4a":
+			meeting.Audio = path
+		case strings.HasPrefix(name, "audio") && filepath.Ext(name) == ".m4a":
+			audios = append(audios, path)
+		case filepath.Ext(name) == ".mp4":
+			videos = append(videos, path)
+		}
+	}
+	// Later clients name tracks audio1234567890.m4a and video1234567890.mp4;
+	// older ones write zoom_0.mp4. Take the first in name order.
+	sort.Strings(audios)
+	sort.Strings(videos)
+	if meeting.Audio == "" && len(audios) > 0 {
+		meeting.Audio = audios[0]
+	}
+	if len(videos) > 0 {
+		meeting.Video = videos[0]
+	}
+	if meeting.Audio == "" && meeting.Video == "" {
+		return Meeting{}, false, nil
+	}
+	return meeting, true, nil
+}
+
+// Recording returns the one file to upload for the meeting under policy.
+func (m Meeting) Recording(policy Policy) string {
+	if policy == PreferVideo && m.Video != "" {
+		return m.Video
+	}
+	if m.Audio != "" {
+		return m.Audio
+	}
+	return m.Video
+}
+
+// Key identifies the meeting in processing state.
+func (m Meeting) Key() string {
+	return "zoom:" + m.Start.Format("2006-01-02T15:04:05") + " " + m.Topic + " " + m.MeetingID
+}
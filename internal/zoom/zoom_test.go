@@ -0,0 +1,48 @@
+package zoom
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseFolderName(t *testing.T) {
+	m, ok := ParseFolderName("2024-01-05 18.30.12 Carnatic Class 81234567890", time.UTC)
+	if !ok {
+		t.Fatal("expected Zoom folder name to be recognised")
+	}
+	if m.Topic != "Carnatic Class" || m.MeetingID != "81234567890" || !m.Start.Equal(time.Date(2024, 1, 5, 18, 30, 12, 0, time.UTC)) {
+		t.Errorf("unexpected meeting: %+v", m)
+	}
+	if m, ok := ParseFolderName("2024-01-05 18.30.12 Personal Meeting Room", time.UTC); !ok || m.Topic != "Personal Meeting Room" || m.MeetingID != "" {
+		t.Errorf("unexpected meeting without ID: %+v", m)
+	}
+	if _, ok := ParseFolderName("Holiday photos", time.UTC); ok {
+		t.Error("expected ordinary folder not to be recognised")
+	}
+}
+
+func TestOpen_Policy(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "2024-01-05 18.30.12 Carnatic Class 81234567890")
+	os.MkdirAll(dir, 0755)
+	for _, name := range []string{"audio_only.m4a", "zoom_0.mp4", "playback.m3u", "chat.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+	m, ok, err := Open(dir, time.UTC)
+	if err != nil || !ok {
+		t.Fatalf("expected meeting, got %v %v", ok, err)
+	}
+	if got := m.Recording(PreferAudio); filepath.Base(got) != "audio_only.m4a" {
+		t.Errorf("expected audio track, got %s", got)
+	}
+	if got := m.Recording(PreferVideo); filepath.Base(got) != "zoom_0.mp4" {
+		t.Errorf("expected video track, got %s", got)
+	}
+
+	os.Remove(filepath.Join(dir, "zoom_0.mp4"))
+	m, _, _ = Open(dir, time.UTC)
+	if got := m.Recording(PreferVideo); filepath.Base(got) != "audio_only.m4a" {
+		t.Errorf("expected fallback to audio, got %s", got)
+	}
+}